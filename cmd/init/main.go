@@ -7,7 +7,9 @@ import (
 	"net/rpc"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -15,16 +17,44 @@ import (
 	"github.com/plasma-umass/systemgo/config"
 	"github.com/plasma-umass/systemgo/system"
 	"github.com/plasma-umass/systemgo/systemctl"
+	"github.com/plasma-umass/systemgo/unit/service"
 )
 
 // Initializes the system, sets the default paths, as specified in configuration and attempts to start the default target, falls back to "rescue.target", if it fails
 func main() {
+	// Processes of services are sandboxed by the re-executed manager
+	service.RunSandboxHelper()
+
 	go Serve()
 
 	// Initialize system
 	log.Info("Systemgo starting...")
 
 	sys.SetPaths(config.Paths...)
+	sys.SetDefaultTimeoutStart(config.TimeoutStart)
+	sys.SetBusAddress(config.BusAddress)
+
+	if config.Subreaper {
+		if err := sys.EnableSubreaper(); err != nil {
+			log.Errorf("Error becoming subreaper of orphaned processes: %s", err)
+		}
+	}
+
+	if err := sys.ListenNotify(config.NotifySocket); err != nil {
+		log.Errorf("Error listening for notifications on %s: %s", config.NotifySocket, err)
+	}
+
+	// Action requested by a unit, e.g. using StartLimitAction=. The hook is set before
+	// any unit is started, so that actions requested during boot are not lost
+	actions := make(chan string, 1)
+	sys.SetActionHook(func(action string, u *system.Unit) error {
+		select {
+		case actions <- action:
+		default:
+			log.Warnf("Another action is pending, %s requested by %s ignored", action, u.Name())
+		}
+		return nil
+	})
 
 	// Start the default target
 	if err := sys.Start(config.Target); err != nil {
@@ -38,10 +68,26 @@ func main() {
 		go printUnits()
 	}
 
-	exit := make(chan os.Signal)
+	exit := make(chan os.Signal, 1)
 	signal.Notify(exit, os.Interrupt, os.Kill)
-	<-exit
 
+	action := "exit"
+	select {
+	case <-exit:
+	case action = <-actions:
+	}
+
+	if !strings.HasSuffix(action, "-force") && !strings.HasSuffix(action, "-immediate") {
+		shutdown()
+	}
+
+	if err := perform(action); err != nil {
+		log.Fatalf("Error performing %s: %s", action, err)
+	}
+}
+
+// Stops all units and waits for them to finish
+func shutdown() {
 	log.Infoln("Shutting down...")
 	if err := sys.Isolate("shutdown.target"); err != nil {
 		log.Fatalf("Error shutting down: %s", err)
@@ -66,6 +112,29 @@ func main() {
 			}(u)
 		}
 	}
+	wg.Wait()
+}
+
+// Performs the final action - exits, reboots, powers off or halts the machine
+func perform(action string) error {
+	action = strings.TrimSuffix(strings.TrimSuffix(action, "-force"), "-immediate")
+
+	var cmd int
+	switch action {
+	case "exit":
+		os.Exit(0)
+	case "reboot":
+		cmd = syscall.LINUX_REBOOT_CMD_RESTART
+	case "poweroff":
+		cmd = syscall.LINUX_REBOOT_CMD_POWER_OFF
+	case "halt":
+		cmd = syscall.LINUX_REBOOT_CMD_HALT
+	default:
+		return system.ErrUnknownAction
+	}
+
+	syscall.Sync()
+	return syscall.Reboot(cmd)
 }
 
 // Instance of a system
@@ -7,6 +7,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/plasma-umass/systemgo/system"
+	"github.com/plasma-umass/systemgo/unit"
 	"github.com/spf13/viper"
 )
 
@@ -14,6 +15,10 @@ const (
 	DEFAULT_PORT   = 8008
 	DEFAULT_TARGET = "default.target"
 	RESCUE_TARGET  = "rescue.target"
+	DEFAULT_NOTIFY = "/run/systemgo/notify"
+	DEFAULT_BUS    = "unix:path=/run/dbus/system_bus_socket"
+
+	DEFAULT_TIMEOUT_START = "90s"
 )
 
 var (
@@ -26,6 +31,18 @@ var (
 	// Port for system daemon to listen on
 	Port port
 
+	// Path to the socket services send notifications to
+	NotifySocket string
+
+	// Address of the bus dbus services acquire their names on
+	BusAddress string
+
+	// Whether to reap the orphaned processes of services as their subreaper
+	Subreaper bool
+
+	// Time to wait for the start of services, which do not specify TimeoutStartSec, to complete
+	TimeoutStart time.Duration
+
 	// Retry specifies the period(in seconds) to wait before
 	// restarting the http service if it fails
 	Retry time.Duration
@@ -44,6 +61,10 @@ func init() {
 	viper.SetDefault("port", DEFAULT_PORT)
 	viper.SetDefault("target", DEFAULT_TARGET)
 	viper.SetDefault("paths", system.DEFAULT_PATHS)
+	viper.SetDefault("notify", DEFAULT_NOTIFY)
+	viper.SetDefault("bus", DEFAULT_BUS)
+	viper.SetDefault("subreaper", true)
+	viper.SetDefault("timeout-start", DEFAULT_TIMEOUT_START)
 	viper.SetDefault("retry", 1)
 	viper.SetDefault("debug", false)
 
@@ -77,6 +98,18 @@ func init() {
 	Target = viper.GetString("target")
 	Paths = viper.GetStringSlice("paths")
 	Port = port(viper.GetInt("port"))
+	NotifySocket = viper.GetString("notify")
+	BusAddress = viper.GetString("bus")
+	Subreaper = viper.GetBool("subreaper")
+
+	var err error
+	if TimeoutStart, err = unit.ParseTimespan(viper.GetString("timeout-start")); err != nil {
+		log.WithFields(log.Fields{
+			"timeout-start": viper.GetString("timeout-start"),
+			"err":           err,
+		}).Errorf("Parse error, using default")
+		TimeoutStart, _ = unit.ParseTimespan(DEFAULT_TIMEOUT_START)
+	}
 	Retry = viper.GetDuration("retry") * time.Second
 	Debug = viper.GetBool("debug")
 
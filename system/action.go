@@ -0,0 +1,45 @@
+package system
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// ActionHook performs action requested by unit u
+type ActionHook func(action string, u *Unit) error
+
+// SetActionHook sets the hook, which gets called each time a unit requests an action to be performed.
+// By default, actions other than "none" are only logged
+func (sys *Daemon) SetActionHook(hook ActionHook) {
+	sys.mutex.Lock()
+	defer sys.mutex.Unlock()
+
+	sys.actionHook = hook
+}
+
+// performAction performs action requested by u using the action hook set
+func (sys *Daemon) performAction(action string, u *Unit) (err error) {
+	log.WithFields(log.Fields{
+		"action": action,
+		"unit":   u.Name(),
+	}).Debugf("sys.performAction")
+
+	switch {
+	case action == "" || action == "none":
+		return nil
+	case !unit.Actions[action]:
+		return ErrUnknownAction
+	}
+
+	sys.Log.Warnf("%s requested %s", u.Name(), action)
+
+	sys.mutex.Lock()
+	hook := sys.actionHook
+	sys.mutex.Unlock()
+
+	if hook == nil {
+		sys.Log.Errorf("No action hook set, %s ignored", action)
+		return nil
+	}
+	return hook(action, u)
+}
@@ -40,8 +40,10 @@ type Daemon struct {
 	// System log
 	Log *Log
 
-	// Map of created units (name -> *Unit)
-	units map[string]*Unit
+	// Map of created units (name -> *Unit) and a lock protecting it, which is held
+	// for reading while units are looked up and for writing while they are added
+	units      map[string]*Unit
+	unitsMutex sync.RWMutex
 
 	// Paths, where the unit file specifications get searched for
 	paths []string
@@ -52,6 +54,30 @@ type Daemon struct {
 	// System starting time
 	since time.Time
 
+	// Path to the notification socket, empty if not listening
+	notifySocket string
+
+	// Default time to wait for the start of a service to complete
+	timeoutStart time.Duration
+
+	// Address of the bus the names of dbus services are watched on
+	busAddress string
+
+	// Hook performing actions requested by units
+	actionHook ActionHook
+
+	// Number of jobs running, of which nIdle wait for the others to complete,
+	// and a channel closed whenever the numbers change
+	nJobs, nIdle int
+	jobsch       chan struct{}
+	jobsMutex    sync.Mutex
+
+	// PIDs of the processes started by units, which are left for the units to wait for,
+	// and a lock held for reading while processes are started and for writing while reaping
+	tracked    map[int]bool
+	trackMutex sync.Mutex
+	spawnMutex sync.RWMutex
+
 	mutex sync.Mutex
 }
 
@@ -79,6 +105,24 @@ func (sys *Daemon) SetPaths(paths ...string) {
 	sys.paths = paths
 }
 
+// SetDefaultTimeoutStart sets the time to wait for the start of services, which
+// do not specify TimeoutStartSec, to complete. Only affects the units loaded afterwards
+func (sys *Daemon) SetDefaultTimeoutStart(timeout time.Duration) {
+	sys.mutex.Lock()
+	defer sys.mutex.Unlock()
+
+	sys.timeoutStart = timeout
+}
+
+// SetBusAddress sets the address of the bus, on which dbus services acquire
+// their BusName. Only affects the units loaded afterwards
+func (sys *Daemon) SetBusAddress(address string) {
+	sys.mutex.Lock()
+	defer sys.mutex.Unlock()
+
+	sys.busAddress = address
+}
+
 // Since returns time, when sys was created
 func (sys *Daemon) Since() (t time.Time) {
 	return sys.since
@@ -117,6 +161,16 @@ func (sys *Daemon) StatusOf(name string) (st unit.Status, err error) {
 	return u.Status(), nil
 }
 
+// PropertiesOf returns the properties of the unit with name specified
+func (sys *Daemon) PropertiesOf(name string) (props map[string]string, err error) {
+	var u *Unit
+	if u, err = sys.Get(name); err != nil {
+		return
+	}
+
+	return u.Properties(), nil
+}
+
 // Start gets names from internal hashmap, creates a new start transaction and runs it
 func (sys *Daemon) Start(names ...string) (err error) {
 	log.WithField("names", names).Debugf("sys.Start")
@@ -183,7 +237,32 @@ func (sys *Daemon) Reload(names ...string) (err error) {
 	return tr.Run()
 }
 
+// ReloadOrRestart gets names from internal hashmap, creates a new transaction reloading
+// the active units capable of reloading and restarting the rest and runs it
+func (sys *Daemon) ReloadOrRestart(names ...string) (err error) {
+	log.WithField("names", names).Debugf("sys.ReloadOrRestart")
+
+	var tr *transaction
+	if tr, err = sys.newTransactionFunc(reloadOrRestart, names); err != nil {
+		return
+	}
+	return tr.Run()
+}
+
+// reloadOrRestart returns the type of the job reload-or-restart runs for u
+func reloadOrRestart(u *Unit) jobType {
+	if u.IsReloader() && u.IsActive() {
+		return reload
+	}
+	return restart
+}
+
 func (sys *Daemon) newTransaction(typ jobType, names []string) (tr *transaction, err error) {
+	return sys.newTransactionFunc(func(*Unit) jobType { return typ }, names)
+}
+
+// newTransactionFunc is like newTransaction, but the type of the job for each unit is determined by typeOf
+func (sys *Daemon) newTransactionFunc(typeOf func(*Unit) jobType, names []string) (tr *transaction, err error) {
 	sys.mutex.Lock()
 	defer sys.mutex.Unlock()
 
@@ -195,13 +274,52 @@ func (sys *Daemon) newTransaction(typ jobType, names []string) (tr *transaction,
 			return nil, err
 		}
 
-		if err = tr.add(typ, dep, nil, true, true); err != nil {
+		if err = tr.add(typeOf(dep), dep, nil, true, true); err != nil {
 			return nil, err
 		}
 	}
 	return
 }
 
+// ResetFailed gets names from internal hashmap and calls ResetFailed() on each unit returned.
+// If no names are specified, ResetFailed() is called on all units
+func (sys *Daemon) ResetFailed(names ...string) (err error) {
+	log.WithField("names", names).Debugf("sys.ResetFailed")
+
+	if len(names) == 0 {
+		for _, u := range sys.Units() {
+			u.ResetFailed()
+		}
+		return nil
+	}
+
+	return sys.getAndExecute(names, func(u *Unit, gerr error) error {
+		if gerr != nil {
+			return gerr
+		}
+
+		u.ResetFailed()
+		return nil
+	})
+}
+
+// Clean gets names from internal hashmap and calls Clean() on each unit returned
+// with the kinds of directories specified
+func (sys *Daemon) Clean(what []string, names ...string) (err error) {
+	log.WithFields(log.Fields{
+		"names": names,
+		"what":  what,
+	}).Debugf("sys.Clean")
+
+	return sys.getAndExecute(names, func(u *Unit, gerr error) error {
+		if gerr != nil {
+			return gerr
+		}
+
+		return u.Clean(what...)
+	})
+}
+
 // Enable gets names from internal hasmap and calls Enable() on each unit returned
 func (sys *Daemon) Enable(names ...string) (err error) {
 	log.WithField("names", names).Debugf("sys.Enable")
@@ -241,10 +359,12 @@ func (sys *Daemon) getAndExecute(names []string, fn func(*Unit, error) error) (e
 func (sys *Daemon) Units() (units []*Unit) {
 	log.Debugf("sys.Units")
 
+	sys.unitsMutex.RLock()
 	unitSet := map[*Unit]struct{}{}
 	for _, u := range sys.units {
 		unitSet[u] = struct{}{}
 	}
+	sys.unitsMutex.RUnlock()
 
 	units = make([]*Unit, 0, len(unitSet))
 	for u := range unitSet {
@@ -258,6 +378,9 @@ func (sys *Daemon) Units() (units []*Unit) {
 func (sys *Daemon) Unit(name string) (u *Unit, err error) {
 	log.WithField("name", name).Debug("sys.Unit")
 
+	sys.unitsMutex.RLock()
+	defer sys.unitsMutex.RUnlock()
+
 	var ok bool
 	if u, ok = sys.units[name]; !ok {
 		return nil, ErrNotFound
@@ -265,6 +388,14 @@ func (sys *Daemon) Unit(name string) (u *Unit, err error) {
 	return
 }
 
+// setUnit associates name with u in the internal hashmap
+func (sys *Daemon) setUnit(name string, u *Unit) {
+	sys.unitsMutex.Lock()
+	defer sys.unitsMutex.Unlock()
+
+	sys.units[name] = u
+}
+
 // Get looks up the unit name in the internal hasmap of loaded units and calls
 // sys.Load(name) if it can not be found.
 // If error is returned, it will be error from sys.Load(name)
@@ -303,9 +434,13 @@ func (sys *Daemon) newUnit(name string, v unit.Interface) (u *Unit) {
 
 	u.System = sys
 
-	sys.units[name] = u
+	if r, ok := v.(unit.AutoRestarter); ok {
+		go u.autoRestart(r.AutoRestart())
+	}
+
+	sys.setUnit(name, u)
 	if strings.HasSuffix(name, ".service") {
-		sys.units[strings.TrimSuffix(name, ".service")] = u
+		sys.setUnit(strings.TrimSuffix(name, ".service"), u)
 	}
 
 	return
@@ -352,7 +487,13 @@ func (sys *Daemon) load(name string) (u *Unit, err error) {
 			case ".target":
 				v = &Target{System: sys}
 			case ".service":
-				v = &service.Unit{}
+				v = &service.Unit{
+					NotifySocket:           sys.notifySocket,
+					DefaultTimeoutStartSec: sys.timeoutStart,
+					IdleWait:               sys.waitIdle,
+					BusAddress:             sys.busAddress,
+					Supervisor:             sys,
+				}
 			default:
 				panic("Trying to load an unsupported unit type")
 			}
@@ -361,7 +502,7 @@ func (sys *Daemon) load(name string) (u *Unit, err error) {
 		}
 
 		u.path = path
-		sys.units[path] = u
+		sys.setUnit(path, u)
 
 		var info os.FileInfo
 		if info, err = file.Stat(); err == nil && info.IsDir() {
@@ -423,3 +564,43 @@ func pathset(path string) (definitions []string, err error) {
 
 	return
 }
+
+// changeJobs adds the deltas specified to the numbers of running and idle jobs
+func (sys *Daemon) changeJobs(jobs, idle int) {
+	sys.jobsMutex.Lock()
+	defer sys.jobsMutex.Unlock()
+
+	sys.nJobs += jobs
+	sys.nIdle += idle
+	if sys.jobsch != nil {
+		close(sys.jobsch)
+		sys.jobsch = nil
+	}
+}
+
+// waitIdle blocks until all running jobs, including the calling one, are waiting
+// for the others to complete or timeout elapses
+func (sys *Daemon) waitIdle(timeout time.Duration) {
+	sys.changeJobs(0, 1)
+	defer sys.changeJobs(0, -1)
+
+	deadline := time.After(timeout)
+	for {
+		sys.jobsMutex.Lock()
+		if sys.nJobs <= sys.nIdle {
+			sys.jobsMutex.Unlock()
+			return
+		}
+		if sys.jobsch == nil {
+			sys.jobsch = make(chan struct{})
+		}
+		ch := sys.jobsch
+		sys.jobsMutex.Unlock()
+
+		select {
+		case <-ch:
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -61,6 +62,26 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestUnitsConcurrent(t *testing.T) {
+	sys := New()
+
+	// Notifications are dispatched while units are being added
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			sys.notify(1, "READY=1")
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		_, err := sys.Supervise(strconv.Itoa(i)+".target", &Target{System: sys})
+		require.NoError(t, err)
+	}
+	<-done
+	assert.Len(t, sys.Units(), 100)
+}
+
 func TestSuported(t *testing.T) {
 	for suffix, is := range supported {
 		assert.Equal(t, is, Supported("foo"+suffix))
@@ -307,3 +328,34 @@ func emptyOne(m *mockUnit, method string) (c *gomock.Call) {
 	}
 	return c.Return([]string{})
 }
+
+func TestWaitIdle(t *testing.T) {
+	sys := New()
+
+	// Idle job and another one running
+	sys.changeJobs(2, 0)
+
+	done := make(chan struct{})
+	go func() {
+		sys.waitIdle(time.Minute)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("waitIdle returned while another job is running")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sys.changeJobs(-1, 0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waitIdle did not return once the other job completed")
+	}
+
+	begin := time.Now()
+	sys.changeJobs(1, 0)
+	sys.waitIdle(100 * time.Millisecond)
+	assert.True(t, time.Since(begin) < time.Second, "waitIdle did not time out")
+}
@@ -9,8 +9,14 @@ var ErrDepFail = errors.New("Dependency failed to start. See unit log for detail
 var ErrDepConflict = errors.New("Error stopping conflicting unit")
 var ErrNotLoaded = errors.New("Unit is not loaded.")
 var ErrNoReload = errors.New("Unit does not support reloading")
+var ErrNoClean = errors.New("Unit does not support cleaning")
+var ErrNotInactive = errors.New("Unit is not inactive or failed")
 var ErrUnknownType = errors.New("Unknown type")
 var ErrNotActive = errors.New("Unit is not active")
 var ErrExists = errors.New("Unit already exists")
 var ErrNotImplemented = errors.New("Not implemented yet")
 var ErrUnmergeable = errors.New("Unmergeable job types")
+var ErrNoCredentials = errors.New("No credentials received")
+var ErrStartLimitHit = errors.New("Start request repeated too quickly")
+var ErrAssertFailed = errors.New("Assertion failed")
+var ErrUnknownAction = errors.New("Unknown action")
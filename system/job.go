@@ -136,6 +136,13 @@ func (j *job) Run() (err error) {
 		return
 	}
 
+	// Jobs are counted once dispatched, jobs waiting for their dependencies
+	// must not delay idle services they may depend on themselves
+	if sys := j.unit.System; sys != nil {
+		sys.changeJobs(1, 0)
+		defer sys.changeJobs(-1, 0)
+	}
+
 	switch j.typ {
 	case start:
 		return j.unit.start()
@@ -0,0 +1,128 @@
+package system
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Maximum size of a notification message
+const NOTIFY_BUFFER_SIZE = 4096
+
+// ListenNotify creates a datagram socket at path, which unit processes can send
+// notifications to(see sd_notify(3)), and starts dispatching received notifications
+// to the units owning the sending processes.
+// The path of the socket is passed to services using NOTIFY_SOCKET environment variable.
+func (sys *Daemon) ListenNotify(path string) (err error) {
+	log.WithField("path", path).Debugf("sys.ListenNotify")
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	var conn *net.UnixConn
+	if conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"}); err != nil {
+		return
+	}
+
+	if err = setPassCred(conn); err != nil {
+		conn.Close()
+		return
+	}
+
+	// Processes of services running as unprivileged users need to be able to write to the socket
+	if err = os.Chmod(path, 0777); err != nil {
+		conn.Close()
+		return
+	}
+
+	sys.mutex.Lock()
+	sys.notifySocket = path
+	sys.mutex.Unlock()
+
+	go sys.serveNotify(conn)
+	return nil
+}
+
+func setPassCred(conn *net.UnixConn) (err error) {
+	var raw syscall.RawConn
+	if raw, err = conn.SyscallConn(); err != nil {
+		return
+	}
+
+	if cerr := raw.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return
+}
+
+func (sys *Daemon) serveNotify(conn *net.UnixConn) {
+	defer conn.Close()
+
+	buf := make([]byte, NOTIFY_BUFFER_SIZE)
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
+
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			sys.Log.Errorf("Error reading notification: %s", err)
+			return
+		}
+
+		pid, err := senderPID(oob[:oobn])
+		if err != nil {
+			sys.Log.Warnf("Notification without valid credentials ignored: %s", err)
+			continue
+		}
+
+		sys.notify(pid, string(buf[:n]))
+	}
+}
+
+// senderPID extracts the PID of the sender from the socket control message
+func senderPID(oob []byte) (pid int, err error) {
+	var msgs []syscall.SocketControlMessage
+	if msgs, err = syscall.ParseSocketControlMessage(oob); err != nil {
+		return
+	}
+
+	for _, msg := range msgs {
+		var cred *syscall.Ucred
+		if cred, err = syscall.ParseUnixCredentials(&msg); err == nil {
+			return int(cred.Pid), nil
+		}
+	}
+	return 0, ErrNoCredentials
+}
+
+// notify dispatches the notification message to the unit owning the process with pid specified
+func (sys *Daemon) notify(pid int, msg string) {
+	log.WithFields(log.Fields{
+		"pid": pid,
+		"msg": msg,
+	}).Debugf("sys.notify")
+
+	for _, u := range sys.Units() {
+		n, ok := u.Interface.(unit.Notifier)
+		if !ok || !n.Owns(pid) {
+			continue
+		}
+
+		if err := n.Notify(pid, msg); err != nil {
+			u.Log.Warnf("Notification from PID %d rejected: %s", pid, err)
+		}
+		return
+	}
+
+	sys.Log.Warnf("Notification from PID %d, which does not belong to any unit, ignored", pid)
+}
@@ -0,0 +1,71 @@
+package system
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/plasma-umass/systemgo/test/mock_unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type notification struct {
+	pid int
+	msg string
+}
+
+type mockNotifier struct {
+	*mock_unit.MockInterface
+	notifych chan notification
+}
+
+func (m *mockNotifier) Owns(pid int) bool {
+	return pid == os.Getpid()
+}
+
+func (m *mockNotifier) Notify(pid int, msg string) error {
+	m.notifych <- notification{pid, msg}
+	return nil
+}
+
+func TestListenNotify(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir, err := ioutil.TempDir("", "notify-test")
+	require.NoError(t, err, "ioutil.TempDir")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notify")
+
+	sys := New()
+	require.NoError(t, sys.ListenNotify(path), "sys.ListenNotify")
+	assert.Equal(t, path, sys.notifySocket)
+
+	m := &mockNotifier{
+		MockInterface: mock_unit.NewMockInterface(ctrl),
+		notifych:      make(chan notification, 1),
+	}
+	_, err = sys.Supervise("notify.service", m)
+	require.NoError(t, err, "sys.Supervise")
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err, "net.DialUnix")
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("READY=1\nSTATUS=test"))
+	require.NoError(t, err, "conn.Write")
+
+	select {
+	case n := <-m.notifych:
+		assert.Equal(t, os.Getpid(), n.pid, "sender PID")
+		assert.Equal(t, "READY=1\nSTATUS=test", n.msg, "message")
+	case <-time.After(5 * time.Second):
+		t.Error("Notification was not dispatched")
+	}
+}
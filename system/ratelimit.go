@@ -0,0 +1,34 @@
+package system
+
+import "time"
+
+// rateLimit allows up to burst events in interval
+type rateLimit struct {
+	interval time.Duration
+	burst    int
+
+	begin time.Time
+	num   int
+}
+
+// test records an event happening at t and reports whether the rate limit is not exceeded.
+// Zero interval or burst disable rate limiting
+func (rl *rateLimit) test(t time.Time) bool {
+	if rl.interval <= 0 || rl.burst <= 0 {
+		return true
+	}
+
+	if rl.begin.IsZero() || t.Sub(rl.begin) > rl.interval {
+		rl.begin = t
+		rl.num = 0
+	}
+
+	rl.num++
+	return rl.num <= rl.burst
+}
+
+// reset clears the events recorded
+func (rl *rateLimit) reset() {
+	rl.begin = time.Time{}
+	rl.num = 0
+}
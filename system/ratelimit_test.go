@@ -0,0 +1,32 @@
+package system
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	rl := rateLimit{
+		interval: time.Minute,
+		burst:    2,
+	}
+
+	now := time.Now()
+	assert.True(t, rl.test(now))
+	assert.True(t, rl.test(now.Add(time.Second)))
+	assert.False(t, rl.test(now.Add(2*time.Second)))
+
+	// Interval elapsed
+	assert.True(t, rl.test(now.Add(2*time.Minute)))
+
+	rl.reset()
+	assert.True(t, rl.test(now))
+
+	// Disabled
+	rl = rateLimit{}
+	for i := 0; i < 10; i++ {
+		assert.True(t, rl.test(now))
+	}
+}
@@ -0,0 +1,185 @@
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// prctl(2) option, which is not defined by package syscall
+const PR_SET_CHILD_SUBREAPER = 36
+
+// EnableSubreaper makes sys the subreaper of the processes it starts(see prctl(2)), so that
+// their orphaned descendants get reparented to it, and starts reaping them on SIGCHLD.
+// Exit statuses of the reaped processes are delivered to the units owning them
+func (sys *Daemon) EnableSubreaper() (err error) {
+	log.Debugf("sys.EnableSubreaper")
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_CHILD_SUBREAPER, 1, 0); errno != 0 {
+		return errno
+	}
+
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGCHLD)
+
+	go func() {
+		for range sigch {
+			sys.reapOrphans()
+		}
+	}()
+
+	// Orphans could have been reparented before SIGCHLD was handled
+	sys.reapOrphans()
+	return nil
+}
+
+// Spawn starts a new process running cmd and tracks it, so that it is not reaped as an
+// orphan, until it is waited for using Wait. Each invocation of a unit needs a fresh cmd
+func (sys *Daemon) Spawn(cmd *exec.Cmd) (err error) {
+	// Process must not be reaped before it is tracked
+	sys.spawnMutex.RLock()
+	defer sys.spawnMutex.RUnlock()
+
+	if err = cmd.Start(); err != nil {
+		return
+	}
+
+	sys.trackMutex.Lock()
+	defer sys.trackMutex.Unlock()
+
+	if sys.tracked == nil {
+		sys.tracked = map[int]bool{}
+	}
+	sys.tracked[cmd.Process.Pid] = true
+	return nil
+}
+
+// Wait waits for the process started by Spawn to exit and lets its PID be reaped as an orphan afterwards
+func (sys *Daemon) Wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	if cmd.Process != nil {
+		sys.trackMutex.Lock()
+		delete(sys.tracked, cmd.Process.Pid)
+		sys.trackMutex.Unlock()
+	}
+	return err
+}
+
+func (sys *Daemon) isTracked(pid int) bool {
+	sys.trackMutex.Lock()
+	defer sys.trackMutex.Unlock()
+
+	return sys.tracked[pid]
+}
+
+// reapOrphans reaps the exited children of sys, which are not tracked, and
+// delivers their exit statuses to the units owning them
+func (sys *Daemon) reapOrphans() {
+	type exit struct {
+		pid    int
+		status syscall.WaitStatus
+		owner  *Unit
+	}
+	var exits []exit
+
+	sys.spawnMutex.Lock()
+	for _, pid := range zombieChildren() {
+		if sys.isTracked(pid) {
+			continue
+		}
+
+		// Owner is determined before reaping, while the process can still be looked up
+		owner := sys.owner(pid)
+
+		var ws syscall.WaitStatus
+		if wpid, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil); err != nil || wpid != pid {
+			continue
+		}
+		exits = append(exits, exit{pid, ws, owner})
+	}
+	sys.spawnMutex.Unlock()
+
+	for _, e := range exits {
+		log.WithFields(log.Fields{
+			"pid":    e.pid,
+			"status": e.status,
+		}).Debugf("sys.reapOrphans")
+
+		if e.owner == nil {
+			continue
+		}
+		if h, ok := e.owner.Interface.(unit.ExitHandler); ok {
+			h.ProcessExited(e.pid, e.status)
+		}
+	}
+}
+
+// owner returns the unit owning the process with pid specified, nil if there is none
+func (sys *Daemon) owner(pid int) *Unit {
+	for _, u := range sys.Units() {
+		if n, ok := u.Interface.(unit.Notifier); ok && n.Owns(pid) {
+			return u
+		}
+	}
+	return nil
+}
+
+// zombieChildren returns PIDs of the children of the manager, which exited and were not reaped yet
+func zombieChildren() (pids []int) {
+	for _, pid := range children() {
+		if st, err := unit.ReadProcStat(pid); err == nil && st.State == 'Z' {
+			pids = append(pids, pid)
+		}
+	}
+	return
+}
+
+// children returns PIDs of the children of the threads of the manager. If they are not
+// listed by the kernel(see proc(5)), the processes are searched for a parent instead
+func children() (pids []int) {
+	names, _ := filepath.Glob("/proc/self/task/*/children")
+	if len(names) == 0 {
+		return childrenByParent()
+	}
+
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Fields(string(b)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	return
+}
+
+// childrenByParent returns PIDs of the processes, the parent of which is the manager
+func childrenByParent() (pids []int) {
+	names, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return
+	}
+
+	ppid := os.Getpid()
+	for _, name := range names {
+		pid, err := strconv.Atoi(filepath.Base(name))
+		if err != nil {
+			continue
+		}
+		if st, err := unit.ReadProcStat(pid); err == nil && st.PPID == ppid {
+			pids = append(pids, pid)
+		}
+	}
+	return
+}
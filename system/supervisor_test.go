@@ -0,0 +1,77 @@
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/plasma-umass/systemgo/unit/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpawn(t *testing.T) {
+	sys := New()
+
+	tracked := exec.Command("true")
+	require.NoError(t, sys.Spawn(tracked))
+	assert.True(t, sys.isTracked(tracked.Process.Pid))
+
+	orphan := exec.Command("true")
+	require.NoError(t, orphan.Start())
+
+	for i := 0; i < 100 && len(zombieChildren()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sys.reapOrphans()
+
+	assert.NoError(t, sys.Wait(tracked), "tracked process left to be waited for")
+	assert.Error(t, orphan.Wait(), "untracked process reaped")
+
+	assert.False(t, sys.isTracked(tracked.Process.Pid))
+	assert.Empty(t, zombieChildren())
+
+	assert.Error(t, sys.Spawn(exec.Command("/nonexistent")))
+	assert.Error(t, sys.Spawn(tracked), "cmd started twice")
+}
+
+func TestSubreaper(t *testing.T) {
+	sys := New()
+	require.NoError(t, sys.EnableSubreaper(), "sys.EnableSubreaper")
+	// Children of other tests must not be reaped by sys
+	defer signal.Reset(syscall.SIGCHLD)
+
+	dir, err := ioutil.TempDir("", "systemgo-subreaper-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "main.pid")
+
+	// Main process forked off by the start command is orphaned once it exits
+	sv := &service.Unit{Supervisor: sys}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=forking
+PIDFile=`+pidfile+`
+ExecStart=/bin/sh -c 'sh -c "sleep 0.2; exit 7" & echo $$! > `+pidfile+`'`)))
+
+	u, err := sys.Supervise("orphan.service", sv)
+	require.NoError(t, err, "sys.Supervise")
+	u.load = unit.Loaded
+
+	require.NoError(t, u.start(), "u.start")
+	assert.NotZero(t, sv.MainPID())
+
+	for i := 0; i < 200 && sv.Result() == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "exit-code", sv.Result(), "result of the orphaned main process")
+	assert.Equal(t, &unit.ExitStatus{Code: 7}, sv.ExitStatus())
+	assert.Equal(t, unit.Failed, u.Active())
+	assert.Empty(t, zombieChildren(), "zombies left")
+}
@@ -19,7 +19,17 @@ type Target struct {
 
 // Define attempts to fill the targ definition by parsing r
 func (targ *Target) Define(r io.Reader) (err error) {
-	return unit.ParseDefinition(r, &targ.Definition)
+	targ.Definition = unit.NewDefinition()
+	if err = unit.ParseDefinition(r, &targ.Definition); err != nil {
+		return
+	}
+
+	merr := targ.CheckStartLimit()
+	_, cerr := targ.ParseConditions()
+	if merr = append(merr, cerr...); len(merr) > 0 {
+		return merr
+	}
+	return nil
 }
 
 // Active returns activation status of the unit
@@ -5,7 +5,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/plasma-umass/systemgo/unit"
@@ -28,22 +30,52 @@ type Unit struct {
 
 	job *job
 
+	// Start rate limiting state
+	startLimit    rateLimit
+	startLimitHit bool
+
+	// Condition and assertion, which failed on the last start, empty if none
+	conditionFailed, assertFailed string
+
 	mutex sync.Mutex
 }
 
 // TODO introduce a better workaround
 const (
-	starting  = "starting"
-	stopping  = "stopping"
-	reloading = "reloading"
+	starting      = "starting"
+	stopping      = "stopping"
+	reloading     = "reloading"
+	failedSub     = "failed"
+	startLimitHit = "start-limit-hit"
 )
 
 // NewUnit returns an instance of new unit wrapping v
 func NewUnit(v unit.Interface) (u *Unit) {
-	return &Unit{
+	u = &Unit{
 		Interface: v,
 		Log:       NewLog(),
 	}
+
+	if o, ok := v.(unit.OutputLogger); ok {
+		o.SetOutputLog(u.logOutput)
+	}
+	return
+}
+
+// logOutput writes a line of output of the processes of u with syslog priority specified to the log of u
+func (u *Unit) logOutput(priority int, identifier, line string) {
+	e := u.Log.WithField("identifier", identifier)
+
+	switch {
+	case priority <= 3: // LOG_EMERG, LOG_ALERT, LOG_CRIT, LOG_ERR
+		e.Error(line)
+	case priority == 4: // LOG_WARNING
+		e.Warn(line)
+	case priority <= 6: // LOG_NOTICE, LOG_INFO
+		e.Info(line)
+	default: // LOG_DEBUG
+		e.Debug(line)
+	}
 }
 
 //func (u *Unit) String() string {
@@ -87,8 +119,13 @@ func (u *Unit) IsLoaded() bool {
 
 // IsReloader returns whether u.Interface is capable of reloading
 func (u *Unit) IsReloader() (ok bool) {
-	_, ok = u.Interface.(unit.Reloader)
-	return
+	if _, ok = u.Interface.(unit.Reloader); !ok {
+		return
+	}
+	if c, is := u.Interface.(unit.ReloadChecker); is {
+		return c.CanReload()
+	}
+	return true
 }
 
 func (u *Unit) Active() (st unit.Activation) {
@@ -103,6 +140,10 @@ func (u *Unit) Active() (st unit.Activation) {
 		}
 	}
 
+	if u.isStartLimitHit() {
+		return unit.Failed
+	}
+
 	return u.Interface.Active()
 }
 
@@ -118,6 +159,11 @@ func (u *Unit) Sub() string {
 		}
 	}
 
+	if u.isStartLimitHit() {
+		// Result of the unit reports the start limit being hit
+		return failedSub
+	}
+
 	return u.Interface.Sub()
 }
 
@@ -144,14 +190,69 @@ func (u *Unit) Status() unit.Status {
 		u.Log.Errorf("Error reading log: %s", err)
 	}
 
-	// TODO deal with different unit types requiring different status
-	// something like u.Interface.HasX() ?
-	switch u.Interface.(type) {
-	//case *unit.Service:
-	//return unit.ServiceStatus{st}
-	default:
-		return st
+	if p, ok := u.Interface.(unit.MainPIDer); ok {
+		st.MainPID = p.MainPID()
+	}
+	if t, ok := u.Interface.(unit.StatusTexter); ok {
+		st.Text = t.StatusText()
+	}
+	if r, ok := u.Interface.(unit.AutoRestarter); ok {
+		st.Restarts = r.NRestarts()
+	}
+	if r, ok := u.Interface.(unit.Resulter); ok {
+		st.Result = r.Result()
+		st.MainExit = r.ExitStatus()
+	}
+	if u.isStartLimitHit() {
+		st.Result = startLimitHit
 	}
+	st.Condition, st.Assert = u.failedConditions()
+
+	return st
+}
+
+// Properties returns the properties of the unit and the settings of u.Interface, if it exposes any
+func (u *Unit) Properties() map[string]string {
+	props := map[string]string{
+		"Id":            u.Name(),
+		"Description":   u.Description(),
+		"Documentation": u.Documentation(),
+		"FragmentPath":  u.Path(),
+		"LoadState":     u.Loaded().String(),
+		"ActiveState":   u.Active().String(),
+		"SubState":      u.Sub(),
+	}
+
+	if p, ok := u.Interface.(unit.MainPIDer); ok {
+		props["MainPID"] = strconv.Itoa(p.MainPID())
+	}
+	if t, ok := u.Interface.(unit.StatusTexter); ok {
+		props["StatusText"] = t.StatusText()
+	}
+	if r, ok := u.Interface.(unit.AutoRestarter); ok {
+		props["NRestarts"] = strconv.Itoa(r.NRestarts())
+	}
+	if p, ok := u.Interface.(unit.Propertier); ok {
+		for name, value := range p.Properties() {
+			props[name] = value
+		}
+	}
+	if u.isStartLimitHit() {
+		props["Result"] = startLimitHit
+	}
+	if _, ok := u.Interface.(unit.Conditioner); ok {
+		condition, assert := u.failedConditions()
+		props["ConditionResult"], props["AssertResult"] = yesNo(condition == ""), yesNo(assert == "")
+	}
+	return props
+}
+
+// yesNo formats b as a boolean property value
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
 }
 
 // Requires returns a slice of unit names as found in definition and absolute paths
@@ -279,11 +380,24 @@ func (u *Unit) Reload() (err error) {
 func (u *Unit) reload() (err error) {
 	log.WithField("u", u).Debugf("u.reload")
 
-	reloader, ok := u.Interface.(unit.Reloader)
-	if !ok {
+	if !u.IsLoaded() {
+		return ErrNotLoaded
+	}
+
+	if !u.IsReloader() {
 		return ErrNoReload
 	}
-	return reloader.Reload()
+
+	// The reload job itself makes u appear reloading
+	if u.Interface.Active() != unit.Active {
+		return ErrNotActive
+	}
+
+	u.Log.Println("Reloading...")
+	if err = u.Interface.(unit.Reloader).Reload(); err != nil {
+		u.Log.Errorf("Failed to reload: %s", err)
+	}
+	return
 }
 
 // Start creates a new start transaction and runs it
@@ -306,6 +420,17 @@ func (u *Unit) start() (err error) {
 		return ErrNotLoaded
 	}
 
+	var skip bool
+	if skip, err = u.checkConditions(); err != nil || skip {
+		e.Debug("conditions not met")
+		return
+	}
+
+	if err = u.testStartLimit(); err != nil {
+		e.Debug("start limit hit")
+		return
+	}
+
 	u.Log.Println("Starting...")
 
 	starter, ok := u.Interface.(unit.Starter)
@@ -315,7 +440,149 @@ func (u *Unit) start() (err error) {
 	}
 
 	e.Debugf("Interface.Start")
-	return starter.Start()
+	if err = starter.Start(); err != nil {
+		u.Log.Errorf("Failed to start: %s", err)
+	}
+	return
+}
+
+// checkConditions checks the conditions and assertions of u and records the failed ones.
+// skip is true if a condition failed, ErrAssertFailed is returned if an assertion failed
+func (u *Unit) checkConditions() (skip bool, err error) {
+	u.mutex.Lock()
+	u.conditionFailed, u.assertFailed = "", ""
+	u.mutex.Unlock()
+
+	c, ok := u.Interface.(unit.Conditioner)
+	if !ok {
+		return false, nil
+	}
+
+	var conds, asserts []unit.Condition
+	for _, cond := range c.Conditions() {
+		if cond.Assert {
+			asserts = append(asserts, cond)
+		} else {
+			conds = append(conds, cond)
+		}
+	}
+
+	if ok, failed := unit.CheckConditions(conds); !ok {
+		u.mutex.Lock()
+		u.conditionFailed = failed.String()
+		u.mutex.Unlock()
+
+		u.Log.Infof("Condition check resulted in unit being skipped: %s was not met", failed)
+		return true, nil
+	}
+
+	if ok, failed := unit.CheckConditions(asserts); !ok {
+		u.mutex.Lock()
+		u.assertFailed = failed.String()
+		u.mutex.Unlock()
+
+		u.Log.Errorf("Assertion failed: %s was not met", failed)
+		return false, ErrAssertFailed
+	}
+	return false, nil
+}
+
+// failedConditions returns the condition and assertion, which failed on the last start of u
+func (u *Unit) failedConditions() (condition, assert string) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.conditionFailed, u.assertFailed
+}
+
+// autoRestart starts u each time a restart is requested on ch
+func (u *Unit) autoRestart(ch <-chan struct{}) {
+	for range ch {
+		log.WithField("unit", u.Name()).Debugf("u.autoRestart")
+
+		u.Log.Println("Restarting automatically...")
+		if err := u.Start(); err != nil {
+			u.Log.Errorf("Automatic restart failed: %s", err)
+		}
+	}
+}
+
+// testStartLimit records a start attempt and returns ErrStartLimitHit if u was started too often.
+// Start limit action of u is performed when the limit is hit. Starting is refused until
+// the limit is reset using ResetFailed
+func (u *Unit) testStartLimit() (err error) {
+	limiter, ok := u.Interface.(unit.StartLimiter)
+	if !ok {
+		return nil
+	}
+
+	u.mutex.Lock()
+	wasHit := u.startLimitHit
+	if !wasHit {
+		u.startLimit.interval = limiter.StartLimitInterval()
+		u.startLimit.burst = limiter.StartLimitBurst()
+		u.startLimitHit = !u.startLimit.test(time.Now())
+	}
+	isHit := u.startLimitHit
+	u.mutex.Unlock()
+
+	if !isHit {
+		return nil
+	}
+
+	u.Log.Errorf("%s. Refusing to start, use reset-failed to allow starting again.", ErrStartLimitHit)
+
+	if !wasHit && u.System != nil {
+		if err = u.System.performAction(limiter.StartLimitAction(), u); err != nil {
+			u.Log.Errorf("Error performing start limit action %s: %s", limiter.StartLimitAction(), err)
+		}
+	}
+	return ErrStartLimitHit
+}
+
+func (u *Unit) isStartLimitHit() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.startLimitHit
+}
+
+// ResetFailed resets the start rate limiting counter of u and the failure state of u.Interface
+func (u *Unit) ResetFailed() {
+	log.WithField("unit", u.Name()).Debugf("u.ResetFailed")
+
+	u.mutex.Lock()
+	u.startLimit.reset()
+	u.startLimitHit = false
+	u.mutex.Unlock()
+
+	if r, ok := u.Interface.(unit.ResetFailer); ok {
+		r.ResetFailed()
+	}
+}
+
+// Clean removes the directories of the kinds specified managed for u, which must be inactive or failed
+func (u *Unit) Clean(what ...string) (err error) {
+	log.WithFields(log.Fields{
+		"unit": u.Name(),
+		"what": what,
+	}).Debugf("u.Clean")
+
+	if !u.IsLoaded() {
+		return ErrNotLoaded
+	}
+
+	cleaner, ok := u.Interface.(unit.Cleaner)
+	if !ok {
+		return ErrNoClean
+	}
+
+	if st := u.Active(); st != unit.Inactive && st != unit.Failed {
+		return ErrNotInactive
+	}
+
+	u.Log.Println("Cleaning...")
+	return cleaner.Clean(what...)
 }
 
 // Stop creates a new stop transaction and runs it
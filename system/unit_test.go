@@ -1,12 +1,17 @@
 package system
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/plasma-umass/systemgo/test/mock_unit"
 	"github.com/golang/mock/gomock"
+	"github.com/plasma-umass/systemgo/test/mock_unit"
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/plasma-umass/systemgo/unit/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -58,3 +63,203 @@ func TestDeps(t *testing.T) {
 		}
 	}
 }
+
+type mockLimiter struct {
+	*mockUnit
+}
+
+func (m *mockLimiter) StartLimitInterval() time.Duration {
+	return time.Hour
+}
+
+func (m *mockLimiter) StartLimitBurst() int {
+	return 2
+}
+
+func (m *mockLimiter) StartLimitAction() string {
+	return "reboot"
+}
+
+func TestStartLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sys := New()
+
+	var actions []string
+	sys.SetActionHook(func(action string, u *Unit) error {
+		actions = append(actions, action)
+		return nil
+	})
+
+	m := &mockLimiter{newMock(ctrl)}
+	m.MockStarter.EXPECT().Start().Return(nil).Times(5)
+	m.MockInterface.EXPECT().Active().Return(unit.Inactive).AnyTimes()
+	m.MockInterface.EXPECT().Sub().Return("dead").AnyTimes()
+
+	u, err := sys.Supervise("limited.service", m)
+	require.NoError(t, err, "sys.Supervise")
+	u.load = unit.Loaded
+
+	assert.NoError(t, u.start())
+	assert.NoError(t, u.start())
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, ErrStartLimitHit, u.start())
+		assert.Equal(t, unit.Failed, u.Active())
+		assert.Equal(t, "failed", u.Sub())
+		assert.Equal(t, startLimitHit, u.Status().Result)
+	}
+	assert.Equal(t, []string{"reboot"}, actions, "actions performed")
+
+	require.NoError(t, sys.ResetFailed("limited.service"), "sys.ResetFailed")
+	assert.Equal(t, unit.Inactive, u.Active())
+	assert.NoError(t, u.start())
+
+	// Starting is refused until the limit is reset, even once the interval passes
+	assert.NoError(t, u.start())
+	assert.Equal(t, ErrStartLimitHit, u.start())
+	u.mutex.Lock()
+	u.startLimit.begin = u.startLimit.begin.Add(-2 * time.Hour)
+	u.mutex.Unlock()
+	assert.Equal(t, ErrStartLimitHit, u.start(), "interval passed")
+	assert.Equal(t, []string{"reboot", "reboot"}, actions, "actions performed")
+
+	require.NoError(t, sys.ResetFailed("limited.service"), "sys.ResetFailed")
+	assert.NoError(t, u.start())
+}
+
+func TestLogOutput(t *testing.T) {
+	u := NewUnit(nil)
+
+	u.logOutput(3, "test", "error line")
+	u.logOutput(6, "test", "info line")
+	u.logOutput(7, "test", "debug line")
+
+	b, err := ioutil.ReadAll(u.Log)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(b), "error line")
+	assert.Contains(t, string(b), "info line")
+	assert.NotContains(t, string(b), "debug line")
+	assert.Contains(t, string(b), "identifier=test")
+}
+
+func TestConditions(t *testing.T) {
+	sys := New()
+
+	for _, test := range []struct {
+		options             string
+		err                 error
+		condition, asserted string
+	}{
+		{"ConditionPathExists=/", nil, "", ""},
+		{"ConditionPathExists=/nonexistent", nil, "ConditionPathExists=/nonexistent", ""},
+		{"ConditionPathExists=|/nonexistent\nConditionPathExists=|/", nil, "", ""},
+		{"AssertPathExists=!/", ErrAssertFailed, "", "AssertPathExists=!/"},
+	} {
+		targ := &Target{System: sys}
+		require.NoError(t, targ.Define(strings.NewReader("[Unit]\n"+test.options)), test.options)
+
+		u := NewUnit(targ)
+		u.System = sys
+		u.load = unit.Loaded
+
+		assert.Equal(t, test.err, u.start(), test.options)
+
+		st := u.Status()
+		assert.Equal(t, test.condition, st.Condition, test.options)
+		assert.Equal(t, test.asserted, st.Assert, test.options)
+
+		props := u.Properties()
+		assert.Equal(t, yesNo(test.condition == ""), props["ConditionResult"], test.options)
+		assert.Equal(t, yesNo(test.asserted == ""), props["AssertResult"], test.options)
+	}
+
+	targ := &Target{System: sys}
+	assert.Error(t, targ.Define(strings.NewReader("[Unit]\nConditionPathExists=relative")), "relative path")
+}
+
+func TestClean(t *testing.T) {
+	sys := New()
+
+	targ := &Target{System: sys}
+	require.NoError(t, targ.Define(strings.NewReader("[Unit]\nDescription=target")))
+	u := NewUnit(targ)
+	u.load = unit.Loaded
+	assert.Equal(t, ErrNoClean, u.Clean(), "target")
+
+	sv := &service.Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/sleep 10")))
+	u, err := sys.Supervise("clean.service", sv)
+	require.NoError(t, err, "sys.Supervise")
+	u.load = unit.Loaded
+
+	require.NoError(t, u.start(), "u.start")
+	assert.Equal(t, ErrNotInactive, u.Clean(), "active service")
+
+	require.NoError(t, u.stop(), "u.stop")
+	for i := 0; i < 100 && u.Active() != unit.Inactive; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, u.Clean(), "inactive service")
+	assert.Error(t, u.Clean("everything"), "unknown kind")
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-reload-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+
+	sys := New()
+	supervise := func(name, definition string) *Unit {
+		sv := &service.Unit{}
+		require.NoError(t, sv.Define(strings.NewReader(definition)), name)
+		u, err := sys.Supervise(name, sv)
+		require.NoError(t, err, "sys.Supervise")
+		u.load = unit.Loaded
+		return u
+	}
+	reloadable := supervise("reloadable.service", "[Service]\nExecStart=/bin/sleep 10\nExecReload=/bin/touch "+out)
+	restartable := supervise("restartable.service", "[Service]\nExecStart=/bin/sleep 10")
+	defer reloadable.stop()
+	defer restartable.stop()
+
+	assert.True(t, reloadable.IsReloader())
+	assert.False(t, restartable.IsReloader(), "service without ExecReload")
+	assert.Equal(t, ErrNotActive, reloadable.reload(), "inactive service")
+	assert.Equal(t, restart, reloadOrRestart(reloadable), "inactive service")
+
+	require.NoError(t, reloadable.start(), "u.start")
+	require.NoError(t, restartable.start(), "u.start")
+	assert.Equal(t, ErrNoReload, restartable.reload())
+	assert.Equal(t, reload, reloadOrRestart(reloadable))
+	assert.Equal(t, restart, reloadOrRestart(restartable))
+
+	mainPIDs := func() (pids [2]int) {
+		return [2]int{
+			reloadable.Interface.(unit.MainPIDer).MainPID(),
+			restartable.Interface.(unit.MainPIDer).MainPID(),
+		}
+	}
+	before := mainPIDs()
+
+	require.NoError(t, sys.ReloadOrRestart("reloadable.service", "restartable.service"))
+	for i := 0; i < 100 && (mainPIDs()[1] == before[1] || mainPIDs()[1] == 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	after := mainPIDs()
+	assert.Equal(t, before[0], after[0], "reloaded service keeps running")
+	assert.NotEqual(t, before[1], after[1], "service restarted")
+	assert.NotZero(t, after[1], "service restarted")
+
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(out); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, err, "ExecReload run")
+}
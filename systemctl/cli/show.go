@@ -0,0 +1,75 @@
+// Copyright © 2016 Romans Volosatovs <rvolosatovs@riseup.net>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/plasma-umass/systemgo/systemctl"
+	"github.com/spf13/cobra"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// showCmd represents the show command
+var showCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show properties of one or more units",
+	Long: `show prints the properties of the units specified as "Name=Value" pairs,
+including the settings applied to the processes of the units`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var resp systemctl.Response
+		if err := client.Call("Server.Show", args, &resp); err != nil {
+			log.Error(err)
+		}
+
+		if resp.Yield == nil {
+			return
+		}
+
+		props := resp.Yield.(map[string]map[string]string)
+		for i, name := range args {
+			p, ok := props[name]
+			if !ok {
+				continue
+			}
+
+			if i > 0 {
+				fmt.Println()
+			}
+
+			keys := make([]string, 0, len(p))
+			for key := range p {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				fmt.Printf("%s=%s\n", key, p[key])
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(showCmd)
+}
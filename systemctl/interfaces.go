@@ -11,12 +11,16 @@ type Daemon interface {
 	Isolate(...string) error
 	Restart(...string) error
 	Reload(...string) error
+	ReloadOrRestart(...string) error
 	Enable(...string) error
 	Disable(...string) error
+	ResetFailed(...string) error
+	Clean([]string, ...string) error
 
 	Units() []*system.Unit
 	Status() (system.Status, error)
 	StatusOf(string) (unit.Status, error)
+	PropertiesOf(string) (map[string]string, error)
 	IsEnabled(string) (unit.Enable, error)
 	IsActive(string) (unit.Activation, error)
 }
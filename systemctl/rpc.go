@@ -13,6 +13,7 @@ type Response struct {
 
 func init() {
 	gob.Register(map[string]unit.Status{})
+	gob.Register(map[string]map[string]string{})
 }
 
 func newResponse() (resp *Response) {
@@ -49,6 +50,10 @@ func (sv *Server) Reload(names []string, resp *Response) (err error) {
 	return sv.sys.Reload(names...)
 }
 
+func (sv *Server) ReloadOrRestart(names []string, resp *Response) (err error) {
+	return sv.sys.ReloadOrRestart(names...)
+}
+
 func (sv *Server) Enable(names []string, resp *Response) (err error) {
 	return sv.sys.Enable(names...)
 }
@@ -57,6 +62,19 @@ func (sv *Server) Disable(names []string, resp *Response) (err error) {
 	return sv.sys.Disable(names...)
 }
 
+func (sv *Server) ResetFailed(names []string, resp *Response) (err error) {
+	return sv.sys.ResetFailed(names...)
+}
+
+// CleanRequest specifies the units to clean and the kinds of their directories to remove
+type CleanRequest struct {
+	Names, What []string
+}
+
+func (sv *Server) Clean(req CleanRequest, resp *Response) (err error) {
+	return sv.sys.Clean(req.What, req.Names...)
+}
+
 func (sv *Server) Status(names []string, resp *Response) (err error) {
 	*resp = *newResponse()
 
@@ -84,3 +102,21 @@ func (sv *Server) StatusAll(names []string, resp *Response) (err error) {
 	}
 	return sv.Status(names, resp)
 }
+
+func (sv *Server) Show(names []string, resp *Response) (err error) {
+	*resp = *newResponse()
+
+	props := map[string]map[string]string{}
+
+	for _, name := range names {
+		var p map[string]string
+		if p, err = sv.sys.PropertiesOf(name); err != nil {
+			continue
+		}
+
+		props[name] = p
+	}
+
+	resp.Yield = props
+	return err
+}
@@ -0,0 +1,516 @@
+package unit
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Highest UID of system users, used by ConditionUser=@system
+const SYSTEM_UID_MAX = 999
+
+// Flag of a read-only filesystem in Statfs_t.Flags(see statfs(2))
+const ST_RDONLY = 1
+
+// Paths of the files conditions are checked against
+var (
+	cmdlinePath   = "/proc/cmdline"
+	mountinfoPath = "/proc/self/mountinfo"
+	machineIDPath = "/etc/machine-id"
+)
+
+// Condition is a check specified by a Condition*= or Assert*= option, which has to pass for a unit to start
+type Condition struct {
+	// Name of the option without the "Condition" or "Assert" prefix, e.g. "PathExists"
+	Type string
+
+	// Value of the option without the "|" and "!" prefixes
+	Value string
+
+	// Whether the option is an assertion, failure of which fails the start instead of skipping it
+	Assert bool
+
+	// Whether at least one of the triggering conditions has to pass instead of all of them("|" prefix)
+	Trigger bool
+
+	// Whether the result of the check is negated("!" prefix)
+	Negate bool
+}
+
+// Conditioner is implemented by any value that has conditions checked before it is started
+type Conditioner interface {
+	Conditions() []Condition
+}
+
+// Checks of the supported condition types mapped to the type names
+var conditionChecks = map[string]func(value string) bool{
+	"PathExists":         checkPathExists,
+	"PathExistsGlob":     checkPathExistsGlob,
+	"PathIsDirectory":    checkPathIsDirectory,
+	"PathIsSymbolicLink": checkPathIsSymbolicLink,
+	"PathIsMountPoint":   checkPathIsMountPoint,
+	"PathIsReadWrite":    checkPathIsReadWrite,
+	"DirectoryNotEmpty":  checkDirectoryNotEmpty,
+	"FileNotEmpty":       checkFileNotEmpty,
+	"FileIsExecutable":   checkFileIsExecutable,
+	"Virtualization":     checkVirtualization,
+	"Host":               checkHost,
+	"KernelCommandLine":  checkKernelCommandLine,
+	"KernelVersion":      checkKernelVersion,
+	"Architecture":       checkArchitecture,
+	"Environment":        checkEnvironment,
+	"User":               checkUser,
+	"Group":              checkGroup,
+	"FirstBoot":          checkFirstBoot,
+}
+
+// ParseCondition parses the value of option Condition<typ>= or, if assert is true, Assert<typ>=
+func ParseCondition(typ, value string, assert bool) (c Condition, err error) {
+	c = Condition{Type: typ, Assert: assert}
+
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "|") {
+		c.Trigger, value = true, strings.TrimSpace(value[1:])
+	}
+	if strings.HasPrefix(value, "!") {
+		c.Negate, value = true, strings.TrimSpace(value[1:])
+	}
+	c.Value = value
+
+	if _, ok := conditionChecks[typ]; !ok {
+		return c, ErrNotSupported
+	}
+
+	switch {
+	case value == "":
+		return c, ErrWrongVal
+	case strings.HasPrefix(typ, "Path") || typ == "DirectoryNotEmpty" || strings.HasPrefix(typ, "File"):
+		if !filepath.IsAbs(value) {
+			return c, ErrPathNotAbs
+		}
+	case typ == "Virtualization":
+		if _, err := parseBoolean(value); err != nil && !virtualizations[value] {
+			return c, ErrWrongVal
+		}
+	case typ == "Architecture":
+		if !architectures[value] && value != "native" {
+			return c, ErrWrongVal
+		}
+	case typ == "FirstBoot":
+		if _, err := parseBoolean(value); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// Option returns the name of the option c was specified by
+func (c Condition) Option() string {
+	if c.Assert {
+		return "Assert" + c.Type
+	}
+	return "Condition" + c.Type
+}
+
+// String returns c in the form of an option assignment
+func (c Condition) String() (s string) {
+	s = c.Option() + "="
+	if c.Trigger {
+		s += "|"
+	}
+	if c.Negate {
+		s += "!"
+	}
+	return s + c.Value
+}
+
+// Check reports whether c passes
+func (c Condition) Check() bool {
+	check, ok := conditionChecks[c.Type]
+	if !ok {
+		return false
+	}
+	return check(c.Value) != c.Negate
+}
+
+// CheckConditions checks conds and reports whether all of the non-triggering
+// conditions and at least one of the triggering ones, if any, pass.
+// If not, failed is the condition causing the failure
+func CheckConditions(conds []Condition) (ok bool, failed *Condition) {
+	var triggered, hasTriggers bool
+	for i, c := range conds {
+		if !c.Trigger {
+			if !c.Check() {
+				return false, &conds[i]
+			}
+			continue
+		}
+
+		hasTriggers = true
+		if !triggered {
+			if triggered = c.Check(); !triggered {
+				failed = &conds[i]
+			}
+		}
+	}
+
+	if hasTriggers && !triggered {
+		return false, failed
+	}
+	return true, nil
+}
+
+// Conditions returns the valid conditions and assertions as found in Definition
+func (def Definition) Conditions() (conds []Condition) {
+	conds, _ = def.ParseConditions()
+	return
+}
+
+// ParseConditions parses the conditions and assertions as found in Definition
+func (def Definition) ParseConditions() (conds []Condition, merr MultiError) {
+	v := reflect.ValueOf(def.Unit)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+
+		var typ string
+		var assert bool
+		switch {
+		case strings.HasPrefix(name, "Condition"):
+			typ = strings.TrimPrefix(name, "Condition")
+		case strings.HasPrefix(name, "Assert"):
+			typ, assert = strings.TrimPrefix(name, "Assert"), true
+		default:
+			continue
+		}
+
+		for _, value := range v.Field(i).Interface().(Lines) {
+			c, err := ParseCondition(typ, value, assert)
+			if err != nil {
+				merr = append(merr, ParseErr(name, ParseErr(value, err)))
+				continue
+			}
+			conds = append(conds, c)
+		}
+	}
+	return
+}
+
+// parseBoolean parses boolean values accepted in unit files
+func parseBoolean(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "yes", "y", "true", "t", "on":
+		return true, nil
+	case "0", "no", "n", "false", "f", "off":
+		return false, nil
+	default:
+		return false, ErrWrongVal
+	}
+}
+
+func checkPathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func checkPathExistsGlob(pattern string) bool {
+	matches, err := filepath.Glob(pattern)
+	return err == nil && len(matches) > 0
+}
+
+func checkPathIsDirectory(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+func checkPathIsSymbolicLink(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.Mode()&os.ModeSymlink != 0
+}
+
+func checkPathIsMountPoint(path string) bool {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	f, err := os.Open(mountinfoPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	// Fifth field of each line is the mount point(see proc(5))
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fields := strings.Fields(s.Text()); len(fields) > 4 && unescapeOctal(fields[4]) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeOctal replaces octal escape sequences like "\040" in s by the characters they encode
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(n))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+func checkPathIsReadWrite(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return st.Flags&ST_RDONLY == 0
+}
+
+func checkDirectoryNotEmpty(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(1)
+	return err == nil && len(names) > 0
+}
+
+func checkFileNotEmpty(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() > 0
+}
+
+func checkFileIsExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0
+}
+
+func checkHost(value string) bool {
+	if hostname, err := os.Hostname(); err == nil {
+		if ok, _ := filepath.Match(strings.ToLower(value), strings.ToLower(hostname)); ok {
+			return true
+		}
+	}
+	return machineID() == strings.ToLower(value)
+}
+
+// machineID returns the machine ID of the system, empty if not initialized
+func machineID() string {
+	b, err := ioutil.ReadFile(machineIDPath)
+	if err != nil {
+		return ""
+	}
+	if id := strings.TrimSpace(string(b)); id != "uninitialized" {
+		return id
+	}
+	return ""
+}
+
+func checkKernelCommandLine(value string) bool {
+	b, err := ioutil.ReadFile(cmdlinePath)
+	if err != nil {
+		return false
+	}
+
+	for _, word := range strings.Fields(string(b)) {
+		if word == value || !strings.Contains(value, "=") && strings.HasPrefix(word, value+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func checkKernelVersion(value string) bool {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return false
+	}
+
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+
+	for _, op := range []string{"<=", ">=", "!=", "<", ">", "="} {
+		if strings.HasPrefix(value, op) {
+			cmp := compareVersions(string(release), strings.TrimSpace(value[len(op):]))
+			switch op {
+			case "<=":
+				return cmp <= 0
+			case ">=":
+				return cmp >= 0
+			case "!=":
+				return cmp != 0
+			case "<":
+				return cmp < 0
+			case ">":
+				return cmp > 0
+			default:
+				return cmp == 0
+			}
+		}
+	}
+
+	ok, _ := filepath.Match(value, string(release))
+	return ok
+}
+
+// compareVersions compares version strings a and b by their numeric and non-numeric
+// components and returns -1, 0 or 1 if a is lower, equal or greater than b respectively
+func compareVersions(a, b string) int {
+	for a != "" || b != "" {
+		a, b = strings.TrimLeft(a, ".-_~+"), strings.TrimLeft(b, ".-_~+")
+
+		var x, y string
+		x, a = versionComponent(a)
+		y, b = versionComponent(b)
+
+		nx, errx := strconv.Atoi(x)
+		ny, erry := strconv.Atoi(y)
+		switch {
+		case errx == nil && erry == nil && nx != ny:
+			if nx < ny {
+				return -1
+			}
+			return 1
+		case (errx != nil || erry != nil) && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionComponent splits the leading run of digits or non-digits off s
+func versionComponent(s string) (component, rest string) {
+	if s == "" {
+		return "", ""
+	}
+
+	digit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isDigit := digit(s[0])
+
+	i := 0
+	for i < len(s) && digit(s[i]) == isDigit && !strings.ContainsRune(".-_~+", rune(s[i])) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// Architectures recognized by ConditionArchitecture=
+var architectures = map[string]bool{
+	"x86": true, "x86-64": true, "arm": true, "arm64": true,
+	"ppc64": true, "ppc64-le": true, "s390x": true, "mips": true,
+	"mips-le": true, "mips64": true, "mips64-le": true, "riscv64": true,
+	"loongarch64": true,
+}
+
+// Architecture returns the name of the architecture of the system, as used by ConditionArchitecture=
+func Architecture() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "x86"
+	case "amd64":
+		return "x86-64"
+	case "ppc64le":
+		return "ppc64-le"
+	case "mipsle":
+		return "mips-le"
+	case "mips64le":
+		return "mips64-le"
+	case "loong64":
+		return "loongarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+func checkArchitecture(value string) bool {
+	return value == "native" || value == Architecture()
+}
+
+func checkEnvironment(value string) bool {
+	for _, kv := range os.Environ() {
+		if kv == value || !strings.Contains(value, "=") && strings.HasPrefix(kv, value+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func checkUser(value string) bool {
+	uid, euid := os.Getuid(), os.Geteuid()
+
+	if value == "@system" {
+		return uid <= SYSTEM_UID_MAX || euid <= SYSTEM_UID_MAX
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		u, err := user.Lookup(value)
+		if err != nil {
+			return false
+		}
+		if id, err = strconv.Atoi(u.Uid); err != nil {
+			return false
+		}
+	}
+	return id == uid || id == euid
+}
+
+func checkGroup(value string) bool {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		g, err := user.LookupGroup(value)
+		if err != nil {
+			return false
+		}
+		if id, err = strconv.Atoi(g.Gid); err != nil {
+			return false
+		}
+	}
+
+	if id == os.Getgid() || id == os.Getegid() {
+		return true
+	}
+	groups, _ := os.Getgroups()
+	for _, gid := range groups {
+		if gid == id {
+			return true
+		}
+	}
+	return false
+}
+
+func checkFirstBoot(value string) bool {
+	want, err := parseBoolean(value)
+	if err != nil {
+		return false
+	}
+	return (machineID() == "") == want
+}
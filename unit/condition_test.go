@@ -0,0 +1,155 @@
+package unit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := unit.ParseCondition("PathExists", "|!/etc", true)
+	require.NoError(t, err)
+	assert.Equal(t, unit.Condition{Type: "PathExists", Value: "/etc", Assert: true, Trigger: true, Negate: true}, c)
+	assert.Equal(t, "AssertPathExists=|!/etc", c.String())
+
+	for _, test := range []struct {
+		typ, value string
+		err        error
+	}{
+		{"PathExists", "etc", unit.ErrPathNotAbs},
+		{"FileNotEmpty", "!", unit.ErrWrongVal},
+		{"Architecture", "pdp11", unit.ErrWrongVal},
+		{"Virtualization", "abacus", unit.ErrWrongVal},
+		{"FirstBoot", "maybe", unit.ErrWrongVal},
+		{"Moon", "full", unit.ErrNotSupported},
+	} {
+		_, err := unit.ParseCondition(test.typ, test.value, false)
+		assert.Equal(t, test.err, err, test.typ+"="+test.value)
+	}
+}
+
+func TestCheckConditions(t *testing.T) {
+	exists, missing := os.TempDir(), filepath.Join(os.TempDir(), "systemgo-missing")
+
+	cond := func(value string) unit.Condition {
+		c, err := unit.ParseCondition("PathExists", value, false)
+		require.NoError(t, err, value)
+		return c
+	}
+
+	for _, test := range []struct {
+		values []string
+		ok     bool
+	}{
+		{nil, true},
+		{[]string{exists}, true},
+		{[]string{exists, missing}, false},
+		{[]string{"!" + missing}, true},
+		{[]string{"|" + missing, "|" + exists}, true},
+		{[]string{"|" + missing, "|!" + exists}, false},
+		{[]string{exists, "|" + missing, "|" + missing}, false},
+	} {
+		var conds []unit.Condition
+		for _, v := range test.values {
+			conds = append(conds, cond(v))
+		}
+
+		ok, failed := unit.CheckConditions(conds)
+		assert.Equal(t, test.ok, ok, "%v", test.values)
+		if ok {
+			assert.Nil(t, failed, "%v", test.values)
+		} else if assert.NotNil(t, failed, "%v", test.values) {
+			assert.False(t, failed.Check(), "%v", test.values)
+		}
+	}
+}
+
+func TestConditionTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-condition-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	empty, script, link := filepath.Join(dir, "empty"), filepath.Join(dir, "script"), filepath.Join(dir, "link")
+	require.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0755))
+	require.NoError(t, os.Symlink(script, link))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+
+	os.Setenv("SYSTEMGO_CONDITION", "1")
+	defer os.Unsetenv("SYSTEMGO_CONDITION")
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	for typ, values := range map[string]map[string]bool{
+		"PathExistsGlob":     {dir + "/scr*": true, dir + "/none*": false},
+		"PathIsDirectory":    {dir: true, script: false},
+		"PathIsSymbolicLink": {link: true, script: false},
+		"PathIsMountPoint":   {"/": true, dir + "/sub": false},
+		"DirectoryNotEmpty":  {dir: true, dir + "/sub": false, script: false},
+		"FileNotEmpty":       {script: true, empty: false, dir: false},
+		"FileIsExecutable":   {script: true, link: true, empty: false},
+		"Architecture":       {"native": true, unit.Architecture(): true},
+		"Environment":        {"SYSTEMGO_CONDITION": true, "SYSTEMGO_CONDITION=1": true, "SYSTEMGO_CONDITION=2": false},
+		"User":               {strconv.Itoa(os.Getuid()): true, strconv.Itoa(os.Getuid() + 1): false},
+		"Group":              {strconv.Itoa(os.Getgid()): true},
+		"Host":               {strings.ToUpper(hostname): true, hostname + "-other": false},
+		"KernelVersion":      {">=1.0": true, "<1.0": false, "*": true},
+		"KernelCommandLine":  {"systemgo.nonexistent": false},
+	} {
+		for value, expected := range values {
+			c, err := unit.ParseCondition(typ, value, false)
+			if assert.NoError(t, err, typ+"="+value) {
+				assert.Equal(t, expected, c.Check(), typ+"="+value)
+			}
+		}
+	}
+
+	yes, err := unit.ParseCondition("Virtualization", "yes", false)
+	require.NoError(t, err)
+	no, err := unit.ParseCondition("Virtualization", "no", false)
+	require.NoError(t, err)
+	assert.NotEqual(t, yes.Check(), no.Check(), "Virtualization")
+
+	kind, _ := unit.Virtualization()
+	assert.Equal(t, kind != "", yes.Check(), "Virtualization")
+}
+
+func TestDefinitionConditions(t *testing.T) {
+	def := unit.NewDefinition()
+	require.NoError(t, unit.ParseDefinition(strings.NewReader(`[Unit]
+ConditionPathExists=/etc
+ConditionPathExists=|!/nonexistent
+AssertArchitecture=native`), &def))
+
+	conds, merr := def.ParseConditions()
+	assert.Empty(t, merr)
+	assert.Equal(t, []unit.Condition{
+		{Type: "PathExists", Value: "/etc"},
+		{Type: "PathExists", Value: "/nonexistent", Trigger: true, Negate: true},
+		{Type: "Architecture", Value: "native", Assert: true},
+	}, conds)
+	assert.Equal(t, conds, def.Conditions())
+
+	def = unit.NewDefinition()
+	require.NoError(t, unit.ParseDefinition(strings.NewReader(`[Unit]
+ConditionPathExists=etc
+AssertFirstBoot=sometimes`), &def))
+
+	_, merr = def.ParseConditions()
+	if assert.Len(t, merr, 2) {
+		assert.Equal(t, "ConditionPathExists", merr[0].(unit.ParseError).Source)
+		assert.Equal(t, "AssertFirstBoot", merr[1].(unit.ParseError).Source)
+	}
+
+	st := unit.Status{Condition: "ConditionPathExists=/nonexistent", Assert: "AssertHost=other"}
+	assert.Contains(t, st.String(), "\nCondition: start condition failed (ConditionPathExists=/nonexistent was not met)")
+	assert.Contains(t, st.String(), "\nAssert: start assertion failed (AssertHost=other was not met)")
+}
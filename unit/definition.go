@@ -6,22 +6,76 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-systemd/unit"
 )
 
+// Default start rate limiting settings
+const (
+	DEFAULT_START_LIMIT_INTERVAL = 10 * time.Second
+	DEFAULT_START_LIMIT_BURST    = 5
+)
+
 // Definition of a unit matching the fields found in unit-file
 type Definition struct {
 	Unit struct {
 		Description                               string
 		Documentation                             string
 		Wants, Requires, Conflicts, Before, After []string
+
+		StartLimitIntervalSec time.Duration
+		StartLimitBurst       int
+		StartLimitAction      string
+
+		ConditionPathExists, ConditionPathExistsGlob          Lines
+		ConditionPathIsDirectory, ConditionPathIsSymbolicLink Lines
+		ConditionPathIsMountPoint, ConditionPathIsReadWrite   Lines
+		ConditionDirectoryNotEmpty, ConditionFileNotEmpty     Lines
+		ConditionFileIsExecutable, ConditionVirtualization    Lines
+		ConditionHost, ConditionKernelCommandLine             Lines
+		ConditionKernelVersion, ConditionArchitecture         Lines
+		ConditionEnvironment, ConditionUser, ConditionGroup   Lines
+		ConditionFirstBoot                                    Lines
+		AssertPathExists, AssertPathExistsGlob                Lines
+		AssertPathIsDirectory, AssertPathIsSymbolicLink       Lines
+		AssertPathIsMountPoint, AssertPathIsReadWrite         Lines
+		AssertDirectoryNotEmpty, AssertFileNotEmpty           Lines
+		AssertFileIsExecutable, AssertVirtualization          Lines
+		AssertHost, AssertKernelCommandLine                   Lines
+		AssertKernelVersion, AssertArchitecture               Lines
+		AssertEnvironment, AssertUser, AssertGroup            Lines
+		AssertFirstBoot                                       Lines
 	}
 	Install struct {
 		WantedBy, RequiredBy []string
 	}
 }
 
+// Actions a unit can request the system to perform, e.g. using StartLimitAction= (see systemd.unit(5))
+var Actions = map[string]bool{
+	"none":               true,
+	"reboot":             true,
+	"reboot-force":       true,
+	"reboot-immediate":   true,
+	"poweroff":           true,
+	"poweroff-force":     true,
+	"poweroff-immediate": true,
+	"halt":               true,
+	"halt-force":         true,
+	"halt-immediate":     true,
+	"exit":               true,
+	"exit-force":         true,
+}
+
+// NewDefinition returns a Definition with default values set
+func NewDefinition() (def Definition) {
+	def.Unit.StartLimitIntervalSec = DEFAULT_START_LIMIT_INTERVAL
+	def.Unit.StartLimitBurst = DEFAULT_START_LIMIT_BURST
+	def.Unit.StartLimitAction = "none"
+	return
+}
+
 // Description returns a string as found in Definition
 func (def Definition) Description() string {
 	return def.Unit.Description
@@ -67,6 +121,35 @@ func (def Definition) WantedBy() []string {
 	return def.Install.WantedBy
 }
 
+// Lines holds values of an option, which can be specified multiple times.
+// Each assignment appends a line to the list, an empty assignment resets the list
+type Lines []string
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// StartLimitInterval returns the interval start rate limiting is applied in as found in Definition
+func (def Definition) StartLimitInterval() time.Duration {
+	return def.Unit.StartLimitIntervalSec
+}
+
+// StartLimitBurst returns the number of starts allowed in start limit interval as found in Definition
+func (def Definition) StartLimitBurst() int {
+	return def.Unit.StartLimitBurst
+}
+
+// StartLimitAction returns the action to take when start limit is hit as found in Definition
+func (def Definition) StartLimitAction() string {
+	return def.Unit.StartLimitAction
+}
+
+// CheckStartLimit returns the errors in the start rate limiting settings found in Definition
+func (def Definition) CheckStartLimit() (merr MultiError) {
+	if !Actions[def.Unit.StartLimitAction] {
+		merr = append(merr, ParseErr("StartLimitAction", ParseErr(def.Unit.StartLimitAction, ErrNotSupported)))
+	}
+	return
+}
+
 // ParseDefinition parses the data in Systemd unit-file format and stores the result in value pointed by Definition
 func ParseDefinition(r io.Reader, v interface{}) (err error) {
 	// Access the underlying value of the pointer
@@ -89,18 +172,47 @@ func ParseDefinition(r io.Reader, v interface{}) (err error) {
 				// reflect.Kind of field in Definition
 				switch v.Kind() {
 
+				case reflect.Int64:
+					if v.Type() != durationType {
+						return ParseErr(opt.Name, ErrUnknownType)
+					}
+
+					d, err := ParseTimespan(opt.Value)
+					if err != nil {
+						return ParseErr(opt.Name, err)
+					}
+					v.SetInt(int64(d))
+
+				case reflect.Int:
+					i, err := strconv.Atoi(opt.Value)
+					if err != nil {
+						return ParseErr(opt.Name, err)
+					}
+					v.SetInt(int64(i))
+
 				case reflect.String:
 					v.SetString(opt.Value)
 
 				case reflect.Bool:
-					if opt.Value == "yes" {
+					switch opt.Value {
+					case "yes":
 						v.SetBool(true)
-					} else if opt.Value != "no" {
+					case "no":
+						v.SetBool(false)
+					default:
 						return ParseErr(opt.Name, errors.New(`Value should be "yes" or "no"`))
 					}
 
 				case reflect.Slice:
-					if _, ok := v.Interface().([]string); ok { // []string
+					if lines, ok := v.Interface().(Lines); ok { // Lines
+						if opt.Value == "" {
+							lines = nil
+						} else {
+							lines = append(lines, opt.Value)
+						}
+						v.Set(reflect.ValueOf(lines))
+
+					} else if _, ok := v.Interface().([]string); ok { // []string
 						v.Set(reflect.ValueOf(strings.Fields(opt.Value)))
 
 					} else if _, ok := v.Interface().([]int); ok { // []int
@@ -5,9 +5,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/plasma-umass/systemgo/unit"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var DEFAULT_INTS = []int{1, 2, 3}
@@ -23,6 +25,8 @@ Conflicts=Conflicts
 Before=Before
 After=After
 
+StartLimitAction=StartLimitAction
+
 [Install]
 WantedBy=WantedBy
 RequiredBy=RequiredBy`
@@ -142,6 +146,65 @@ Bool=foo`),
 	}
 }
 
+func TestParseDefinitionNumeric(t *testing.T) {
+	def := &struct {
+		unit.Definition
+		Test struct {
+			Int      int
+			Duration time.Duration
+		}
+	}{}
+
+	if assert.NoError(t, unit.ParseDefinition(strings.NewReader(`[Test]
+Int=42
+Duration=1min 30s`), def), "ParseDefinition") {
+		assert.Equal(t, 42, def.Test.Int, "int")
+		assert.Equal(t, 90*time.Second, def.Test.Duration, "time.Duration")
+	}
+
+	assert.Error(t, unit.ParseDefinition(strings.NewReader(`[Test]
+Int=foo`), def), "ParseDefinition with wrong int")
+	assert.Error(t, unit.ParseDefinition(strings.NewReader(`[Test]
+Duration=foo`), def), "ParseDefinition with wrong time.Duration")
+}
+
+func TestParseDefinitionLines(t *testing.T) {
+	def := &struct {
+		unit.Definition
+		Test struct {
+			Lines unit.Lines
+		}
+	}{}
+
+	if assert.NoError(t, unit.ParseDefinition(strings.NewReader(`[Test]
+Lines=/bin/echo first
+Lines=/bin/echo second`), def), "ParseDefinition") {
+		assert.Equal(t, unit.Lines{"/bin/echo first", "/bin/echo second"}, def.Test.Lines)
+	}
+
+	def.Test.Lines = nil
+	if assert.NoError(t, unit.ParseDefinition(strings.NewReader(`[Test]
+Lines=/bin/echo first
+Lines=
+Lines=/bin/echo second`), def), "ParseDefinition with reset") {
+		assert.Equal(t, unit.Lines{"/bin/echo second"}, def.Test.Lines)
+	}
+}
+
+func TestCheckStartLimit(t *testing.T) {
+	def := unit.NewDefinition()
+	assert.Empty(t, def.CheckStartLimit(), "default")
+
+	// Options are only parsed by ParseDefinition, the values are checked afterwards
+	require.NoError(t, unit.ParseDefinition(strings.NewReader(DEFAULT_UNIT), &def))
+	if merr := def.CheckStartLimit(); assert.Len(t, merr, 1) {
+		assert.Equal(t, "StartLimitAction", merr[0].(unit.ParseError).Source)
+	}
+
+	def.Unit.StartLimitAction = "reboot-force"
+	assert.Empty(t, def.CheckStartLimit())
+}
+
 func interfaceOf(val reflect.Value) interface{} {
 	return val.Interface()
 }
@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrInvalidAssignment = errors.New("Invalid environment variable assignment")
+
+// ParseEnvironment parses a value of Environment= option, which is a list of
+// "KEY=VALUE" assignments separated by whitespace and quoted according to
+// systemd quoting rules (see systemd.exec(5))
+func ParseEnvironment(line string) (env []string, err error) {
+	var words []word
+	if words, err = splitWords(line); err != nil {
+		return
+	}
+
+	for _, w := range words {
+		if i := strings.IndexByte(w.value, '='); i <= 0 || !ValidEnvName(w.value[:i]) {
+			return nil, ParseErr(w.value, ErrInvalidAssignment)
+		}
+		env = append(env, w.value)
+	}
+	return
+}
+
+// ReadEnvironmentFile parses a file containing newline-separated "KEY=VALUE" assignments.
+// Empty lines and lines starting with "#" or ";" are ignored, values may be enclosed in
+// single or double quotes and lines ending with a backslash are continued on the next line
+func ReadEnvironmentFile(r io.Reader) (env []string, err error) {
+	scanner := bufio.NewScanner(r)
+
+	var line string
+	for n := 1; scanner.Scan(); n++ {
+		line += scanner.Text()
+
+		if trailingBackslashes(line)%2 == 1 {
+			// Line continuation
+			line = line[:len(line)-1]
+			continue
+		}
+
+		assignment := strings.TrimSpace(line)
+		line = ""
+
+		if assignment == "" || assignment[0] == '#' || assignment[0] == ';' {
+			continue
+		}
+
+		var kv string
+		if kv, err = parseAssignment(assignment); err != nil {
+			return nil, ParseErr(fmt.Sprintf("line %d", n), ParseErr(assignment, err))
+		}
+		env = append(env, kv)
+	}
+	return env, scanner.Err()
+}
+
+func trailingBackslashes(s string) (n int) {
+	for i := len(s) - 1; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return
+}
+
+// parseAssignment parses a single line of an environment file
+func parseAssignment(s string) (kv string, err error) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return "", ErrInvalidAssignment
+	}
+
+	key := strings.TrimSpace(s[:i])
+	if !ValidEnvName(key) {
+		return "", ErrInvalidAssignment
+	}
+
+	s = strings.TrimSpace(s[i+1:])
+
+	var value []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return "", ErrUnterminatedQuote
+			}
+			value = append(value, s[i+1:i+1+j]...)
+			i += j + 1
+
+		case '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) && strings.IndexByte("\"\\`$", s[j+1]) >= 0 {
+					j++
+				}
+				value = append(value, s[j])
+			}
+			if j == len(s) {
+				return "", ErrUnterminatedQuote
+			}
+			i = j
+
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				value = append(value, s[i])
+			}
+
+		default:
+			value = append(value, c)
+		}
+	}
+	return key + "=" + string(value), nil
+}
+
+// ValidEnvName reports whether s is a valid environment variable name
+func ValidEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package unit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvironment(t *testing.T) {
+	env, err := unit.ParseEnvironment(`ONE=1 "TWO=two words" THREE='"quoted"' EMPTY=`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"ONE=1", "TWO=two words", `THREE="quoted"`, "EMPTY="}, env)
+	}
+
+	for _, line := range []string{`ONE`, `=1`, `1ONE=1`, `ONE="1`} {
+		_, err := unit.ParseEnvironment(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestReadEnvironmentFile(t *testing.T) {
+	env, err := unit.ReadEnvironmentFile(strings.NewReader(`# comment
+; comment
+
+ONE=1
+ TWO = two words  
+THREE="double \"quoted\" \$value"
+FOUR='single "quoted" \value'
+FIVE=continued \
+line
+SIX=escaped\ space
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			"ONE=1",
+			"TWO=two words",
+			`THREE=double "quoted" $value`,
+			`FOUR=single "quoted" \value`,
+			"FIVE=continued line",
+			"SIX=escaped space",
+		}, env)
+	}
+
+	_, err = unit.ReadEnvironmentFile(strings.NewReader("ONE=1\nTWO\n"))
+	if pe, ok := err.(unit.ParseError); assert.True(t, ok, "error is ParseError") {
+		assert.Equal(t, "line 2", pe.Source)
+	}
+
+	_, err = unit.ReadEnvironmentFile(strings.NewReader(`ONE="1`))
+	assert.Error(t, err, "unterminated quote")
+}
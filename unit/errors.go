@@ -13,6 +13,7 @@ var ErrPathNotAbs = errors.New("Path specified is not absolute")
 var ErrNotParsed = errors.New("Unit definition is not parsed properly")
 var ErrWrongVal = errors.New("Wrong value received")
 var ErrNotStarted = errors.New("Unit not started")
+var ErrMalformedStat = errors.New("Malformed process status")
 
 type ParseError struct {
 	Source string
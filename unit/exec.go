@@ -0,0 +1,347 @@
+package unit
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrUnterminatedQuote = errors.New("Unterminated quote")
+var ErrInvalidEscape = errors.New("Invalid escape sequence")
+var ErrNoCommand = errors.New("No command specified")
+var ErrInvalidPrefix = errors.New("Invalid command prefix")
+
+// ExecCommand is a single command specified in a command line of an Exec*= option
+// (see systemd.service(5))
+type ExecCommand struct {
+	// Path to the executable. If it does not contain a slash, it is searched for in PATH
+	Path string
+
+	// Arguments of the command, including argv[0]
+	Argv []string
+
+	// Prefix "-": failure of the command is ignored
+	IgnoreFailure bool
+
+	// Prefix "+": the command is run with full privileges, sandboxing and
+	// credential options are not applied
+	FullPrivileges bool
+
+	// Prefix "!": the command is run with the credentials of the manager,
+	// User=, Group= and SupplementaryGroups= are not applied
+	NoSetCredentials bool
+
+	// Prefix "!!": same as "!", but only applies on systems without ambient capabilities support
+	AmbientFallback bool
+
+	// Prefix ":": environment variables are not substituted
+	NoExpand bool
+}
+
+// ParseCommandLine parses a command line of an Exec*= option, which may contain several
+// commands separated by a standalone ";", according to systemd quoting rules.
+// Returned errors are ParseErrors, which specify the part of the line the error occurred in
+func ParseCommandLine(line string) (cmds []ExecCommand, err error) {
+	var split [][]string
+	if split, err = splitCommands(line); err != nil {
+		return
+	}
+
+	for _, words := range split {
+		var cmd ExecCommand
+		if cmd, err = parseCommand(words); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	if len(cmds) == 0 {
+		return nil, ParseErr(line, ErrNoCommand)
+	}
+	return
+}
+
+// parseCommand parses a command from words, the first of which may contain exec prefixes
+func parseCommand(words []string) (cmd ExecCommand, err error) {
+	if len(words) == 0 {
+		return cmd, ParseErr(";", ErrNoCommand)
+	}
+
+	var argv0 bool
+	path := words[0]
+
+prefixes:
+	for ; path != ""; path = path[1:] {
+		switch path[0] {
+		case '-':
+			cmd.IgnoreFailure = true
+		case '@':
+			argv0 = true
+		case ':':
+			cmd.NoExpand = true
+		case '+':
+			if cmd.NoSetCredentials || cmd.AmbientFallback {
+				return cmd, ParseErr(words[0], ErrInvalidPrefix)
+			}
+			cmd.FullPrivileges = true
+		case '!':
+			switch {
+			case cmd.FullPrivileges || cmd.AmbientFallback:
+				return cmd, ParseErr(words[0], ErrInvalidPrefix)
+			case cmd.NoSetCredentials:
+				cmd.NoSetCredentials = false
+				cmd.AmbientFallback = true
+			default:
+				cmd.NoSetCredentials = true
+			}
+		default:
+			break prefixes
+		}
+	}
+
+	switch {
+	case path == "":
+		return cmd, ParseErr(words[0], ErrNoCommand)
+	case strings.ContainsRune(path, '/') && !filepath.IsAbs(path):
+		return cmd, ParseErr(path, ErrPathNotAbs)
+	}
+	cmd.Path = path
+
+	if argv0 {
+		if len(words) < 2 {
+			return cmd, ParseErr(words[0], ErrNoCommand)
+		}
+		cmd.Argv = append([]string{}, words[1:]...)
+	} else {
+		cmd.Argv = append([]string{path}, words[1:]...)
+	}
+	return
+}
+
+// splitCommands splits line into words of the commands it contains.
+// Only an unquoted and unescaped standalone ";" separates commands
+func splitCommands(line string) (cmds [][]string, err error) {
+	var ws []word
+	if ws, err = splitWords(line); err != nil {
+		return
+	}
+
+	var words []string
+	for _, w := range ws {
+		if w.plain && w.value == ";" {
+			cmds = append(cmds, words)
+			words = nil
+			continue
+		}
+		words = append(words, w.value)
+	}
+
+	if len(words) > 0 || len(cmds) > 0 {
+		cmds = append(cmds, words)
+	}
+	return
+}
+
+// word is a word of a line split according to systemd quoting rules
+type word struct {
+	value string
+
+	// Whether the word contains no quotes or escape sequences
+	plain bool
+}
+
+// splitWords splits line into words separated by whitespace, unquoting the words
+// and resolving escape sequences
+func splitWords(line string) (words []word, err error) {
+	var w []byte
+	var inWord, plain bool
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word{string(w), plain})
+		}
+		w, inWord = nil, false
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		if !inWord {
+			inWord, plain = true, true
+		}
+
+		switch {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '\n':
+			// Line continuation
+			i++
+			fallthrough
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(w) == 0 && plain {
+				// Not a word yet
+				inWord = false
+			}
+			endWord()
+
+		case c == '"' || c == '\'':
+			plain = false
+
+			j := i + 1
+			for ; j < len(line) && line[j] != c; j++ {
+				if line[j] != '\\' || c == '\'' {
+					w = append(w, line[j])
+					continue
+				}
+
+				var n int
+				if w, n, err = unescape(w, line[j:]); err != nil {
+					return nil, ParseErr(line[j:], err)
+				}
+				j += n - 1
+			}
+			if j == len(line) {
+				return nil, ParseErr(line[i:], ErrUnterminatedQuote)
+			}
+			i = j
+
+		case c == '\\':
+			plain = false
+
+			if i+1 < len(line) && line[i+1] == ';' {
+				w = append(w, ';')
+				i++
+				continue
+			}
+
+			var n int
+			if w, n, err = unescape(w, line[i:]); err != nil {
+				return nil, ParseErr(line[i:], err)
+			}
+			i += n - 1
+
+		default:
+			w = append(w, c)
+		}
+	}
+	endWord()
+
+	return
+}
+
+var simpleEscapes = map[byte]byte{
+	'a':  '\a',
+	'b':  '\b',
+	'f':  '\f',
+	'n':  '\n',
+	'r':  '\r',
+	't':  '\t',
+	'v':  '\v',
+	's':  ' ',
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
+
+// unescape appends the character represented by the escape sequence s starts with to buf.
+// The number of bytes of s consumed is returned
+func unescape(buf []byte, s string) (_ []byte, n int, err error) {
+	if len(s) < 2 {
+		return buf, 0, ErrInvalidEscape
+	}
+
+	if c, ok := simpleEscapes[s[1]]; ok {
+		return append(buf, c), 2, nil
+	}
+
+	var digits, base int
+	switch s[1] {
+	case 'x':
+		digits, base, n = 2, 16, 2
+	case 'u':
+		digits, base, n = 4, 16, 2
+	case 'U':
+		digits, base, n = 8, 16, 2
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		digits, base, n = 3, 8, 1
+	default:
+		return buf, 0, ErrInvalidEscape
+	}
+
+	if len(s) < n+digits {
+		return buf, 0, ErrInvalidEscape
+	}
+
+	var v uint64
+	if v, err = strconv.ParseUint(s[n:n+digits], base, 32); err != nil {
+		return buf, 0, ErrInvalidEscape
+	}
+
+	switch {
+	case v == 0:
+		// NUL bytes can not be passed as arguments
+		return buf, 0, ErrInvalidEscape
+	case s[1] == 'u' || s[1] == 'U':
+		if !utf8.ValidRune(rune(v)) {
+			return buf, 0, ErrInvalidEscape
+		}
+		var b [utf8.UTFMax]byte
+		return append(buf, b[:utf8.EncodeRune(b[:], rune(v))]...), n + digits, nil
+	case v > 0xff:
+		return buf, 0, ErrInvalidEscape
+	default:
+		return append(buf, byte(v)), n + digits, nil
+	}
+}
+
+// Expand returns the arguments of the command with environment variables from env,
+// specified as "key=value" pairs, substituted. "${VAR}" is replaced by the value of VAR,
+// while a standalone "$VAR" word is split on whitespace into zero or more words.
+// "$$" is replaced by a single "$". Undefined variables expand to an empty string
+func (cmd ExecCommand) Expand(env []string) (argv []string) {
+	if cmd.NoExpand {
+		return append([]string{}, cmd.Argv...)
+	}
+
+	vars := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	for _, arg := range cmd.Argv {
+		if len(arg) > 1 && arg[0] == '$' && ValidEnvName(arg[1:]) {
+			argv = append(argv, strings.Fields(vars[arg[1:]])...)
+			continue
+		}
+		argv = append(argv, expandVars(arg, vars))
+	}
+	return
+}
+
+// expandVars replaces "${VAR}" with the value of VAR and "$$" with "$" in s
+func expandVars(s string, vars map[string]string) string {
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] != '$' || i+1 == len(s):
+			buf = append(buf, s[i])
+		case s[i+1] == '$':
+			buf = append(buf, '$')
+			i++
+		case s[i+1] == '{':
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 || !ValidEnvName(s[i+2:i+j]) {
+				buf = append(buf, s[i])
+				continue
+			}
+			buf = append(buf, vars[s[i+2:i+j]]...)
+			i += j
+		default:
+			buf = append(buf, s[i])
+		}
+	}
+	return string(buf)
+}
@@ -0,0 +1,84 @@
+package unit_test
+
+import (
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	cases := []struct {
+		line string
+		cmds []unit.ExecCommand
+	}{
+		{`/bin/echo test`, []unit.ExecCommand{
+			{Path: "/bin/echo", Argv: []string{"/bin/echo", "test"}},
+		}},
+		{`/bin/echo "one two" 'three "four"' five\ssix`, []unit.ExecCommand{
+			{Path: "/bin/echo", Argv: []string{"/bin/echo", "one two", `three "four"`, "five six"}},
+		}},
+		{`/bin/echo "\t\x41\101é\s" "" a"b"c`, []unit.ExecCommand{
+			{Path: "/bin/echo", Argv: []string{"/bin/echo", "\tAAé ", "", "abc"}},
+		}},
+		{"/bin/echo one \\\n two", []unit.ExecCommand{
+			{Path: "/bin/echo", Argv: []string{"/bin/echo", "one", "two"}},
+		}},
+		{`-@/bin/sleep sleeper 1`, []unit.ExecCommand{
+			{Path: "/bin/sleep", Argv: []string{"sleeper", "1"}, IgnoreFailure: true},
+		}},
+		{`+/bin/true ; !/bin/true ; !!:/bin/true`, []unit.ExecCommand{
+			{Path: "/bin/true", Argv: []string{"/bin/true"}, FullPrivileges: true},
+			{Path: "/bin/true", Argv: []string{"/bin/true"}, NoSetCredentials: true},
+			{Path: "/bin/true", Argv: []string{"/bin/true"}, AmbientFallback: true, NoExpand: true},
+		}},
+		{`/bin/echo \; ";" a;`, []unit.ExecCommand{
+			{Path: "/bin/echo", Argv: []string{"/bin/echo", ";", ";", "a;"}},
+		}},
+		{`true`, []unit.ExecCommand{
+			{Path: "true", Argv: []string{"true"}},
+		}},
+	}
+
+	for _, c := range cases {
+		cmds, err := unit.ParseCommandLine(c.line)
+		if assert.NoError(t, err, c.line) {
+			assert.Equal(t, c.cmds, cmds, c.line)
+		}
+	}
+
+	errs := map[string]error{
+		``:                 unit.ErrNoCommand,
+		`-`:                unit.ErrNoCommand,
+		`@/bin/true`:       unit.ErrNoCommand,
+		`; /bin/true`:      unit.ErrNoCommand,
+		`+!/bin/true`:      unit.ErrInvalidPrefix,
+		`bin/true`:         unit.ErrPathNotAbs,
+		`/bin/echo "test`:  unit.ErrUnterminatedQuote,
+		`/bin/echo 'test`:  unit.ErrUnterminatedQuote,
+		`/bin/echo \q`:     unit.ErrInvalidEscape,
+		`/bin/echo "\x4"`:  unit.ErrInvalidEscape,
+		`/bin/echo \000`:   unit.ErrInvalidEscape,
+		`/bin/echo test \`: unit.ErrInvalidEscape,
+	}
+	for line, expected := range errs {
+		_, err := unit.ParseCommandLine(line)
+		if pe, ok := err.(unit.ParseError); assert.True(t, ok, "%q: error is ParseError", line) {
+			assert.Equal(t, expected, pe.Err, line)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	env := []string{"ONE=1", "MANY=a  b c", "EMPTY="}
+
+	cmds, err := unit.ParseCommandLine(`/bin/echo $ONE ${ONE}x "${MANY}" $MANY $EMPTY $$ONE ${UNSET}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"/bin/echo", "1", "1x", "a  b c", "a", "b", "c", "$ONE", ""}, cmds[0].Expand(env))
+	}
+
+	cmds, err = unit.ParseCommandLine(`:/bin/echo $ONE ${ONE}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"/bin/echo", "$ONE", "${ONE}"}, cmds[0].Expand(env))
+	}
+}
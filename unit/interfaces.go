@@ -1,6 +1,11 @@
 package unit
 
-import "io"
+import (
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+)
 
 type Interface interface {
 	Definer
@@ -43,6 +48,98 @@ type Reloader interface {
 	Reload() error
 }
 
+// ReloadChecker is implemented by any Reloader, which can only be reloaded if configured to
+type ReloadChecker interface {
+	CanReload() bool
+}
+
+// MainPIDer is implemented by any value that tracks a main process
+type MainPIDer interface {
+	MainPID() int
+}
+
+// StatusTexter is implemented by any value that reports a free-form status text
+type StatusTexter interface {
+	StatusText() string
+}
+
+// Notifier is implemented by any value that accepts notifications sent by its
+// processes over the notification socket(see sd_notify(3))
+type Notifier interface {
+	// Owns reports whether the process with pid specified belongs to the value
+	Owns(pid int) bool
+
+	// Notify processes the notification message sent by the process with pid specified
+	Notify(pid int, msg string) error
+}
+
+// Resulter is implemented by any value that records the outcome of its last run
+type Resulter interface {
+	// Result returns the result of the last run, e.g. "success" or "exit-code", empty if unknown
+	Result() string
+
+	// ExitStatus returns the exit status of the main process of the last run, nil if unknown
+	ExitStatus() *ExitStatus
+}
+
+// ProcessSupervisor is implemented by any value starting and waiting for the processes
+// of units, which reaps their orphaned descendants
+type ProcessSupervisor interface {
+	// Spawn starts a new process running cmd, which must not have been started before,
+	// and tracks it until it is waited for using Wait
+	Spawn(cmd *exec.Cmd) error
+
+	// Wait waits for the process started by Spawn to exit and stops tracking it
+	Wait(cmd *exec.Cmd) error
+}
+
+// ExitHandler is implemented by any value that handles the exits of its processes,
+// which were orphaned and reaped by the manager
+type ExitHandler interface {
+	ProcessExited(pid int, status syscall.WaitStatus)
+}
+
+// Cleaner is implemented by any value managing directories, which can be removed while it is inactive
+type Cleaner interface {
+	// Clean removes the directories of the kinds specified, e.g. "cache" or "runtime",
+	// or those of the default kinds if none are specified
+	Clean(what ...string) error
+}
+
+// AutoRestarter is implemented by any value that can request to be restarted automatically
+type AutoRestarter interface {
+	// AutoRestart returns a channel, which receives a value on each restart request
+	AutoRestart() <-chan struct{}
+
+	// NRestarts returns the number of restarts requested
+	NRestarts() int
+}
+
+// ResetFailer is implemented by any value that keeps state about its failures, which can be reset
+type ResetFailer interface {
+	ResetFailed()
+}
+
+// OutputLogger is implemented by any value running processes, output of which can be logged
+type OutputLogger interface {
+	// SetOutputLog sets the function each line of the output is passed to,
+	// along with its syslog priority(see syslog(3)) and identifier
+	SetOutputLog(fn func(priority int, identifier, line string))
+}
+
+// Propertier is implemented by any value that exposes its settings as properties
+type Propertier interface {
+	// Properties returns the settings mapped to the property names
+	Properties() map[string]string
+}
+
+// StartLimiter is implemented by any value that limits the rate it can be started at
+type StartLimiter interface {
+	StartLimitInterval() time.Duration
+	StartLimitBurst() int
+	StartLimitAction() string
+}
+
 type Dependency interface {
 	Wants() []string
 	Requires() []string
@@ -0,0 +1,62 @@
+package unit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcStat holds the fields of /proc/[pid]/stat used by the manager(see proc(5))
+type ProcStat struct {
+	State byte
+	PPID  int
+	PGID  int
+	SID   int
+
+	// Device number of the controlling terminal, 0 if none
+	TTY int
+
+	// Time the process started after system boot in clock ticks
+	Start uint64
+}
+
+// Zombie reports whether the process has exited and was not reaped yet, or is being reaped
+func (st ProcStat) Zombie() bool {
+	return st.State == 'Z' || st.State == 'X'
+}
+
+// ReadProcStat parses /proc/[pid]/stat
+func ReadProcStat(pid int) (st ProcStat, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); err != nil {
+		return
+	}
+
+	// Process name is enclosed in parentheses and may contain spaces
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return st, ErrMalformedStat
+	}
+
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 20 {
+		return st, ErrMalformedStat
+	}
+
+	st.State = fields[0][0]
+	if st.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return
+	}
+	if st.PGID, err = strconv.Atoi(fields[2]); err != nil {
+		return
+	}
+	if st.SID, err = strconv.Atoi(fields[3]); err != nil {
+		return
+	}
+	if st.TTY, err = strconv.Atoi(fields[4]); err != nil {
+		return
+	}
+	st.Start, err = strconv.ParseUint(fields[19], 10, 64)
+	return
+}
@@ -0,0 +1,527 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Address of the system bus, which is used if no bus address is specified
+const DEFAULT_BUS_ADDRESS = "unix:path=/run/dbus/system_bus_socket"
+
+// Maximum length of a message accepted from the bus (see D-Bus specification)
+const MAX_BUS_MESSAGE = 128 << 20
+
+// D-Bus message types
+const (
+	busMethodCall   = 1
+	busMethodReturn = 2
+	busError        = 3
+	busSignal       = 4
+)
+
+// D-Bus header field codes
+const (
+	busFieldPath        = 1
+	busFieldInterface   = 2
+	busFieldMember      = 3
+	busFieldErrorName   = 4
+	busFieldReplySerial = 5
+	busFieldDestination = 6
+	busFieldSender      = 7
+	busFieldSignature   = 8
+)
+
+// Name, object path and interface of the message bus itself
+const (
+	busName      = "org.freedesktop.DBus"
+	busPath      = "/org/freedesktop/DBus"
+	busInterface = "org.freedesktop.DBus"
+)
+
+// busMessage is a D-Bus message, the body of which consists of basic types only
+type busMessage struct {
+	Type        byte
+	Serial      uint32
+	ReplySerial uint32
+
+	Path, Interface, Member string
+	ErrorName               string
+	Destination, Sender     string
+
+	Signature string
+	Body      []interface{}
+}
+
+// busErr is an error reply received from the bus
+type busErr struct {
+	Name, Message string
+}
+
+func (err busErr) Error() string {
+	if err.Message == "" {
+		return err.Name
+	}
+	return err.Name + ": " + err.Message
+}
+
+// busConn is a minimal connection to a D-Bus message bus, which is only able to
+// call the methods of the bus itself and receive signals it emits
+type busConn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	serial uint32
+
+	// Signals received while waiting for a reply
+	signals []*busMessage
+}
+
+// parseBusAddress returns the network and address of the first supported
+// transport in D-Bus server address string specified
+func parseBusAddress(address string) (network, addr string, err error) {
+	for _, s := range strings.Split(address, ";") {
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) != 2 || kv[0] != "unix" {
+			continue
+		}
+
+		for _, param := range strings.Split(kv[1], ",") {
+			p := strings.SplitN(param, "=", 2)
+			if len(p) != 2 {
+				continue
+			}
+			if addr, err = url.PathUnescape(p[1]); err != nil {
+				return "", "", err
+			}
+
+			switch p[0] {
+			case "path":
+				return "unix", addr, nil
+			case "abstract":
+				return "unix", "@" + addr, nil
+			}
+		}
+	}
+	return "", "", ErrBusAddress
+}
+
+// dialBus connects to the bus at address specified, authenticates and registers on it
+func dialBus(address string) (bus *busConn, err error) {
+	network, addr, err := parseBusAddress(address)
+	if err != nil {
+		return
+	}
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return
+	}
+
+	bus = &busConn{conn: conn, r: bufio.NewReader(conn)}
+	if err = bus.auth(); err == nil {
+		_, err = bus.call("Hello", "")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return
+}
+
+// auth authenticates as the effective user using the EXTERNAL mechanism
+func (bus *busConn) auth() (err error) {
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Geteuid())))
+	if _, err = io.WriteString(bus.conn, "\x00AUTH EXTERNAL "+uid+"\r\n"); err != nil {
+		return
+	}
+
+	line, err := bus.r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return ErrBusAuth
+	}
+
+	_, err = io.WriteString(bus.conn, "BEGIN\r\n")
+	return
+}
+
+// Close closes the connection
+func (bus *busConn) Close() error {
+	return bus.conn.Close()
+}
+
+// call calls method of the bus with arguments of signature specified and returns the reply
+func (bus *busConn) call(method, signature string, args ...interface{}) (reply []interface{}, err error) {
+	bus.serial++
+	msg := &busMessage{
+		Type:        busMethodCall,
+		Serial:      bus.serial,
+		Path:        busPath,
+		Interface:   busInterface,
+		Member:      method,
+		Destination: busName,
+		Signature:   signature,
+		Body:        args,
+	}
+
+	b, err := msg.marshal()
+	if err != nil {
+		return
+	}
+	if _, err = bus.conn.Write(b); err != nil {
+		return
+	}
+
+	for {
+		if msg, err = readBusMessage(bus.r); err != nil {
+			return
+		}
+
+		switch {
+		case msg.Type == busSignal:
+			bus.signals = append(bus.signals, msg)
+		case msg.ReplySerial != bus.serial:
+			continue
+		case msg.Type == busError:
+			e := busErr{Name: msg.ErrorName}
+			if len(msg.Body) > 0 {
+				e.Message, _ = msg.Body[0].(string)
+			}
+			return nil, e
+		default:
+			return msg.Body, nil
+		}
+	}
+}
+
+// signal returns the next signal received
+func (bus *busConn) signal() (msg *busMessage, err error) {
+	if len(bus.signals) > 0 {
+		msg, bus.signals = bus.signals[0], bus.signals[1:]
+		return
+	}
+
+	for {
+		if msg, err = readBusMessage(bus.r); err != nil || msg.Type == busSignal {
+			return
+		}
+	}
+}
+
+// watchName subscribes to the changes of the owner of name
+func (bus *busConn) watchName(name string) (err error) {
+	_, err = bus.call("AddMatch", "s", "type='signal',sender='"+busName+"',interface='"+busInterface+
+		"',member='NameOwnerChanged',arg0='"+name+"'")
+	return
+}
+
+// nameHasOwner reports whether name is currently owned
+func (bus *busConn) nameHasOwner(name string) (bool, error) {
+	reply, err := bus.call("NameHasOwner", "s", name)
+	if err != nil {
+		return false, err
+	}
+	if len(reply) == 0 {
+		return false, ErrBusReply
+	}
+	has, ok := reply[0].(bool)
+	if !ok {
+		return false, ErrBusReply
+	}
+	return has, nil
+}
+
+// nameOwnerChanged waits for the owner of name watched to change and returns the new owner,
+// which is empty if the name was released
+func (bus *busConn) nameOwnerChanged(name string) (owner string, err error) {
+	for {
+		msg, err := bus.signal()
+		if err != nil {
+			return "", err
+		}
+		if msg.Member != "NameOwnerChanged" || msg.Sender != busName || len(msg.Body) != 3 {
+			continue
+		}
+		if n, _ := msg.Body[0].(string); n != name {
+			continue
+		}
+		owner, _ = msg.Body[2].(string)
+		return owner, nil
+	}
+}
+
+// busEncoder appends values in D-Bus wire format to a buffer, which
+// starts at an offset aligned to 8 bytes in the message
+type busEncoder struct {
+	buf []byte
+}
+
+func (e *busEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *busEncoder) uint32(v uint32) {
+	e.align(4)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *busEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(append(e.buf, s...), 0)
+}
+
+func (e *busEncoder) signature(s string) {
+	e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+}
+
+// value appends v of basic type specified by signature code typ
+func (e *busEncoder) value(typ byte, v interface{}) error {
+	switch typ {
+	case 'y':
+		b, ok := v.(byte)
+		if !ok {
+			return ErrBusType
+		}
+		e.buf = append(e.buf, b)
+	case 'b':
+		b, ok := v.(bool)
+		if !ok {
+			return ErrBusType
+		}
+		if b {
+			e.uint32(1)
+		} else {
+			e.uint32(0)
+		}
+	case 'u':
+		u, ok := v.(uint32)
+		if !ok {
+			return ErrBusType
+		}
+		e.uint32(u)
+	case 's', 'o':
+		s, ok := v.(string)
+		if !ok {
+			return ErrBusType
+		}
+		e.string(s)
+	case 'g':
+		s, ok := v.(string)
+		if !ok {
+			return ErrBusType
+		}
+		e.signature(s)
+	default:
+		return ErrBusType
+	}
+	return nil
+}
+
+// marshal returns msg encoded in little-endian D-Bus wire format
+func (msg *busMessage) marshal() ([]byte, error) {
+	if len(msg.Signature) != len(msg.Body) {
+		return nil, ErrBusType
+	}
+
+	body := &busEncoder{}
+	for i, v := range msg.Body {
+		if err := body.value(msg.Signature[i], v); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &busEncoder{buf: []byte{'l', msg.Type, 0, 1}}
+	e.uint32(uint32(len(body.buf)))
+	e.uint32(msg.Serial)
+	e.uint32(0) // Length of the header field array, set below
+
+	field := func(code, typ byte, v interface{}) {
+		e.align(8)
+		e.buf = append(e.buf, code)
+		e.signature(string(typ))
+		e.value(typ, v)
+	}
+	for _, f := range []struct {
+		code, typ byte
+		value     string
+	}{
+		{busFieldPath, 'o', msg.Path},
+		{busFieldInterface, 's', msg.Interface},
+		{busFieldMember, 's', msg.Member},
+		{busFieldErrorName, 's', msg.ErrorName},
+		{busFieldDestination, 's', msg.Destination},
+		{busFieldSender, 's', msg.Sender},
+		{busFieldSignature, 'g', msg.Signature},
+	} {
+		if f.value != "" {
+			field(f.code, f.typ, f.value)
+		}
+	}
+	if msg.ReplySerial != 0 {
+		field(busFieldReplySerial, 'u', msg.ReplySerial)
+	}
+
+	binary.LittleEndian.PutUint32(e.buf[12:], uint32(len(e.buf)-16))
+	e.align(8)
+	return append(e.buf, body.buf...), nil
+}
+
+// busDecoder reads values in D-Bus wire format from a message
+type busDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+func (d *busDecoder) align(n int) {
+	d.pos = (d.pos + n - 1) / n * n
+}
+
+func (d *busDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.buf) {
+		return nil, ErrBusMessage
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *busDecoder) uint32() (uint32, error) {
+	d.align(4)
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+// value reads a value of basic type specified by signature code typ
+func (d *busDecoder) value(typ byte) (interface{}, error) {
+	switch typ {
+	case 'y':
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		u, err := d.uint32()
+		return u != 0, err
+	case 'u':
+		return d.uint32()
+	case 's', 'o':
+		n, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case 'g':
+		n, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(b[:n[0]]), nil
+	default:
+		return nil, ErrBusType
+	}
+}
+
+// readBusMessage reads a message from r. Only the leading values of basic
+// types in the message body are decoded
+func readBusMessage(r io.Reader) (msg *busMessage, err error) {
+	head := make([]byte, 16)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+
+	d := &busDecoder{buf: head}
+	switch head[0] {
+	case 'l':
+		d.order = binary.LittleEndian
+	case 'B':
+		d.order = binary.BigEndian
+	default:
+		return nil, ErrBusMessage
+	}
+
+	bodyLen, fieldsLen := d.order.Uint32(head[4:]), d.order.Uint32(head[12:])
+	if bodyLen > MAX_BUS_MESSAGE || fieldsLen > MAX_BUS_MESSAGE {
+		return nil, ErrBusMessage
+	}
+	size := (16 + int(fieldsLen) + 7) / 8 * 8
+	d.buf = append(head, make([]byte, size-16+int(bodyLen))...)
+	if _, err = io.ReadFull(r, d.buf[16:]); err != nil {
+		return
+	}
+
+	msg = &busMessage{Type: head[1], Serial: d.order.Uint32(head[8:])}
+
+	for d.pos = 16; d.pos < 16+int(fieldsLen); {
+		d.align(8)
+		code, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := d.value('g')
+		if err != nil {
+			return nil, err
+		}
+		if len(sig.(string)) != 1 {
+			return nil, ErrBusMessage
+		}
+		v, err := d.value(sig.(string)[0])
+		if err != nil {
+			return nil, err
+		}
+
+		switch s, _ := v.(string); code[0] {
+		case busFieldPath:
+			msg.Path = s
+		case busFieldInterface:
+			msg.Interface = s
+		case busFieldMember:
+			msg.Member = s
+		case busFieldErrorName:
+			msg.ErrorName = s
+		case busFieldReplySerial:
+			msg.ReplySerial, _ = v.(uint32)
+		case busFieldDestination:
+			msg.Destination = s
+		case busFieldSender:
+			msg.Sender = s
+		case busFieldSignature:
+			msg.Signature = s
+		}
+	}
+
+	d.pos = size
+	for i := 0; i < len(msg.Signature); i++ {
+		v, err := d.value(msg.Signature[i])
+		if err != nil {
+			// Values of other types are not used
+			break
+		}
+		msg.Body = append(msg.Body, v)
+	}
+	return msg, nil
+}
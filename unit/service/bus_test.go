@@ -0,0 +1,128 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBusAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		"unix:path=/run/dbus/system_bus_socket":              "/run/dbus/system_bus_socket",
+		"unix:abstract=/tmp/dbus-test,guid=0123":             "@/tmp/dbus-test",
+		"tcp:host=localhost,port=1;unix:path=/tmp/with%20sp": "/tmp/with sp",
+	} {
+		network, addr, err := parseBusAddress(address)
+		if assert.NoError(t, err, address) {
+			assert.Equal(t, "unix", network, address)
+			assert.Equal(t, expected, addr, address)
+		}
+	}
+
+	for _, address := range []string{"", "tcp:host=localhost,port=1", "unix:guid=0123"} {
+		_, _, err := parseBusAddress(address)
+		assert.Equal(t, ErrBusAddress, err, address)
+	}
+}
+
+func TestBusMessage(t *testing.T) {
+	msg := &busMessage{
+		Type:        busSignal,
+		Serial:      3,
+		ReplySerial: 2,
+		Path:        busPath,
+		Interface:   busInterface,
+		Member:      "NameOwnerChanged",
+		Sender:      busName,
+		Signature:   "sub",
+		Body:        []interface{}{"org.example.Test", uint32(7), true},
+	}
+
+	b, err := msg.marshal()
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(b)%8)
+
+	read, err := readBusMessage(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, msg, read)
+
+	_, err = readBusMessage(bytes.NewReader(append([]byte{'x'}, b[1:]...)))
+	assert.Equal(t, ErrBusMessage, err, "invalid byte order")
+
+	_, err = (&busMessage{Signature: "s", Body: []interface{}{1}}).marshal()
+	assert.Equal(t, ErrBusType, err)
+}
+
+// startBus starts a dbus-daemon listening in a temporary directory and
+// returns its address and a function stopping it
+func startBus(t *testing.T) (address string, stop func()) {
+	path, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+
+	dir, err := ioutil.TempDir("", "systemgo-bus-")
+	require.NoError(t, err)
+
+	cmd := exec.Command(path, "--session", "--nofork", "--print-address",
+		"--address=unix:path="+filepath.Join(dir, "bus"))
+	out, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+
+	stop = func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+	}
+
+	if address, err = bufio.NewReader(out).ReadString('\n'); err != nil {
+		stop()
+		t.Fatalf("Failed to read bus address: %s", err)
+	}
+	return strings.TrimSpace(address), stop
+}
+
+func TestBusConn(t *testing.T) {
+	address, stop := startBus(t)
+	defer stop()
+
+	bus, err := dialBus(address)
+	require.NoError(t, err)
+	defer bus.Close()
+
+	require.NoError(t, bus.watchName("org.example.Test"))
+
+	has, err := bus.nameHasOwner("org.example.Test")
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	owner, err := dialBus(address)
+	require.NoError(t, err)
+	defer owner.Close()
+
+	_, err = owner.call("RequestName", "su", "org.example.Test", uint32(0))
+	require.NoError(t, err)
+
+	id, err := bus.nameOwnerChanged("org.example.Test")
+	require.NoError(t, err)
+	assert.NotEmpty(t, id, "owner after RequestName")
+
+	_, err = owner.call("ReleaseName", "s", "org.example.Test")
+	require.NoError(t, err)
+
+	id, err = bus.nameOwnerChanged("org.example.Test")
+	require.NoError(t, err)
+	assert.Empty(t, id, "owner after ReleaseName")
+
+	_, err = bus.call("NoSuchMethod", "")
+	assert.IsType(t, busErr{}, err)
+}
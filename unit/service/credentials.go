@@ -0,0 +1,271 @@
+package service
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Maximum size of a single credential passed to a service
+const MAX_CREDENTIAL_SIZE = 1024 * 1024
+
+// Directory the per-invocation credentials directories are created in
+var credentialsRoot = "/run/credentials"
+
+// Directories credentials specified by ID only or by relative paths are searched in,
+// after the credentials directory of the manager itself
+var credentialStores = []string{"/etc/credstore", "/run/credstore", "/usr/local/lib/credstore", "/usr/lib/credstore"}
+
+// credentialSpecs are the credentials specified by LoadCredential, ImportCredential and SetCredential
+type credentialSpecs struct {
+	// IDs and the paths they are loaded from, empty meaning the ID itself
+	load []struct{ id, path string }
+
+	// Globs the names of imported credentials match
+	imports []string
+
+	// Data of the credentials, which are not loaded or imported otherwise
+	set []struct{ id, data string }
+}
+
+// parseCredentials parses the credentials specified in def
+func parseCredentials(def Definition) (specs credentialSpecs, merr unit.MultiError) {
+	for _, v := range def.Service.LoadCredential {
+		parts := strings.SplitN(v, ":", 2)
+		id, path := parts[0], ""
+		if len(parts) > 1 {
+			path = parts[1]
+		}
+		if !validCredentialID(id) || path != "" && !filepath.IsAbs(path) && !validCredentialID(path) {
+			merr = append(merr, unit.ParseErr("LoadCredential", unit.ParseErr(v, unit.ErrWrongVal)))
+			continue
+		}
+		specs.load = append(specs.load, struct{ id, path string }{id, path})
+	}
+
+	for _, line := range def.Service.ImportCredential {
+		for _, glob := range strings.Fields(line) {
+			if _, err := filepath.Match(glob, ""); err != nil || strings.Contains(glob, "/") {
+				merr = append(merr, unit.ParseErr("ImportCredential", unit.ParseErr(glob, unit.ErrWrongVal)))
+				continue
+			}
+			specs.imports = append(specs.imports, glob)
+		}
+	}
+
+	for _, v := range def.Service.SetCredential {
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) != 2 || !validCredentialID(parts[0]) || len(parts[1]) > MAX_CREDENTIAL_SIZE {
+			// Data is not included in the error, as it is secret
+			merr = append(merr, unit.ParseErr("SetCredential", unit.ParseErr(parts[0], unit.ErrWrongVal)))
+			continue
+		}
+		specs.set = append(specs.set, struct{ id, data string }{parts[0], parts[1]})
+	}
+	return
+}
+
+// validCredentialID reports whether id can be used as a file name in the credentials directory
+func validCredentialID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.Contains(id, "/")
+}
+
+// empty reports whether no credentials are specified
+func (specs credentialSpecs) empty() bool {
+	return len(specs.load) == 0 && len(specs.imports) == 0 && len(specs.set) == 0
+}
+
+// credentialSearchPath returns the directories credentials are searched in
+func credentialSearchPath() (dirs []string) {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return append(dirs, credentialStores...)
+}
+
+// setupCredentials creates the private credentials directory of the invocation, on a tmpfs
+// if possible, and writes the credentials specified to it. The directory and the files are made
+// read-only and are owned by the user of the service. Missing credentials fail the setup
+func (sv *Unit) setupCredentials() (err error) {
+	sv.removeCredentials()
+	if sv.credentialSpecs.empty() {
+		return nil
+	}
+
+	if err = os.MkdirAll(credentialsRoot, 0755); err != nil {
+		return
+	}
+
+	var dir string
+	if dir, err = ioutil.TempDir(credentialsRoot, "systemgo-"); err != nil {
+		return
+	}
+	sv.credentialsDir = dir
+
+	// Falls back to the directory itself, if tmpfs cannot be mounted
+	mounted := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0700") == nil
+	sv.credentialsMounted = mounted
+
+	written := map[string]bool{}
+	write := func(id string, r io.Reader) error {
+		b, err := ioutil.ReadAll(io.LimitReader(r, MAX_CREDENTIAL_SIZE+1))
+		if err != nil {
+			return err
+		}
+		if len(b) > MAX_CREDENTIAL_SIZE {
+			return ErrCredentialSize
+		}
+		written[id] = true
+		return ioutil.WriteFile(filepath.Join(dir, id), b, 0400)
+	}
+
+	for _, l := range sv.credentialSpecs.load {
+		if lerr := loadCredential(l.id, l.path, write); lerr != nil {
+			if sv.hasSetCredential(l.id) {
+				// SetCredential provides the fallback
+				continue
+			}
+			return unit.ParseErr("LoadCredential", unit.ParseErr(l.id, lerr))
+		}
+	}
+
+	for _, glob := range sv.credentialSpecs.imports {
+		for _, store := range credentialSearchPath() {
+			paths, _ := filepath.Glob(filepath.Join(store, glob))
+			for _, path := range paths {
+				id := filepath.Base(path)
+				if written[id] {
+					// Credentials found earlier in the search path take precedence
+					continue
+				}
+				if err = writeCredentialFile(path, id, write); err != nil {
+					return unit.ParseErr("ImportCredential", unit.ParseErr(id, err))
+				}
+			}
+		}
+	}
+
+	for _, s := range sv.credentialSpecs.set {
+		if written[s.id] {
+			continue
+		}
+		if err = write(s.id, strings.NewReader(s.data)); err != nil {
+			return unit.ParseErr("SetCredential", unit.ParseErr(s.id, err))
+		}
+	}
+
+	if sv.creds != nil {
+		if err = chownTree(dir, int(sv.creds.UID), int(sv.creds.GID)); err != nil {
+			return
+		}
+	}
+	if err = os.Chmod(dir, 0500); err != nil {
+		return
+	}
+	if mounted {
+		return syscall.Mount("", dir, "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	}
+	return nil
+}
+
+// loadCredential writes the credentials loaded from path with write. Paths, which are
+// not absolute, are looked up in the search path. All the files in a directory are
+// loaded as credentials named "id_filename"
+func loadCredential(id, path string, write func(id string, r io.Reader) error) (err error) {
+	if path == "" {
+		path = id
+	}
+
+	if !filepath.IsAbs(path) {
+		name := path
+		path = ""
+		for _, store := range credentialSearchPath() {
+			if _, serr := os.Stat(filepath.Join(store, name)); serr == nil {
+				path = filepath.Join(store, name)
+				break
+			}
+		}
+		if path == "" {
+			return ErrCredentialNotFound
+		}
+	}
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(path); os.IsNotExist(err) {
+		return ErrCredentialNotFound
+	} else if err != nil {
+		return
+	}
+	if !fi.IsDir() {
+		return writeCredentialFile(path, id, write)
+	}
+
+	var names []string
+	if names, err = readDirNames(path); err != nil {
+		return
+	}
+	for _, name := range names {
+		if fi, serr := os.Stat(filepath.Join(path, name)); serr != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if err = writeCredentialFile(filepath.Join(path, name), id+"_"+name, write); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// writeCredentialFile writes the contents of the file at path as the credential id with write
+func writeCredentialFile(path, id string, write func(id string, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return write(id, f)
+}
+
+func (sv *Unit) hasSetCredential(id string) bool {
+	for _, s := range sv.credentialSpecs.set {
+		if s.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialsEnv returns the variable pointing to the credentials directory, if created
+func (sv *Unit) credentialsEnv() []string {
+	if sv.credentialsDir == "" {
+		return nil
+	}
+	return []string{"CREDENTIALS_DIRECTORY=" + sv.credentialsDir}
+}
+
+// removeCredentials unmounts and removes the credentials directory created by setupCredentials
+func (sv *Unit) removeCredentials() {
+	dir := sv.credentialsDir
+	if dir == "" {
+		return
+	}
+
+	if sv.credentialsMounted {
+		if err := syscall.Unmount(dir, syscall.MNT_DETACH); err != nil {
+			log.WithField("path", dir).Warnf("Failed to unmount credentials directory: %s", err)
+		}
+	}
+	// Read-only directory must be writable for its contents to be removed
+	os.Chmod(dir, 0700)
+	if err := os.RemoveAll(dir); err != nil {
+		log.WithField("path", dir).Warnf("Failed to remove credentials directory: %s", err)
+	}
+
+	sv.credentialsDir, sv.credentialsMounted = "", false
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useCredentialDirs makes the credentials directories be created under dir and
+// credentials be searched for in store, the returned function restores the defaults
+func useCredentialDirs(t *testing.T, dir, store string) func() {
+	root, stores := credentialsRoot, credentialStores
+	credentialsRoot, credentialStores = dir, []string{store}
+	return func() { credentialsRoot, credentialStores = root, stores }
+}
+
+func TestDefineCredentials(t *testing.T) {
+	for option, value := range map[string]string{
+		"LoadCredential":   "a/b:/etc/passwd",
+		"ImportCredential": "foo/*",
+		"SetCredential":    "nodata",
+	} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+
+	sv := Unit{}
+	assert.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+LoadCredential=a
+LoadCredential=b:/etc/passwd
+LoadCredential=c:stored
+ImportCredential=app.*
+SetCredential=d:some data: with colons`)))
+	assert.Len(t, sv.credentialSpecs.load, 3)
+	assert.Equal(t, []string{"app.*"}, sv.credentialSpecs.imports)
+	if assert.Len(t, sv.credentialSpecs.set, 1) {
+		assert.Equal(t, "some data: with colons", sv.credentialSpecs.set[0].data)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-credentials-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chmod(dir, 0755))
+
+	store, src := filepath.Join(dir, "store"), filepath.Join(dir, "src")
+	for path, data := range map[string]string{
+		"store/stored":     "from store",
+		"store/app.one":    "imported one",
+		"store/app.two":    "imported two",
+		"store/other":      "not imported",
+		"src/file":         "from file",
+		"src/dir/first":    "first in dir",
+		"src/dir/second":   "second in dir",
+		"src/dir/sub/skip": "in subdirectory",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, path), []byte(data), 0600))
+	}
+	defer useCredentialDirs(t, filepath.Join(dir, "run"), store)()
+
+	out := filepath.Join(dir, "out")
+	require.NoError(t, ioutil.WriteFile(out, nil, 0666))
+	require.NoError(t, os.Chmod(out, 0666))
+	options := `[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c 'cd "$CREDENTIALS_DIRECTORY" && for f in *; do echo "$f=$(cat $f)"; done > ` + out + `'
+LoadCredential=file:` + filepath.Join(src, "file") + `
+LoadCredential=dir:` + filepath.Join(src, "dir") + `
+LoadCredential=stored
+LoadCredential=fallback:/nonexistent
+SetCredential=fallback:set data
+SetCredential=file:not used
+ImportCredential=app.*`
+	if os.Getuid() == 0 {
+		options += "\nUser=4242"
+	}
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(options)))
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, `app.one=imported one
+app.two=imported two
+dir_first=first in dir
+dir_second=second in dir
+fallback=set data
+file=from file
+stored=from store
+`, string(b))
+
+	credsDir := sv.credentialsDir
+	require.NotEmpty(t, credsDir)
+	assert.Equal(t, filepath.Join(dir, "run"), filepath.Dir(credsDir))
+
+	fi, err := os.Stat(credsDir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.ModeDir|0500, fi.Mode())
+		if os.Getuid() == 0 {
+			assert.Equal(t, uint32(4242), fi.Sys().(*syscall.Stat_t).Uid)
+		}
+	}
+	fi, err = os.Stat(filepath.Join(credsDir, "file"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0400), fi.Mode())
+	}
+	if sv.credentialsMounted {
+		assert.Error(t, ioutil.WriteFile(filepath.Join(credsDir, "new"), nil, 0600), "read-only credentials directory")
+	}
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	_, err = os.Stat(credsDir)
+	assert.True(t, os.IsNotExist(err), "credentials directory removed on stop")
+}
+
+func TestMissingCredential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-credentials-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer useCredentialDirs(t, filepath.Join(dir, "run"), filepath.Join(dir, "store"))()
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+LoadCredential=missing`)))
+
+	err = sv.Start()
+	if assert.Error(t, err, "sv.Start") {
+		assert.Contains(t, err.Error(), ErrCredentialNotFound.Error())
+		assert.Contains(t, err.Error(), "missing")
+	}
+	assert.Equal(t, failed, sv.Sub())
+
+	names, err := readDirNames(filepath.Join(dir, "run"))
+	require.NoError(t, err)
+	assert.Empty(t, names, "credentials directory removed")
+}
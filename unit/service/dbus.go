@@ -0,0 +1,134 @@
+package service
+
+import (
+	"os/exec"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// busAddress returns the address of the bus BusName is watched on
+func (sv *Unit) busAddress() string {
+	if sv.BusAddress != "" {
+		return sv.BusAddress
+	}
+	return DEFAULT_BUS_ADDRESS
+}
+
+// startDbus starts the main process using cmd and waits until it either
+// acquires BusName on the bus or exits
+func (sv *Unit) startDbus(cmd *exec.Cmd) (err error) {
+	name := sv.Definition.Service.BusName
+
+	bus, err := dialBus(sv.busAddress())
+	if err != nil {
+		return
+	}
+
+	// Subscribe before the process is started, so that the acquisition of the name is not missed
+	if err = bus.watchName(name); err != nil {
+		bus.Close()
+		return
+	}
+
+	sv.mutex.Lock()
+	sv.ready = false
+	sv.notified = ""
+	sv.readych = make(chan struct{})
+	readych := sv.readych
+	sv.mutex.Unlock()
+
+	if err = sv.startMain(cmd); err != nil {
+		bus.Close()
+		return
+	}
+	sv.setMainPID(cmd.Process.Pid)
+
+	exitch := make(chan struct{})
+	go sv.supervise(cmd, exitch)
+	go sv.watchBusName(cmd, bus, name, exitch)
+
+	select {
+	case <-readych:
+		return nil
+	case <-exitch:
+		// The name could have been acquired right before the process exited
+		select {
+		case <-readych:
+			return nil
+		default:
+			return ErrNotReady
+		}
+	}
+}
+
+// watchBusName watches the owner of name on bus for the main process started by cmd,
+// which has exited once exitch is closed. The service is considered ready once the
+// name is acquired and is stopped once it is released
+func (sv *Unit) watchBusName(cmd *exec.Cmd, bus *busConn, name string, exitch chan struct{}) {
+	go func() {
+		<-exitch
+		bus.Close()
+	}()
+	defer bus.Close()
+
+	owned, err := bus.nameHasOwner(name)
+	for err == nil {
+		if owned {
+			sv.busNameAcquired(cmd)
+		}
+
+		var owner string
+		if owner, err = bus.nameOwnerChanged(name); err != nil {
+			return
+		}
+
+		switch {
+		case owner != "":
+			owned = true
+		case owned:
+			sv.busNameReleased(cmd, exitch)
+			return
+		}
+	}
+}
+
+// busNameAcquired marks the service, the main process of which was started by cmd, ready
+func (sv *Unit) busNameAcquired(cmd *exec.Cmd) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	if cmd == sv.main && !sv.ready {
+		sv.ready = true
+		close(sv.readych)
+	}
+}
+
+// busNameReleased stops the service, the main process of which was started by cmd
+// and has exited once exitch is closed, as if Stop was called
+func (sv *Unit) busNameReleased(cmd *exec.Cmd, exitch chan struct{}) {
+	select {
+	case <-exitch:
+		// Exit of the main process is handled by supervise
+		return
+	default:
+	}
+
+	sv.mutex.Lock()
+	if cmd != sv.main || !sv.ready || sv.stopping || sv.startFailed {
+		sv.mutex.Unlock()
+		return
+	}
+	sv.stopping = true
+	sv.cancelRestart()
+	sv.stopWatchdog()
+	sv.mutex.Unlock()
+
+	log.WithFields(log.Fields{
+		"ExecStart": sv.Definition.Service.ExecStart,
+		"BusName":   sv.Definition.Service.BusName,
+	}).Info("Bus name released, stopping")
+
+	if err := sv.runStop(true); err != nil {
+		log.WithField("ExecStart", sv.Definition.Service.ExecStart).Warnf("Failed to run stop commands: %s", err)
+	}
+}
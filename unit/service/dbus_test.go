@@ -0,0 +1,74 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefineDbus(t *testing.T) {
+	sv := Unit{}
+	err := sv.Define(strings.NewReader("[Service]\nType=dbus\nExecStart=/bin/sleep 60"))
+	if assert.IsType(t, unit.MultiError{}, err) {
+		merr := err.(unit.MultiError)
+		if assert.Len(t, merr, 1) {
+			assert.Equal(t, "BusName", merr[0].(unit.ParseError).Source)
+		}
+	}
+
+	assert.NoError(t, sv.Define(strings.NewReader("[Service]\nType=dbus\nBusName=org.example.Test\nExecStart=/bin/sleep 60")))
+	assert.Equal(t, "org.example.Test", sv.Properties()["BusName"])
+}
+
+func TestStartDbus(t *testing.T) {
+	address, stop := startBus(t)
+	defer stop()
+
+	sv := &Unit{BusAddress: address}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nType=dbus\nBusName=org.example.Test\nExecStart=/bin/sleep 60")))
+
+	owner, err := dialBus(address)
+	require.NoError(t, err)
+	defer owner.Close()
+
+	errch := make(chan error, 1)
+	go func() {
+		for sv.MainPID() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, start, sv.Sub(), "sv.Sub before the name is acquired")
+
+		// The name is acquired on behalf of the service
+		_, err := owner.call("RequestName", "su", "org.example.Test", uint32(0))
+		errch <- err
+	}()
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	require.NoError(t, <-errch, "RequestName")
+	assert.Equal(t, running, sv.Sub())
+	pid := sv.MainPID()
+
+	_, err = owner.call("ReleaseName", "s", "org.example.Test")
+	require.NoError(t, err, "ReleaseName")
+
+	for i := 0; i < 100 && sv.Active() != unit.Inactive; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, dead, sv.Sub(), "sv.Sub after the name is released")
+	assert.False(t, isAlive(pid), "main process after the name is released")
+
+	// Process exiting before acquiring the name
+	sv = &Unit{BusAddress: address}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nType=dbus\nBusName=org.example.Test\nExecStart=/bin/true")))
+	assert.Equal(t, ErrNotReady, sv.Start(), "sv.Start of a process exiting before acquiring the name")
+
+	// No bus to connect to
+	sv = &Unit{BusAddress: "unix:path=/nonexistent/bus"}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nType=dbus\nBusName=org.example.Test\nExecStart=/bin/sleep 60")))
+	assert.Error(t, sv.Start(), "sv.Start without a bus")
+	assert.Equal(t, 0, sv.MainPID())
+}
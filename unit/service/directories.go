@@ -0,0 +1,263 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Mode of the managed directories if not specified
+const DEFAULT_DIRECTORY_MODE = 0755
+
+// Kinds of directories managed for the service, as accepted by Clean
+const (
+	runtimeDirectory       = "runtime"
+	stateDirectory         = "state"
+	cacheDirectory         = "cache"
+	logsDirectory          = "logs"
+	configurationDirectory = "configuration"
+)
+
+// Kinds of directories along with the prefixes of the options specifying them
+// and the environment variables they are exported in
+var directoryKinds = []struct {
+	kind, option, env string
+}{
+	{runtimeDirectory, "Runtime", "RUNTIME_DIRECTORY"},
+	{stateDirectory, "State", "STATE_DIRECTORY"},
+	{cacheDirectory, "Cache", "CACHE_DIRECTORY"},
+	{logsDirectory, "Logs", "LOGS_DIRECTORY"},
+	{configurationDirectory, "Configuration", "CONFIGURATION_DIRECTORY"},
+}
+
+// Directories the managed directories of each kind are created in
+var directoryRoots = map[string]string{
+	runtimeDirectory:       "/run",
+	stateDirectory:         "/var/lib",
+	cacheDirectory:         "/var/cache",
+	logsDirectory:          "/var/log",
+	configurationDirectory: "/etc",
+}
+
+// Kinds of directories removed by Clean if none are specified
+var defaultCleanKinds = []string{runtimeDirectory, cacheDirectory}
+
+var runtimeDirectoryPreserveModes = map[string]bool{
+	"no":      true,
+	"yes":     true,
+	"restart": true,
+}
+
+// directories are the managed directories of a kind
+type directories struct {
+	// Names relative to the root directory of the kind
+	names []string
+	mode  os.FileMode
+}
+
+// directoryOptions returns the values of the options specifying the directories and the modes of kind
+func (def Definition) directoryOptions(kind string) (names unit.Lines, mode string) {
+	svc := def.Service
+	switch kind {
+	case runtimeDirectory:
+		return svc.RuntimeDirectory, svc.RuntimeDirectoryMode
+	case stateDirectory:
+		return svc.StateDirectory, svc.StateDirectoryMode
+	case cacheDirectory:
+		return svc.CacheDirectory, svc.CacheDirectoryMode
+	case logsDirectory:
+		return svc.LogsDirectory, svc.LogsDirectoryMode
+	case configurationDirectory:
+		return svc.ConfigurationDirectory, svc.ConfigurationDirectoryMode
+	}
+	return nil, ""
+}
+
+// parseDirectories parses the managed directories specified in def mapped to their kinds
+func parseDirectories(def Definition) (dirs map[string]directories, merr unit.MultiError) {
+	dirs = map[string]directories{}
+
+	for _, k := range directoryKinds {
+		lines, modeStr := def.directoryOptions(k.kind)
+
+		d := directories{mode: DEFAULT_DIRECTORY_MODE}
+		if modeStr != "" {
+			mode, err := strconv.ParseUint(modeStr, 8, 32)
+			if err != nil || mode > 07777 {
+				merr = append(merr, unit.ParseErr(k.option+"DirectoryMode", unit.ParseErr(modeStr, unit.ErrWrongVal)))
+			} else {
+				d.mode = os.FileMode(mode)
+			}
+		}
+
+		for _, line := range lines {
+			for _, name := range strings.Fields(line) {
+				if !validDirectoryName(name) {
+					merr = append(merr, unit.ParseErr(k.option+"Directory", unit.ParseErr(name, unit.ErrWrongVal)))
+					continue
+				}
+				d.names = append(d.names, name)
+			}
+		}
+
+		if len(d.names) > 0 {
+			dirs[k.kind] = d
+		}
+	}
+
+	if preserve := def.Service.RuntimeDirectoryPreserve; !runtimeDirectoryPreserveModes[preserve] {
+		merr = append(merr, unit.ParseErr("RuntimeDirectoryPreserve", unit.ParseErr(preserve, unit.ErrNotSupported)))
+	}
+	return
+}
+
+// validDirectoryName reports whether name is a relative path, which does not leave the root directory
+func validDirectoryName(name string) bool {
+	if filepath.IsAbs(name) || filepath.Clean(name) != name {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// directoryPaths returns the absolute paths of the managed directories of kind
+func (sv *Unit) directoryPaths(kind string) (paths []string) {
+	for _, name := range sv.directories[kind].names {
+		paths = append(paths, filepath.Join(directoryRoots[kind], name))
+	}
+	return
+}
+
+// createDirectories creates the managed directories, which do not exist, and sets their modes.
+// Directories other than configuration ones are owned by the user and group of the service
+func (sv *Unit) createDirectories() (err error) {
+	for _, k := range directoryKinds {
+		mode := sv.directories[k.kind].mode
+
+		for _, path := range sv.directoryPaths(k.kind) {
+			if err = os.MkdirAll(filepath.Dir(path), DEFAULT_DIRECTORY_MODE); err != nil {
+				return
+			}
+			if err = os.Mkdir(path, mode); err != nil && !os.IsExist(err) {
+				return
+			}
+			// Mode is not affected by umask of the manager
+			if err = os.Chmod(path, mode); err != nil {
+				return
+			}
+
+			if sv.creds != nil && k.kind != configurationDirectory {
+				if err = chownTree(path, int(sv.creds.UID), int(sv.creds.GID)); err != nil {
+					return
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// chownTree changes the owner of path and, if it is not owned by uid and gid already, of its contents
+func chownTree(path string, uid, gid int) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) == uid && int(st.Gid) == gid {
+		return nil
+	}
+
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, uid, gid)
+	})
+}
+
+// directoryEnv returns the variables listing the managed directories of each kind
+func (sv *Unit) directoryEnv() (env []string) {
+	for _, k := range directoryKinds {
+		if paths := sv.directoryPaths(k.kind); len(paths) > 0 {
+			env = append(env, k.env+"="+strings.Join(paths, ":"))
+		}
+	}
+	return
+}
+
+// removeRuntimeDirectories removes the runtime directories, unless they are preserved
+// according to RuntimeDirectoryPreserve. final specifies whether the service is not restarted
+func (sv *Unit) removeRuntimeDirectories(final bool) {
+	switch sv.Definition.Service.RuntimeDirectoryPreserve {
+	case "yes":
+		return
+	case "restart":
+		if !final {
+			return
+		}
+	}
+	sv.removeDirectories(runtimeDirectory)
+}
+
+// removeDirectories removes the managed directories of kind
+func (sv *Unit) removeDirectories(kind string) (err error) {
+	for _, path := range sv.directoryPaths(kind) {
+		if rerr := os.RemoveAll(path); rerr != nil {
+			log.WithField("path", path).Warnf("Failed to remove %s directory: %s", kind, rerr)
+			if err == nil {
+				err = rerr
+			}
+		}
+	}
+	return
+}
+
+// Clean removes the managed directories of the kinds specified: "runtime", "state", "cache",
+// "logs", "configuration" or "all". Runtime and cache directories are removed if none are specified
+func (sv *Unit) Clean(what ...string) (err error) {
+	log.WithField("what", what).Debugf("sv.Clean")
+
+	if len(what) == 0 {
+		what = defaultCleanKinds
+	}
+
+	kinds := map[string]bool{}
+	for _, w := range what {
+		if _, ok := directoryRoots[w]; !ok && w != "all" {
+			return unit.ParseErr(w, unit.ErrNotSupported)
+		}
+		kinds[w] = true
+	}
+
+	for _, k := range directoryKinds {
+		if !kinds[k.kind] && !kinds["all"] {
+			continue
+		}
+		if rerr := sv.removeDirectories(k.kind); err == nil {
+			err = rerr
+		}
+	}
+	return
+}
+
+// directoryProperties adds the managed directory settings to props
+func (sv *Unit) directoryProperties(props map[string]string) {
+	for _, k := range directoryKinds {
+		if d, ok := sv.directories[k.kind]; ok {
+			props[k.option+"Directory"] = strings.Join(d.names, " ")
+			props[k.option+"DirectoryMode"] = fmt.Sprintf("%04o", d.mode)
+		}
+	}
+	if preserve := sv.Definition.Service.RuntimeDirectoryPreserve; preserve != "" {
+		props["RuntimeDirectoryPreserve"] = preserve
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useDirectoryRoots makes the managed directories be created under dir,
+// the returned function restores the defaults
+func useDirectoryRoots(t *testing.T, dir string) func() {
+	defaults := directoryRoots
+	directoryRoots = map[string]string{}
+	for kind := range defaults {
+		directoryRoots[kind] = filepath.Join(dir, kind)
+	}
+	return func() { directoryRoots = defaults }
+}
+
+func TestDefineDirectories(t *testing.T) {
+	for option, value := range map[string]string{
+		"StateDirectory":           "/var/lib/foo",
+		"CacheDirectory":           "foo/../../bar",
+		"LogsDirectory":            "./foo",
+		"RuntimeDirectoryMode":     "0999",
+		"RuntimeDirectoryPreserve": "maybe",
+	} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+StateDirectory=foo foo/bar
+StateDirectory=baz
+StateDirectoryMode=0700`)))
+	assert.Equal(t, map[string]directories{
+		stateDirectory: {names: []string{"foo", "foo/bar", "baz"}, mode: 0700},
+	}, sv.directories)
+
+	props := sv.Properties()
+	assert.Equal(t, "foo foo/bar baz", props["StateDirectory"])
+	assert.Equal(t, "0700", props["StateDirectoryMode"])
+	assert.Equal(t, "no", props["RuntimeDirectoryPreserve"])
+}
+
+func TestDirectories(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-directories-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer useDirectoryRoots(t, dir)()
+
+	out := filepath.Join(dir, "out")
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c 'echo "$RUNTIME_DIRECTORY $STATE_DIRECTORY" > `+out+`; touch ${STATE_DIRECTORY%%:*}/data'
+RuntimeDirectory=rt
+RuntimeDirectoryMode=0700
+StateDirectory=st/a st/b
+CacheDirectory=ca`)))
+
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "runtime/rt")+" "+filepath.Join(dir, "state/st/a")+":"+filepath.Join(dir, "state/st/b")+"\n", string(b))
+
+	fi, err := os.Stat(filepath.Join(dir, "runtime/rt"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.ModeDir|0700, fi.Mode())
+	}
+	for _, path := range []string{"state/st/a/data", "state/st/b", "cache/ca"} {
+		_, err := os.Stat(filepath.Join(dir, path))
+		assert.NoError(t, err, path)
+	}
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	_, err = os.Stat(filepath.Join(dir, "runtime/rt"))
+	assert.True(t, os.IsNotExist(err), "runtime directory removed on stop")
+	_, err = os.Stat(filepath.Join(dir, "state/st/a/data"))
+	assert.NoError(t, err, "state directory preserved on stop")
+
+	assert.Error(t, sv.Clean("everything"))
+
+	require.NoError(t, sv.Clean(), "sv.Clean")
+	_, err = os.Stat(filepath.Join(dir, "cache/ca"))
+	assert.True(t, os.IsNotExist(err), "cache directory cleaned by default")
+	_, err = os.Stat(filepath.Join(dir, "state/st/a"))
+	assert.NoError(t, err, "state directory not cleaned by default")
+
+	require.NoError(t, sv.Clean("state"), "sv.Clean")
+	for _, path := range []string{"state/st/a", "state/st/b"} {
+		_, err = os.Stat(filepath.Join(dir, path))
+		assert.True(t, os.IsNotExist(err), path)
+	}
+	_, err = os.Stat(filepath.Join(dir, "state/st"))
+	assert.NoError(t, err, "parent directory kept")
+}
+
+func TestRuntimeDirectoryPreserve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-directories-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer useDirectoryRoots(t, dir)()
+
+	path := filepath.Join(dir, "runtime/rt")
+	for preserve, expected := range map[string][2]bool{
+		"no":      {false, false},
+		"restart": {true, false},
+		"yes":     {true, true},
+	} {
+		sv := Unit{}
+		require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\nRuntimeDirectory=rt\nRuntimeDirectoryPreserve="+preserve)))
+		require.NoError(t, sv.createDirectories())
+
+		sv.removeRuntimeDirectories(false)
+		_, err := os.Stat(path)
+		assert.Equal(t, expected[0], err == nil, preserve+" on restart")
+
+		sv.removeRuntimeDirectories(true)
+		_, err = os.Stat(path)
+		assert.Equal(t, expected[1], err == nil, preserve+" on stop")
+
+		os.RemoveAll(path)
+	}
+}
+
+func TestDirectoriesOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "systemgo-directories-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer useDirectoryRoots(t, dir)()
+	require.NoError(t, os.Chmod(dir, 0755))
+
+	// Contents of existing directories are handed over to the user as well
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "state/st"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "state/st/data"), nil, 0644))
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/touch ${STATE_DIRECTORY}/user
+User=4242
+Group=4243
+StateDirectory=st
+ConfigurationDirectory=co`)))
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	for path, uid := range map[string]uint32{
+		"state/st":         4242,
+		"state/st/data":    4242,
+		"state/st/user":    4242,
+		"configuration/co": 0,
+	} {
+		fi, err := os.Stat(filepath.Join(dir, path))
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, uid, fi.Sys().(*syscall.Stat_t).Uid, path)
+		}
+	}
+}
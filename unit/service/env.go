@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// PATH passed to service processes
+const DEFAULT_PATH = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// environment returns the environment the processes of the service are run in.
+// Variables are set in the following order, later ones overriding the earlier:
+// defaults and variables describing the user, PassEnvironment, Environment, EnvironmentFile and the variables set by the manager,
+// including those listing the managed directories and the credentials directory.
+// Variables listed in UnsetEnvironment are removed afterwards
+func (sv *Unit) environment() (env []string, err error) {
+	env = []string{"PATH=" + DEFAULT_PATH}
+	if lang, ok := os.LookupEnv("LANG"); ok {
+		env = append(env, "LANG="+lang)
+	}
+	if sv.creds != nil {
+		env = append(env, sv.creds.Env()...)
+	}
+
+	for _, line := range sv.Definition.Service.PassEnvironment {
+		for _, key := range strings.Fields(line) {
+			if value, ok := os.LookupEnv(key); ok {
+				env = setEnv(env, key, value)
+			}
+		}
+	}
+
+	for _, line := range sv.Definition.Service.Environment {
+		var assignments []string
+		if assignments, err = unit.ParseEnvironment(line); err != nil {
+			return nil, unit.ParseErr("Environment", err)
+		}
+		env = mergeEnv(env, assignments)
+	}
+
+	for _, path := range sv.Definition.Service.EnvironmentFile {
+		var assignments []string
+		if assignments, err = readEnvironmentFile(path); err != nil {
+			return nil, unit.ParseErr("EnvironmentFile", err)
+		}
+		env = mergeEnv(env, assignments)
+	}
+
+	var id string
+	if id, err = newInvocationID(); err != nil {
+		return nil, err
+	}
+	env = setEnv(env, "INVOCATION_ID", id)
+	env = mergeEnv(env, sv.watchdogEnv())
+	env = mergeEnv(env, sv.directoryEnv())
+	env = mergeEnv(env, sv.credentialsEnv())
+
+	if access := sv.Definition.Service.NotifyAccess; access != "" && access != "none" && sv.NotifySocket != "" {
+		env = setEnv(env, "NOTIFY_SOCKET", sv.NotifySocket)
+	}
+
+	for _, line := range sv.Definition.Service.UnsetEnvironment {
+		for _, v := range strings.Fields(line) {
+			env = unsetEnv(env, v)
+		}
+	}
+	return env, nil
+}
+
+// readEnvironmentFile reads the environment file at path.
+// If path is prefixed with "-", nothing is returned if the file does not exist
+func readEnvironmentFile(path string) (env []string, err error) {
+	optional := strings.HasPrefix(path, "-")
+	path = strings.TrimPrefix(path, "-")
+
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		if optional && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return
+	}
+	defer f.Close()
+
+	if env, err = unit.ReadEnvironmentFile(f); err != nil {
+		return nil, unit.ParseErr(path, err)
+	}
+	return
+}
+
+// mergeEnv returns env with "key=value" assignments specified set
+func mergeEnv(env, assignments []string) []string {
+	for _, kv := range assignments {
+		i := strings.IndexByte(kv, '=')
+		env = setEnv(env, kv[:i], kv[i+1:])
+	}
+	return env
+}
+
+// unsetEnv returns env without variable v. If v is an assignment, the variable
+// is only removed if it is set to the value specified
+func unsetEnv(env []string, v string) []string {
+	out := env[:0]
+	for _, kv := range env {
+		if kv == v || !strings.Contains(v, "=") && strings.HasPrefix(kv, v+"=") {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
+
+// newInvocationID returns a random 128-bit identifier of a service run
+func newInvocationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-env")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, "env")
+	require.NoError(t, ioutil.WriteFile(envFile, []byte("FILE=file\nOVERRIDE=file\n"), 0644))
+
+	require.NoError(t, os.Setenv("SYSTEMGO_PASSED", "passed"))
+	require.NoError(t, os.Setenv("SYSTEMGO_NOT_PASSED", "passed"))
+	defer os.Unsetenv("SYSTEMGO_PASSED")
+	defer os.Unsetenv("SYSTEMGO_NOT_PASSED")
+
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c "echo $OVERRIDE ${FILE} > `+filepath.Join(dir, "out")+`"
+Environment="OVERRIDE=environment" UNSET=1
+Environment=KEPT=1
+PassEnvironment=SYSTEMGO_PASSED
+EnvironmentFile=`+envFile+`
+EnvironmentFile=-`+filepath.Join(dir, "missing")+`
+UnsetEnvironment=UNSET KEPT=2`)), "sv.Define")
+
+	env, err := sv.environment()
+	if assert.NoError(t, err, "sv.environment") {
+		assert.Contains(t, env, "PATH="+DEFAULT_PATH)
+		assert.Contains(t, env, "SYSTEMGO_PASSED=passed")
+		assert.NotContains(t, env, "SYSTEMGO_NOT_PASSED=passed")
+		assert.Contains(t, env, "FILE=file")
+		assert.Contains(t, env, "OVERRIDE=file")
+		assert.Contains(t, env, "KEPT=1")
+		assert.NotContains(t, env, "UNSET=1")
+
+		var ids int
+		for _, kv := range env {
+			if strings.HasPrefix(kv, "INVOCATION_ID=") {
+				ids++
+				assert.Len(t, kv, len("INVOCATION_ID=")+32)
+			}
+		}
+		assert.Equal(t, 1, ids, "INVOCATION_ID set")
+	}
+
+	if assert.NoError(t, sv.Start(), "sv.Start") {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "file file\n", string(b))
+		}
+	}
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+EnvironmentFile=`+filepath.Join(dir, "missing"))), "sv.Define")
+
+	var logged []string
+	sv.SetOutputLog(func(priority int, identifier, line string) {
+		logged = append(logged, line)
+	})
+
+	assert.Error(t, sv.Start(), "sv.Start with missing EnvironmentFile")
+	if assert.Len(t, logged, 1, "unit log") {
+		assert.Contains(t, logged[0], "Failed to determine environment")
+	}
+
+	sv = &Unit{}
+	assert.Error(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+EnvironmentFile=env`)), "sv.Define with relative EnvironmentFile")
+}
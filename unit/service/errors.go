@@ -0,0 +1,27 @@
+package service
+
+import "errors"
+
+var ErrNoMainPID = errors.New("Main PID could not be determined")
+var ErrNoNotifySocket = errors.New("Notification socket is not set up")
+var ErrNotifyAccess = errors.New("Notification access denied")
+var ErrNotReady = errors.New("Process exited before signaling readiness")
+var ErrMultipleExecStart = errors.New("Multiple commands are only allowed for oneshot services")
+var ErrStartTimeout = errors.New("Start operation timed out")
+var ErrStopTimeout = errors.New("Stop operation timed out")
+var ErrUnknownCapability = errors.New("Unknown capability")
+var ErrUnknownSyscall = errors.New("Unknown system call")
+var ErrNotRealtime = errors.New("Priority requires a realtime scheduling policy")
+var ErrBusAddress = errors.New("No supported transport in bus address")
+var ErrBusAuth = errors.New("Bus authentication failed")
+var ErrBusMessage = errors.New("Malformed bus message")
+var ErrBusType = errors.New("Unsupported bus value type")
+var ErrBusReply = errors.New("Unexpected bus reply")
+var ErrTTYBusy = errors.New("Terminal is the controlling terminal of another session")
+var ErrNotTTY = errors.New("Not a terminal")
+var ErrCredentialNotFound = errors.New("Credential not found")
+var ErrCredentialSize = errors.New("Credential is too large")
+var ErrNoReload = errors.New("Reload is not supported by the service")
+var ErrNotRunning = errors.New("Service is not running")
+var ErrReloadTimeout = errors.New("Reload operation timed out")
+var ErrReloadExited = errors.New("Main process exited before completing reload")
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// command returns an unstarted command for c.
+// The command is run in the environment of the main process of the service
+func (sv *Unit) command(c unit.ExecCommand) *exec.Cmd {
+	sv.mutex.Lock()
+	env := append([]string{}, environ(sv.env)...)
+	sv.mutex.Unlock()
+
+	if pid := sv.MainPID(); pid > 0 {
+		env = setEnv(env, "MAINPID", strconv.Itoa(pid))
+	}
+
+	cmd := exec.Command(c.Path)
+	if argv := c.Expand(env); len(argv) > 0 {
+		cmd.Args = argv
+	}
+	cmd.Env = env
+	cmd.Dir = sv.Definition.Service.WorkingDirectory
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: sv.credential(c),
+		Setpgid:    true,
+	}
+	return cmd
+}
+
+// runControl runs the commands specified one after another in lifecycle phase specified.
+// Execution stops at the first command, which fails, unless its failure is ignored
+func (sv *Unit) runControl(phase string, cmds []unit.ExecCommand) (err error) {
+	for _, c := range cmds {
+		e := log.WithFields(log.Fields{
+			"phase": phase,
+			"cmd":   c.Argv,
+		})
+		e.Debug("sv.runControl")
+
+		cmd := sv.command(c)
+
+		var started func()
+		if started, err = sv.connectStdio(cmd); err == nil {
+			err = sv.startCmd(cmd, c, false)
+			started()
+		}
+
+		if err == nil {
+			sv.setControl(phase, cmd.Process.Pid)
+			err = sv.wait(cmd)
+			sv.setControl("", 0)
+		}
+
+		switch {
+		case err == nil:
+		case c.IgnoreFailure:
+			e.WithField("err", err).Debug("failure ignored")
+			err = nil
+		default:
+			e.WithField("err", err).Debug("failed")
+			return
+		}
+	}
+	return nil
+}
+
+// mainCommand returns the command the main process of the service runs
+func (sv *Unit) mainCommand() unit.ExecCommand {
+	if cmds := sv.commands["ExecStart"]; len(cmds) > 0 {
+		return cmds[0]
+	}
+	return unit.ExecCommand{}
+}
+
+// ignoresFailure reports whether failure of the main process of the service is ignored
+func (sv *Unit) ignoresFailure() bool {
+	cmds := sv.commands["ExecStart"]
+	return len(cmds) > 0 && cmds[0].IgnoreFailure
+}
+
+func (sv *Unit) setControl(phase string, pid int) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.phase = phase
+	sv.controlPID = pid
+	sv.controlStart = startTime(pid)
+}
+
+func (sv *Unit) setPhase(phase string) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.phase = phase
+}
+
+// state returns the phase a control process is running in, if any, and whether
+// the last start of the service failed
+func (sv *Unit) state() (phase string, startFailed bool) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	return sv.phase, sv.startFailed
+}
+
+// checkCondition runs ExecCondition commands and reports whether the service should be started.
+// Exit codes 1 through 254 mean that the service should be skipped, while exit code 255
+// or abnormal termination are considered a failure
+func (sv *Unit) checkCondition() (ok bool, err error) {
+	for _, c := range sv.commands["ExecCondition"] {
+		// Failure of a condition check is never ignored, as it carries the result
+		c.IgnoreFailure = false
+
+		err = sv.runControl(condition, []unit.ExecCommand{c})
+		if exitErr, isExit := err.(*exec.ExitError); isExit {
+			if code := exitErr.ExitCode(); code >= 1 && code <= 254 {
+				return false, nil
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// failStart marks the start of the service as failed with err, terminates the processes
+// of the service and runs ExecStopPost commands. err is returned
+func (sv *Unit) failStart(err error) error {
+	result := resultExitCode
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result = exitResult(exitStatusOf(exitErr.ProcessState))
+	}
+
+	sv.mutex.Lock()
+	if sv.timedOut {
+		err, result = ErrStartTimeout, resultTimeout
+	}
+	sv.startFailed = true
+	sv.result = result
+	sv.scheduleRestart(result)
+	restarting := sv.restarting
+	sv.mutex.Unlock()
+
+	if perr := sv.runStop(false); perr != nil {
+		log.WithField("ExecStopPost", sv.Definition.Service.ExecStopPost).Warnf("Failed to run stop commands: %s", perr)
+	}
+	sv.removeRuntimeDirectories(!restarting)
+	return err
+}
+
+// runStop runs ExecStop commands if execStop is true, terminates the processes of the service
+// according to KillMode and runs ExecStopPost commands. Processes remaining afterwards are terminated as well.
+// ExecStop commands, which do not complete in TimeoutStopSec, are aborted and the processes are terminated.
+// The credentials directory is removed, as are runtime directories, unless preserved until the service is stopped for good
+func (sv *Unit) runStop(execStop bool) (err error) {
+	if execStop {
+		err = sv.runExecStop()
+	}
+
+	sv.terminate(stopSigterm, stopSigkill, sv.Definition.Service.TimeoutStopSec)
+
+	if perr := sv.runControl(stopPost, sv.commands["ExecStopPost"]); err == nil {
+		err = perr
+	}
+
+	if mode := sv.Definition.Service.KillMode; mode == "control-group" || mode == "mixed" {
+		sv.terminate(finalSigterm, finalSigkill, sv.Definition.Service.TimeoutStopSec)
+	}
+
+	sv.cleanupSandbox()
+	sv.removeCredentials()
+
+	sv.mutex.Lock()
+	stopping := sv.stopping
+	sv.mutex.Unlock()
+	sv.removeRuntimeDirectories(stopping)
+	return
+}
+
+// runExecStop runs ExecStop commands in the stop phase for up to TimeoutStopSec
+func (sv *Unit) runExecStop() (err error) {
+	timedOut := make(chan struct{})
+	if timeout := sv.Definition.Service.TimeoutStopSec; timeout > 0 && timeout != unit.Infinity {
+		timer := time.AfterFunc(timeout, func() {
+			close(timedOut)
+			sv.abortStop(timeout)
+		})
+		defer timer.Stop()
+	}
+
+	err = sv.runControl(stop, sv.commands["ExecStop"])
+
+	select {
+	case <-timedOut:
+		err = ErrStopTimeout
+	default:
+	}
+	return
+}
+
+// abortStop terminates the stop control process, which did not complete in TimeoutStopSec.
+// It is sent KillSignal and FinalKillSignal, if it does not exit in timeout and SendSIGKILL is set
+func (sv *Unit) abortStop(timeout time.Duration) {
+	sv.mutex.Lock()
+	phase, pid := sv.phase, sv.controlPID
+	sv.mutex.Unlock()
+
+	log.WithField("ExecStop", sv.Definition.Service.ExecStop).Warn("Stop operation timed out")
+	if phase != stop || pid <= 0 {
+		return
+	}
+
+	def := sv.Definition.Service
+	sig, err := parseSignal(def.KillSignal)
+	if err != nil {
+		sig = syscall.SIGTERM
+	}
+	// Control processes lead their own process groups
+	syscall.Kill(-pid, sig)
+	if waitExit([]int{pid}, timeout) || !def.SendSIGKILL {
+		return
+	}
+
+	if sig, err = parseSignal(def.FinalKillSignal); err != nil {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(-pid, sig)
+}
+
+// exited handles the exit of the main process of the service started by cmd with result specified.
+// Unless the service is being stopped or remains active after exit, stop commands are run
+func (sv *Unit) exited(cmd *exec.Cmd, result string) {
+	sv.mutex.Lock()
+	current := cmd == sv.main
+	skip := !current || sv.stopping || sv.startFailed || sv.Definition.Service.RemainAfterExit
+	if current {
+		sv.stopWatchdog()
+	}
+	sv.mutex.Unlock()
+
+	// ExecStop commands are not run, if the processes were terminated by the watchdog
+	execStop := true
+	if current && sv.watchdogResult() {
+		result = resultWatchdog
+		execStop = false
+	}
+
+	if !skip {
+		if err := sv.runStop(execStop); err != nil {
+			log.WithField("ExecStart", sv.Definition.Service.ExecStart).Warnf("Failed to run stop commands: %s", err)
+		}
+	}
+
+	sv.handleExit(cmd, result)
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-exec")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Script appending its argument to the log
+	log := filepath.Join(dir, "log")
+	script := filepath.Join(dir, "record.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte("echo $1 >> "+log), 0644))
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(fmt.Sprintf(`[Service]
+Type=oneshot
+ExecCondition=/bin/true
+ExecStartPre=/bin/sh %[1]s pre1
+ExecStartPre=/bin/sh %[1]s pre2
+ExecStart=/bin/sh %[1]s start1
+ExecStart=/bin/sh %[1]s start2
+ExecStartPost=/bin/sh %[1]s post
+ExecStop=/bin/sh %[1]s stop
+ExecStopPost=/bin/sh %[1]s stoppost`, script))), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	assert.Equal(t, dead, sv.Sub(), "sv.Sub")
+
+	b, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, "pre1\npre2\nstart1\nstart2\npost\nstop\nstoppost\n", string(b))
+}
+
+func TestLifecycleSub(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStartPre=/bin/sleep 0.5
+ExecStart=/bin/sleep 60
+ExecStopPost=/bin/sleep 0.5`)), "sv.Define")
+
+	errch := make(chan error, 1)
+	go func() { errch <- sv.Start() }()
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, startPre, sv.Sub(), "sv.Sub during ExecStartPre")
+
+	require.NoError(t, <-errch, "sv.Start")
+	assert.Equal(t, running, sv.Sub(), "sv.Sub")
+
+	go func() { errch <- sv.Stop() }()
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, stopPost, sv.Sub(), "sv.Sub during ExecStopPost")
+
+	assert.NoError(t, <-errch, "sv.Stop")
+}
+
+func TestCondition(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecCondition=/bin/false
+ExecStart=/bin/sleep 60`)), "sv.Define")
+
+	assert.NoError(t, sv.Start(), "sv.Start with unmet condition")
+	cmd, _ := sv.mainCmd()
+	assert.Nil(t, cmd, "main process started")
+	assert.Equal(t, dead, sv.Sub(), "sv.Sub")
+
+	dir, err := ioutil.TempDir("", "systemgo-exec")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(dir, "condition.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte("exit 255"), 0644))
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecCondition=/bin/sh `+script+`
+ExecStart=/bin/sleep 60`)), "sv.Define")
+
+	assert.Error(t, sv.Start(), "sv.Start with failing condition")
+	cmd, _ = sv.mainCmd()
+	assert.Nil(t, cmd, "main process started")
+	assert.Equal(t, failed, sv.Sub(), "sv.Sub")
+}
+
+func TestConditionCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-exec")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer useDirectoryRoots(t, dir)()
+	defer useCredentialDirs(t, filepath.Join(dir, "credentials"), filepath.Join(dir, "store"))()
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecCondition=/bin/false
+ExecStart=/bin/sleep 60
+RuntimeDirectory=rt
+SetCredential=c:data
+PrivateTmp=yes`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start with unmet condition")
+	cmd, _ := sv.mainCmd()
+	assert.Nil(t, cmd, "main process started")
+
+	// Nothing is left behind by the skipped start
+	_, err = os.Stat(filepath.Join(dir, "runtime/rt"))
+	assert.True(t, os.IsNotExist(err), "runtime directory exists")
+	assert.Empty(t, sv.credentialsDir, "credentials directory")
+	names, _ := ioutil.ReadDir(filepath.Join(dir, "credentials"))
+	assert.Empty(t, names, "credentials directory exists")
+	assert.Empty(t, sv.runtimeDir, "private directories")
+	assert.Empty(t, sv.varTmpDir, "private directories")
+}
+
+func TestStartPostFailure(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 60
+ExecStartPost=/bin/false`)), "sv.Define")
+
+	assert.Error(t, sv.Start(), "sv.Start")
+	assert.Equal(t, failed, sv.Sub(), "sv.Sub")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, sv.MainPID(), "main process is still running")
+}
+
+func TestIgnoreFailure(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStartPre=-/bin/false
+ExecStart=-/bin/sh -c "exit 3"
+ExecStart=/bin/true`)), "sv.Define")
+
+	assert.NoError(t, sv.Start(), "sv.Start")
+	assert.Equal(t, resultSuccess, sv.Result(), "sv.Result")
+}
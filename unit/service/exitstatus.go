@@ -0,0 +1,108 @@
+package service
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// exitStatusSet is a set of exit codes and signals, as specified by SuccessExitStatus=,
+// RestartPreventExitStatus= and RestartForceExitStatus=
+type exitStatusSet struct {
+	codes   map[int]bool
+	signals map[string]bool
+}
+
+// parseExitStatusSet parses exit codes and signal names separated by spaces in lines
+// of option name
+func parseExitStatusSet(merr *unit.MultiError, name string, lines unit.Lines) (set exitStatusSet) {
+	for _, line := range lines {
+		for _, s := range strings.Fields(line) {
+			if code, err := strconv.Atoi(s); err == nil {
+				if code < 0 || code > 255 {
+					*merr = append(*merr, unit.ParseErr(name, unit.ParseErr(s, unit.ErrWrongVal)))
+					continue
+				}
+				if set.codes == nil {
+					set.codes = map[int]bool{}
+				}
+				set.codes[code] = true
+				continue
+			}
+
+			sig, err := parseSignal(s)
+			if err != nil {
+				*merr = append(*merr, unit.ParseErr(name, unit.ParseErr(s, err)))
+				continue
+			}
+			if set.signals == nil {
+				set.signals = map[string]bool{}
+			}
+			set.signals[signalName(sig)] = true
+		}
+	}
+	return
+}
+
+// contains reports whether st is in the set
+func (set exitStatusSet) contains(st *unit.ExitStatus) bool {
+	switch {
+	case st == nil:
+		return false
+	case st.Signal != "":
+		return set.signals[st.Signal]
+	default:
+		return set.codes[st.Code]
+	}
+}
+
+// exitStatusOf returns the exit status described by state, nil if state is nil
+func exitStatusOf(state *os.ProcessState) *unit.ExitStatus {
+	if state == nil {
+		return nil
+	}
+
+	ws, ok := state.Sys().(syscall.WaitStatus)
+	if !ok {
+		return &unit.ExitStatus{Code: state.ExitCode()}
+	}
+	return waitExitStatus(ws)
+}
+
+// waitExitStatus returns the exit status described by ws
+func waitExitStatus(ws syscall.WaitStatus) *unit.ExitStatus {
+	if !ws.Signaled() {
+		return &unit.ExitStatus{Code: ws.ExitStatus()}
+	}
+	return &unit.ExitStatus{
+		Signal:   signalName(ws.Signal()),
+		CoreDump: ws.CoreDump(),
+	}
+}
+
+// setExitStatus records the exit status of the main process
+func (sv *Unit) setExitStatus(st *unit.ExitStatus) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.exitStatus = st
+}
+
+// ExitStatus returns the exit status of the main process of the last run, nil if unknown
+func (sv *Unit) ExitStatus() *unit.ExitStatus {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	return sv.exitStatus
+}
+
+// Result returns the result of the last run of the service, empty if unknown
+func (sv *Unit) Result() string {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	return sv.result
+}
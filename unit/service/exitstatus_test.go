@@ -0,0 +1,130 @@
+package service
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExitStatusSet(t *testing.T) {
+	var merr unit.MultiError
+	set := parseExitStatusSet(&merr, "SuccessExitStatus", unit.Lines{"75 SIGUSR1", "TERM 0"})
+	assert.Empty(t, merr)
+
+	assert.True(t, set.contains(&unit.ExitStatus{Code: 75}))
+	assert.True(t, set.contains(&unit.ExitStatus{Code: 0}))
+	assert.True(t, set.contains(&unit.ExitStatus{Signal: "SIGUSR1"}))
+	assert.True(t, set.contains(&unit.ExitStatus{Signal: "SIGTERM"}))
+	assert.False(t, set.contains(&unit.ExitStatus{Code: 1}))
+	assert.False(t, set.contains(&unit.ExitStatus{Signal: "SIGKILL"}))
+	assert.False(t, set.contains(nil))
+
+	for _, value := range []string{"256", "-1", "SIGNONE"} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\nSuccessExitStatus=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, value) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, value) {
+				assert.Equal(t, "SuccessExitStatus", merr[0].(unit.ParseError).Source, value)
+			}
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for cmd, expected := range map[string]unit.ExitStatus{
+		"exit 0":        {},
+		"exit 3":        {Code: 3},
+		"kill -TERM $$": {Signal: "SIGTERM"},
+		"kill -KILL $$": {Signal: "SIGKILL"},
+	} {
+		c := exec.Command("sh", "-c", cmd)
+		c.Run()
+		if st := exitStatusOf(c.ProcessState); assert.NotNil(t, st, cmd) {
+			assert.Equal(t, expected, *st, cmd)
+		}
+	}
+	assert.Nil(t, exitStatusOf(nil))
+}
+
+// waitResult waits for the exit of the main process of sv to be handled
+func waitResult(sv *Unit) {
+	for i := 0; i < 100 && sv.Result() == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSuccessExitStatus(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/sh -c \"exit 75\"")))
+	require.NoError(t, sv.Start(), "sv.Start")
+	waitResult(&sv)
+
+	assert.Equal(t, failed, sv.Sub(), "non-zero exit code")
+	assert.Equal(t, resultExitCode, sv.Result())
+	assert.Equal(t, &unit.ExitStatus{Code: 75}, sv.ExitStatus())
+
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/sh -c \"exit 75\"\nSuccessExitStatus=75")))
+	require.NoError(t, sv.Start(), "sv.Start")
+	waitResult(&sv)
+
+	assert.Equal(t, dead, sv.Sub(), "SuccessExitStatus")
+	assert.Equal(t, resultSuccess, sv.Result())
+	assert.Equal(t, &unit.ExitStatus{Code: 75}, sv.ExitStatus())
+
+	// Oneshot services do not fail to start
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nType=oneshot\nExecStart=/bin/sh -c \"kill -USR1 $$$$\"\nSuccessExitStatus=SIGUSR1")))
+	assert.NoError(t, sv.Start(), "sv.Start")
+	assert.Equal(t, dead, sv.Sub())
+	assert.Equal(t, &unit.ExitStatus{Signal: "SIGUSR1"}, sv.ExitStatus())
+
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nType=oneshot\nExecStart=/bin/sh -c \"kill -USR1 $$$$\"")))
+	assert.Error(t, sv.Start(), "sv.Start")
+	assert.Equal(t, failed, sv.Sub())
+	assert.Equal(t, resultSignal, sv.Result())
+}
+
+func TestRestartExitStatus(t *testing.T) {
+	for _, test := range []struct {
+		options  string
+		restarts bool
+	}{
+		{"Restart=always\nRestartPreventExitStatus=3", false},
+		{"Restart=always\nRestartPreventExitStatus=4", true},
+		{"Restart=no\nRestartForceExitStatus=3", true},
+		{"Restart=on-failure\nRestartForceExitStatus=3\nRestartPreventExitStatus=3", false},
+	} {
+		sv := Unit{}
+		require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/sh -c \"exit 3\"\nRestartSec=10ms\n"+test.options)), test.options)
+		require.NoError(t, sv.Start(), test.options)
+
+		select {
+		case <-sv.AutoRestart():
+			assert.True(t, test.restarts, test.options)
+		case <-time.After(500 * time.Millisecond):
+			assert.False(t, test.restarts, test.options)
+		}
+	}
+}
+
+func TestStatusResult(t *testing.T) {
+	sv := Unit{}
+	for st, expected := range map[*unit.ExitStatus]string{
+		nil:                                 resultUnknown,
+		{Code: 0}:                           resultSuccess,
+		{Code: 2}:                           resultExitCode,
+		{Signal: "SIGTERM"}:                 resultSuccess,
+		{Signal: "SIGKILL"}:                 resultSignal,
+		{Signal: "SIGSEGV", CoreDump: true}: resultCoreDump,
+	} {
+		assert.Equal(t, expected, sv.statusResult(st), "%v", st)
+	}
+
+	sv.successStatus = exitStatusSet{codes: map[int]bool{2: true}}
+	assert.Equal(t, resultSuccess, sv.statusResult(&unit.ExitStatus{Code: 2}), "SuccessExitStatus")
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+const DEFAULT_STANDARD_INPUT = "null"
+
+// Terminal used by services reading from a TTY if TTYPath is not specified
+const DEFAULT_TTY_PATH = "/dev/console"
+
+// ioctl(2) request, which is not defined by package syscall(see ioctl_tty(2))
+const TIOCVHANGUP = 0x5437
+
+// Types of input connections accepted by StandardInput.
+// "socket" is not supported, since socket activation is not implemented
+var inputTypes = map[string]bool{
+	"null":      true,
+	"tty":       true,
+	"tty-force": true,
+	"tty-fail":  true,
+	"data":      true,
+	"file":      true,
+}
+
+// parseInput parses a value of StandardInput
+func parseInput(s string) (typ, path string, err error) {
+	typ = s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		typ, path = s[:i], s[i+1:]
+	}
+
+	switch {
+	case !inputTypes[typ]:
+		return "", "", unit.ErrNotSupported
+	case typ == "file":
+		if !filepath.IsAbs(path) {
+			return "", "", unit.ErrPathNotAbs
+		}
+	case path != "":
+		return "", "", unit.ErrWrongVal
+	}
+	return
+}
+
+// isTTYInput reports whether the input type specified connects the standard input to a terminal
+func isTTYInput(typ string) bool {
+	return typ == "tty" || typ == "tty-force" || typ == "tty-fail"
+}
+
+// inputData returns the data specified by StandardInputText and StandardInputData in def
+func inputData(def Definition) (data []byte, err error) {
+	for _, line := range def.Service.StandardInputText {
+		data = append(data, line+"\n"...)
+	}
+	for _, line := range def.Service.StandardInputData {
+		var b []byte
+		if b, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(line), "")); err != nil {
+			return nil, unit.ParseErr("StandardInputData", unit.ErrWrongVal)
+		}
+		data = append(data, b...)
+	}
+	return
+}
+
+// ttyPath returns the path to the terminal used by the service
+func (sv *Unit) ttyPath() string {
+	if path := sv.Definition.Service.TTYPath; path != "" {
+		return path
+	}
+	return DEFAULT_TTY_PATH
+}
+
+// connectInput connects standard input of cmd as specified in definition.
+// The file returned is the terminal opened, if any, which must be closed along
+// with the other files returned once cmd is started
+func (sv *Unit) connectInput(cmd *exec.Cmd) (tty *os.File, files []*os.File, err error) {
+	var typ, path string
+	if typ, path, err = parseInput(sv.Definition.Service.StandardInput); err != nil {
+		return
+	}
+
+	cmd.Stdin = nil
+	switch {
+	case typ == "null":
+		return
+
+	case isTTYInput(typ):
+		if tty, err = sv.acquireTTY(typ); err != nil {
+			return
+		}
+		cmd.Stdin = tty
+
+		// Terminal becomes the controlling terminal of a new session, which the process leads
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = false
+		cmd.SysProcAttr.Setsid = true
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+		return tty, []*os.File{tty}, nil
+
+	case typ == "data":
+		data, _ := inputData(sv.Definition)
+
+		var r, w *os.File
+		if r, w, err = os.Pipe(); err != nil {
+			return
+		}
+		go func() {
+			defer w.Close()
+			w.Write(data)
+		}()
+		cmd.Stdin = r
+		return nil, []*os.File{r}, nil
+
+	case typ == "file":
+		var f *os.File
+		if f, err = os.Open(path); err != nil {
+			return
+		}
+		cmd.Stdin = f
+		return nil, []*os.File{f}, nil
+
+	default:
+		panic("Unknown input type")
+	}
+}
+
+// acquireTTY opens the terminal of the service for a process to acquire as its controlling terminal.
+// Unless typ is "tty-force", the terminal must not be the controlling terminal of another session:
+// "tty-fail" fails if it is, "tty" waits for it to be released for up to TimeoutStartSec.
+// The terminal is hung up and reset before it is returned, if requested
+func (sv *Unit) acquireTTY(typ string) (tty *os.File, err error) {
+	path := sv.ttyPath()
+
+	if typ != "tty-force" {
+		var deadline time.Time
+		if timeout := sv.Definition.Service.TimeoutStartSec; timeout > 0 && timeout != unit.Infinity {
+			deadline = time.Now().Add(timeout)
+		}
+
+		for {
+			var sid int
+			if sid, err = ttySession(path); err != nil {
+				return
+			}
+			if sid == 0 {
+				break
+			}
+			if typ == "tty-fail" || !deadline.IsZero() && time.Now().After(deadline) {
+				return nil, ErrTTYBusy
+			}
+			log.WithFields(log.Fields{
+				"tty": path,
+				"sid": sid,
+			}).Debugf("Waiting for terminal to be released")
+			time.Sleep(POLL_INTERVAL)
+		}
+	}
+
+	if sv.Definition.Service.TTYVHangup {
+		if err = vhangupTTY(path); err != nil {
+			return
+		}
+	}
+
+	if tty, err = os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0); err != nil {
+		return
+	}
+
+	if sv.Definition.Service.TTYReset {
+		if err = resetTTY(tty); err != nil {
+			tty.Close()
+			return nil, err
+		}
+	}
+	return tty, nil
+}
+
+// ttySession returns the ID of the session the terminal at path is the controlling terminal of, 0 if none
+func ttySession(path string) (sid int, err error) {
+	var st syscall.Stat_t
+	if err = syscall.Stat(path, &st); err != nil {
+		return
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFCHR {
+		return 0, ErrNotTTY
+	}
+
+	pids, err := readDirNames("/proc")
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range pids {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		// Controlling terminal is held by the session leader
+		if ps, err := unit.ReadProcStat(pid); err == nil && ps.SID == pid && ps.TTY != 0 && uint64(ps.TTY) == uint64(st.Rdev) && ps.State != 'Z' {
+			return pid, nil
+		}
+	}
+	return 0, nil
+}
+
+// vhangupTTY hangs up the terminal at path, so that the processes using it lose access to it
+func vhangupTTY(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ioctl(f.Fd(), TIOCVHANGUP, 0)
+}
+
+// resetTTY restores sane terminal settings of tty and resets the terminal
+func resetTTY(tty *os.File) (err error) {
+	var t syscall.Termios
+	if err = ioctl(tty.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return
+	}
+
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.IUCLC
+	t.Iflag |= syscall.ICRNL | syscall.IMAXBEL | syscall.IUTF8
+	t.Oflag |= syscall.ONLCR | syscall.OPOST
+	t.Cflag |= syscall.CREAD
+	t.Lflag = syscall.ISIG | syscall.ICANON | syscall.IEXTEN | syscall.ECHO | syscall.ECHOE | syscall.ECHOK | syscall.ECHOCTL | syscall.ECHOKE
+
+	t.Cc[syscall.VINTR] = 3     // ^C
+	t.Cc[syscall.VQUIT] = 28    // ^\
+	t.Cc[syscall.VERASE] = 127  // DEL
+	t.Cc[syscall.VKILL] = 21    // ^U
+	t.Cc[syscall.VEOF] = 4      // ^D
+	t.Cc[syscall.VSTART] = 17   // ^Q
+	t.Cc[syscall.VSTOP] = 19    // ^S
+	t.Cc[syscall.VSUSP] = 26    // ^Z
+	t.Cc[syscall.VLNEXT] = 22   // ^V
+	t.Cc[syscall.VWERASE] = 23  // ^W
+	t.Cc[syscall.VREPRINT] = 18 // ^R
+	t.Cc[syscall.VEOL] = 0
+	t.Cc[syscall.VEOL2] = 0
+	t.Cc[syscall.VTIME] = 0
+	t.Cc[syscall.VMIN] = 1
+
+	if err = ioctl(tty.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&t))); err != nil {
+		return
+	}
+
+	// Full reset(RIS) of the terminal
+	_, err = tty.Write([]byte("\033c"))
+	return
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openPTY opens a pseudo-terminal pair and returns the master and the path to the slave
+func openPTY(t *testing.T) (master *os.File, slave string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals not available: %s", err)
+	}
+
+	var n uint32
+	require.NoError(t, ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))))
+	var unlock int32
+	require.NoError(t, ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))))
+
+	return master, "/dev/pts/" + strconv.Itoa(int(n))
+}
+
+// readUntil reads from f until the output contains s or timeout elapses
+func readUntil(f *os.File, s string, timeout time.Duration) string {
+	f.SetReadDeadline(time.Now().Add(timeout))
+	defer f.SetReadDeadline(time.Time{})
+
+	var out []byte
+	buf := make([]byte, 256)
+	for !strings.Contains(string(out), s) {
+		n, err := f.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	return string(out)
+}
+
+func TestParseInput(t *testing.T) {
+	for s, expected := range map[string][2]string{
+		"null":         {"null", ""},
+		"tty":          {"tty", ""},
+		"tty-force":    {"tty-force", ""},
+		"tty-fail":     {"tty-fail", ""},
+		"data":         {"data", ""},
+		"file:/tmp/in": {"file", "/tmp/in"},
+	} {
+		typ, path, err := parseInput(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, [2]string{typ, path}, s)
+		}
+	}
+
+	for s, expected := range map[string]error{
+		"keyboard":  unit.ErrNotSupported,
+		"socket":    unit.ErrNotSupported,
+		"file:in":   unit.ErrPathNotAbs,
+		"tty:/dev1": unit.ErrWrongVal,
+	} {
+		_, _, err := parseInput(s)
+		assert.Equal(t, expected, err, s)
+	}
+}
+
+func TestDefineInput(t *testing.T) {
+	for option, value := range map[string]string{
+		"StandardInput":     "keyboard",
+		"StandardInputData": "not base64!",
+		"TTYPath":           "tty1",
+	} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true")))
+	assert.Equal(t, DEFAULT_STANDARD_INPUT, sv.Definition.Service.StandardInput)
+
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\nStandardInputText=hello")))
+	assert.Equal(t, "data", sv.Definition.Service.StandardInput, "data specified")
+}
+
+func TestInputData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-input")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	require.NoError(t, ioutil.WriteFile(in, []byte("from file\n"), 0644))
+
+	for input, expected := range map[string]string{
+		"StandardInputText=hello\nStandardInputText=there\nStandardInputData=d29y\nStandardInputData=bGQK": "hello\nthere\nworld\n",
+		"StandardInput=file:" + in: "from file\n",
+		"StandardInput=null":       "",
+	} {
+		sv := Unit{}
+		require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c 'cat > `+out+`'
+`+input)), input)
+		require.NoError(t, sv.Start(), input)
+
+		b, err := ioutil.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(b), input)
+	}
+}
+
+func TestInputTTY(t *testing.T) {
+	master, slave := openPTY(t)
+	defer master.Close()
+
+	// Line is buffered by the terminal until it is read by the service
+	_, err := master.Write([]byte("input\n"))
+	require.NoError(t, err)
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c 'read line; echo "got $line" > /dev/tty'
+StandardInput=tty-fail
+StandardOutput=tty
+TTYPath=`+slave+`
+TTYReset=yes`)))
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	// Writing to /dev/tty requires the terminal to be the controlling terminal
+	assert.Contains(t, readUntil(master, "got input", time.Second), "got input")
+}
+
+func TestInputTTYBusy(t *testing.T) {
+	master, slave := openPTY(t)
+	defer master.Close()
+
+	tty, err := os.OpenFile(slave, os.O_RDWR|syscall.O_NOCTTY, 0)
+	require.NoError(t, err)
+	defer tty.Close()
+
+	// Another session holds the terminal as its controlling terminal
+	holder := exec.Command("/bin/sleep", "10")
+	holder.Stdin = tty
+	holder.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	require.NoError(t, holder.Start())
+	defer holder.Process.Kill()
+
+	for i := 0; i < 100; i++ {
+		if sid, _ := ttySession(slave); sid == holder.Process.Pid {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sid, err := ttySession(slave)
+	require.NoError(t, err)
+	require.Equal(t, holder.Process.Pid, sid, "terminal held")
+
+	define := func(input string) *Unit {
+		sv := &Unit{}
+		require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/true
+StandardInput=`+input+`
+TTYPath=`+slave+`
+TTYVHangup=yes`)), input)
+		return sv
+	}
+
+	assert.Equal(t, ErrTTYBusy, define("tty-fail").Start(), "tty-fail")
+
+	// Terminal is released while waiting for it
+	go func(holder *exec.Cmd) {
+		time.Sleep(3 * POLL_INTERVAL)
+		holder.Process.Kill()
+		holder.Wait()
+	}(holder)
+	start := time.Now()
+	assert.NoError(t, define("tty").Start(), "tty")
+	assert.True(t, time.Since(start) >= 3*POLL_INTERVAL, "waited for the terminal")
+
+	if os.Getuid() != 0 {
+		return
+	}
+
+	// Terminal is stolen from the session holding it.
+	// Descriptors opened earlier were hung up by TTYVHangup
+	tty, err = os.OpenFile(slave, os.O_RDWR|syscall.O_NOCTTY, 0)
+	require.NoError(t, err)
+	defer tty.Close()
+
+	holder = exec.Command("/bin/sleep", "10")
+	holder.Stdin = tty
+	holder.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	require.NoError(t, holder.Start())
+	defer holder.Process.Kill()
+
+	assert.NoError(t, define("tty-force").Start(), "tty-force")
+}
+
+func TestInputSocket(t *testing.T) {
+	sv := Unit{}
+	err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\nStandardInput=socket"))
+	if assert.IsType(t, unit.MultiError{}, err) {
+		pe := err.(unit.MultiError)[0].(unit.ParseError)
+		assert.Equal(t, "StandardInput", pe.Source)
+		assert.Equal(t, unit.ErrNotSupported, pe.Err.(unit.ParseError).Err)
+	}
+}
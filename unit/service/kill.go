@@ -0,0 +1,266 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Default time to wait for the processes of a service to exit after being signaled
+const DEFAULT_TIMEOUT_STOP_SEC = 90 * time.Second
+
+const DEFAULT_KILL_MODE = "control-group"
+
+var killModes = map[string]bool{
+	"control-group": true,
+	"mixed":         true,
+	"process":       true,
+	"none":          true,
+}
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":    syscall.SIGHUP,
+	"SIGINT":    syscall.SIGINT,
+	"SIGQUIT":   syscall.SIGQUIT,
+	"SIGILL":    syscall.SIGILL,
+	"SIGTRAP":   syscall.SIGTRAP,
+	"SIGABRT":   syscall.SIGABRT,
+	"SIGBUS":    syscall.SIGBUS,
+	"SIGFPE":    syscall.SIGFPE,
+	"SIGKILL":   syscall.SIGKILL,
+	"SIGUSR1":   syscall.SIGUSR1,
+	"SIGSEGV":   syscall.SIGSEGV,
+	"SIGUSR2":   syscall.SIGUSR2,
+	"SIGPIPE":   syscall.SIGPIPE,
+	"SIGALRM":   syscall.SIGALRM,
+	"SIGTERM":   syscall.SIGTERM,
+	"SIGCHLD":   syscall.SIGCHLD,
+	"SIGCONT":   syscall.SIGCONT,
+	"SIGSTOP":   syscall.SIGSTOP,
+	"SIGTSTP":   syscall.SIGTSTP,
+	"SIGTTIN":   syscall.SIGTTIN,
+	"SIGTTOU":   syscall.SIGTTOU,
+	"SIGURG":    syscall.SIGURG,
+	"SIGXCPU":   syscall.SIGXCPU,
+	"SIGXFSZ":   syscall.SIGXFSZ,
+	"SIGVTALRM": syscall.SIGVTALRM,
+	"SIGPROF":   syscall.SIGPROF,
+	"SIGWINCH":  syscall.SIGWINCH,
+	"SIGIO":     syscall.SIGIO,
+	"SIGPWR":    syscall.SIGPWR,
+	"SIGSYS":    syscall.SIGSYS,
+}
+
+// parseSignal parses a signal specified by name, with or without the "SIG" prefix, or number
+func parseSignal(s string) (sig syscall.Signal, err error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > 64 {
+			return 0, unit.ErrWrongVal
+		}
+		return syscall.Signal(n), nil
+	}
+
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+
+	sig, ok := signals[s]
+	if !ok {
+		return 0, unit.ErrWrongVal
+	}
+	return sig, nil
+}
+
+// signalName returns the name of sig, e.g. "SIGTERM", or its number if the name is unknown
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// processes returns PIDs of living processes of the service: the main and control
+// processes, members of the process groups of the service and their descendants
+func (sv *Unit) processes() (pids []int) {
+	sv.mutex.Lock()
+	roots := []int{sv.mainPID, sv.controlPID}
+	starts := map[int]uint64{sv.mainPID: sv.mainStart, sv.controlPID: sv.controlStart}
+	cmd, leaderStart := sv.main, sv.leaderStart
+	sv.mutex.Unlock()
+
+	// Main process leads the process group of the service
+	leader := 0
+	if cmd != nil {
+		leader = cmd.Process.Pid
+	}
+
+	names, err := readDirNames("/proc")
+	if err != nil {
+		// Only the processes known can be found without procfs
+		for _, pid := range roots {
+			if isAlive(pid) {
+				pids = append(pids, pid)
+			}
+		}
+		return
+	}
+
+	stats := map[int]unit.ProcStat{}
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		if st, err := unit.ReadProcStat(pid); err == nil && !st.Zombie() {
+			stats[pid] = st
+		}
+	}
+
+	// PIDs of the processes known could have been reused by other processes after they were reaped
+	for i, pid := range roots {
+		if st, ok := stats[pid]; ok && st.Start != starts[pid] {
+			roots[i] = 0
+		}
+	}
+	if st, ok := stats[leader]; ok && st.Start != leaderStart {
+		leader = 0
+	}
+
+	groups := map[int]bool{}
+	for pid, st := range stats {
+		// The process group of the main process started is only signaled while it has
+		// living members, the group ID may be reused afterwards
+		if leader > 0 && st.PGID == leader || pid == roots[0] || pid == roots[1] {
+			groups[st.PGID] = true
+		}
+	}
+	// Never signal the manager itself
+	delete(groups, syscall.Getpgrp())
+
+	found := map[int]bool{}
+	for _, pid := range roots {
+		if _, ok := stats[pid]; ok {
+			found[pid] = true
+		}
+	}
+	for pid, st := range stats {
+		if groups[st.PGID] {
+			found[pid] = true
+		}
+	}
+
+	// Add descendants until there are no more to add
+	for added := true; added; {
+		added = false
+		for pid, st := range stats {
+			if !found[pid] && found[st.PPID] {
+				found[pid] = true
+				added = true
+			}
+		}
+	}
+	delete(found, syscall.Getpid())
+
+	for pid := range found {
+		pids = append(pids, pid)
+	}
+	return
+}
+
+// signal sends sig to the processes of the service according to KillMode and returns the PIDs signaled.
+// If main is true, only the main process is signaled in "mixed" mode
+func (sv *Unit) signal(sig syscall.Signal, main bool) (pids []int) {
+	switch mode := sv.Definition.Service.KillMode; {
+	case mode == "none":
+		return nil
+	case mode == "process", mode == "mixed" && main:
+		if pid := sv.MainPID(); pid > 0 {
+			pids = []int{pid}
+		}
+	default:
+		pids = sv.processes()
+	}
+
+	log.WithFields(log.Fields{
+		"signal": sig,
+		"pids":   pids,
+	}).Debug("sv.signal")
+
+	for _, pid := range pids {
+		syscall.Kill(pid, sig)
+	}
+	return
+}
+
+// terminate sends KillSignal to the processes of the service in termPhase and waits for them
+// to exit for timeout. If they do not, FinalKillSignal is sent in killPhase, if SendSIGKILL is set
+func (sv *Unit) terminate(termPhase, killPhase string, timeout time.Duration) {
+	killSignal, err := parseSignal(sv.Definition.Service.KillSignal)
+	if err != nil {
+		killSignal = syscall.SIGTERM
+	}
+	sv.terminateWith(killSignal, termPhase, killPhase, timeout)
+}
+
+// terminateWith is like terminate, but sends sig instead of KillSignal
+func (sv *Unit) terminateWith(sig syscall.Signal, termPhase, killPhase string, timeout time.Duration) {
+	def := sv.Definition.Service
+	defer sv.setPhase("")
+
+	// Only the main process gets sig in mixed mode, the rest are killed afterwards
+	main := termPhase != finalSigterm
+
+	sv.setPhase(termPhase)
+	pids := sv.signal(sig, main)
+	if def.SendSIGHUP {
+		sv.signal(syscall.SIGHUP, main)
+	}
+
+	exited := len(pids) == 0 || waitExit(pids, timeout)
+	if exited && !(main && def.KillMode == "mixed") || !def.SendSIGKILL {
+		return
+	}
+
+	sv.setPhase(killPhase)
+	finalSignal, err := parseSignal(def.FinalKillSignal)
+	if err != nil {
+		finalSignal = syscall.SIGKILL
+	}
+	if pids = sv.signal(finalSignal, false); len(pids) > 0 && !waitExit(pids, timeout) {
+		log.WithField("pids", pids).Warn("Processes remaining after being killed")
+	}
+}
+
+// waitExit waits for processes specified to exit for timeout and reports whether they did.
+// Zero timeout or Infinity mean that there is no time limit
+func waitExit(pids []int, timeout time.Duration) bool {
+	var deadline time.Time
+	if timeout > 0 && timeout != unit.Infinity {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		alive := false
+		for _, pid := range pids {
+			if isAlive(pid) {
+				alive = true
+				break
+			}
+		}
+
+		switch {
+		case !alive:
+			return true
+		case !deadline.IsZero() && time.Now().After(deadline):
+			return false
+		}
+		time.Sleep(POLL_INTERVAL / 10)
+	}
+}
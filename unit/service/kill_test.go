@@ -0,0 +1,189 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSignal(t *testing.T) {
+	for s, expected := range map[string]syscall.Signal{
+		"SIGTERM": syscall.SIGTERM,
+		"TERM":    syscall.SIGTERM,
+		"9":       syscall.SIGKILL,
+		"SIGHUP":  syscall.SIGHUP,
+	} {
+		sig, err := parseSignal(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, sig, s)
+		}
+	}
+
+	for _, s := range []string{"", "0", "SIGFOO", "sigterm", "100"} {
+		_, err := parseSignal(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestStopProcessTree(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sh -c "sleep 60 & sleep 60"`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	time.Sleep(200 * time.Millisecond)
+
+	pids := sv.processes()
+	assert.Len(t, pids, 3, "sh and two sleep processes")
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	for _, pid := range pids {
+		assert.False(t, isAlive(pid), "process %d is alive", pid)
+	}
+}
+
+func TestStopKillModeProcess(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sh -c "sleep 60 & sleep 60"
+KillMode=process`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	time.Sleep(200 * time.Millisecond)
+
+	pids := sv.processes()
+	main := sv.MainPID()
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	assert.False(t, isAlive(main), "main process is alive")
+
+	var remaining int
+	for _, pid := range pids {
+		if isAlive(pid) {
+			remaining++
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	assert.Equal(t, 2, remaining, "processes other than main are left running")
+}
+
+func TestStopEscalation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-kill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Script ignoring SIGTERM
+	script := filepath.Join(dir, "ignore.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte(`trap "" TERM
+while true; do sleep 0.1; done`), 0644))
+
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sh `+script+`
+TimeoutStopSec=500ms`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	time.Sleep(200 * time.Millisecond)
+	errch := make(chan error, 1)
+	go func() { errch <- sv.Stop() }()
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, stopSigterm, sv.Sub(), "sv.Sub before timeout")
+
+	require.NoError(t, <-errch, "sv.Stop")
+	assert.Empty(t, sv.processes(), "processes of the service are alive")
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sh `+script+`
+TimeoutStopSec=200ms
+SendSIGKILL=no`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	time.Sleep(200 * time.Millisecond)
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	assert.NotEmpty(t, sv.processes(), "processes of the service were killed")
+	cmd, _ := sv.mainCmd()
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func TestStopExecStopTimeout(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 60
+ExecStop=/bin/sh -c 'trap "" TERM; while true; do sleep 0.1; done'
+TimeoutStopSec=300ms`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	main := sv.MainPID()
+
+	start := time.Now()
+	assert.Equal(t, ErrStopTimeout, sv.Stop(), "sv.Stop")
+	assert.True(t, time.Since(start) < 5*time.Second, "stop job completed")
+	assert.False(t, isAlive(main), "main process is alive")
+	assert.Empty(t, sv.processes(), "processes of the service are alive")
+}
+
+func TestProcessesReapedGroup(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh -c "sleep 60 &"`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+	_, state := sv.mainCmd()
+	require.NotNil(t, state, "main process was reaped")
+
+	// Process group of the reaped main process is found while it has members
+	pids := sv.processes()
+	assert.Len(t, pids, 1, "sleep process")
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	for _, pid := range pids {
+		assert.False(t, isAlive(pid), "process %d is alive", pid)
+	}
+	assert.Empty(t, sv.processes())
+}
+
+func TestProcessesReusedPID(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/true`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	sv.mutex.Lock()
+	main := sv.mainPID
+	sv.mutex.Unlock()
+	assert.Zero(t, main, "main PID after the main process exited")
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/sleep 60")), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+
+	// PID of the main process is taken by a foreign process, started at a later clock tick
+	time.Sleep(50 * time.Millisecond)
+	foreign := exec.Command("/bin/sleep", "60")
+	foreign.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, foreign.Start())
+	defer foreign.Wait()
+	defer foreign.Process.Kill()
+
+	sv.mutex.Lock()
+	sv.mainPID = foreign.Process.Pid
+	sv.mutex.Unlock()
+
+	assert.NotContains(t, sv.processes(), foreign.Process.Pid, "foreign process")
+	assert.Zero(t, sv.MainPID(), "sv.MainPID")
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// argv[0] the manager executable is run with to act as the sandbox helper
+const SANDBOX_HELPER = "systemgo-sandbox"
+
+// Exit status of the sandbox helper, which failed to set up the sandbox (see systemd.exec(5))
+const EXIT_NAMESPACE = 226
+
+// O_PATH open flag, which is not defined by package syscall (see open(2))
+const O_PATH = 010000000
+
+// Mount flags preserved when remounting
+const remountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+// RunSandboxHelper sets up the sandbox and executes the command of a service, if the process
+// was started as the sandbox helper, and returns otherwise. Programs starting services must call
+// it first thing in main, since the helper is started by re-executing the program
+func RunSandboxHelper() {
+	if len(os.Args) != 2 || os.Args[0] != SANDBOX_HELPER {
+		return
+	}
+
+	fd, err := strconv.Atoi(os.Args[1])
+	if err != nil || fd < 3 {
+		fmt.Fprintf(os.Stderr, "Invalid sandbox descriptor: %s\n", os.Args[1])
+		os.Exit(EXIT_NAMESPACE)
+	}
+	runSandboxHelper(fd)
+}
+
+// runSandboxHelper receives the configuration of the sandbox over descriptor fd, sets up
+// the namespaces of the process as specified and executes the command. On failure
+// the reason is written to fd and the process exits with EXIT_NAMESPACE
+func runSandboxHelper(fd int) {
+	// Privileges are changed per thread, the command has to be executed by the same thread
+	runtime.LockOSThread()
+
+	syscall.CloseOnExec(fd)
+	conn := os.NewFile(uintptr(fd), "sandbox")
+
+	var cfg sandboxConfig
+	b, err := ioutil.ReadAll(conn)
+	if err == nil {
+		err = json.Unmarshal(b, &cfg)
+	}
+	if err == nil {
+		err = cfg.setup()
+	}
+	if err == nil {
+		env := os.Environ()
+		if cfg.WatchdogPID {
+			env = setEnv(env, "WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+		}
+		err = syscall.Exec(cfg.Path, cfg.Args, env)
+		err = fmt.Errorf("Failed to execute %s: %s", cfg.Path, err)
+	}
+
+	conn.WriteString(err.Error())
+	os.Exit(EXIT_NAMESPACE)
+}
+
+// setup sets up the resource limits, mount namespace, network, credentials and privileges of the process
+func (cfg *sandboxConfig) setup() (err error) {
+	// Settings are applied while the process is privileged and /proc is accessible
+	if res := cfg.Resources; res != nil {
+		if err = res.apply(); err != nil {
+			return
+		}
+	}
+
+	// Mounts must not propagate to the namespace of the manager
+	if len(cfg.Mounts) > 0 {
+		if err = syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_SLAVE, ""); err != nil {
+			return fmt.Errorf("Failed to make mounts private: %s", err)
+		}
+	}
+
+	// Sources are opened beforehand, so that they can not be hidden by the mounts
+	sources := make([]*os.File, len(cfg.Mounts))
+	for i, m := range cfg.Mounts {
+		if m.Kind != mountBind {
+			continue
+		}
+		if sources[i], err = openPath(m.Source); err != nil && !(m.Optional && os.IsNotExist(err)) {
+			return
+		}
+	}
+
+	var inaccessible *os.File
+	if cfg.Inaccessible != "" {
+		if inaccessible, err = openPath(cfg.Inaccessible); err != nil {
+			return
+		}
+	}
+
+	for i, m := range cfg.Mounts {
+		if err = m.apply(sources[i], inaccessible); err != nil {
+			return fmt.Errorf("%s %s: %s", m.Kind, m.Target, err)
+		}
+	}
+
+	if cfg.PrivateNetwork {
+		if err = loopbackUp(); err != nil {
+			return fmt.Errorf("Failed to set up loopback interface: %s", err)
+		}
+	}
+
+	if cfg.Root != "" {
+		if err = syscall.Chroot(cfg.Root); err != nil {
+			return fmt.Errorf("Failed to change root directory to %s: %s", cfg.Root, err)
+		}
+		if cfg.Dir == "" {
+			cfg.Dir = "/"
+		}
+	}
+	if cfg.Dir != "" {
+		if err = syscall.Chdir(cfg.Dir); err != nil {
+			return fmt.Errorf("Failed to change working directory to %s: %s", cfg.Dir, err)
+		}
+	}
+
+	if p := cfg.Privileges; p != nil {
+		if err = p.prepare(cfg.Credential != nil && cfg.Credential.Uid != 0); err != nil {
+			return fmt.Errorf("Failed to change privileges: %s", err)
+		}
+	}
+
+	if c := cfg.Credential; c != nil {
+		if err = syscall.Setgroups(intIDs(c.Groups)); err == nil {
+			if err = syscall.Setgid(int(c.Gid)); err == nil {
+				err = syscall.Setuid(int(c.Uid))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to change credentials: %s", err)
+		}
+	}
+
+	if p := cfg.Privileges; p != nil {
+		if err = p.finish(); err != nil {
+			return fmt.Errorf("Failed to change privileges: %s", err)
+		}
+	}
+	return nil
+}
+
+// apply performs the operation. source is the opened source of bind mounts, nil if
+// it does not exist, inaccessible is the file mounted over inaccessible files
+func (m sandboxMount) apply(source, inaccessible *os.File) (err error) {
+	var st os.FileInfo
+	if m.Kind != mountSymlink {
+		if st, err = os.Stat(m.Target); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+
+	switch m.Kind {
+	case mountBind:
+		if source == nil {
+			// Optional source, which does not exist
+			return nil
+		}
+		defer source.Close()
+
+		if st == nil {
+			var src os.FileInfo
+			if src, err = source.Stat(); err != nil {
+				return
+			}
+			if err = createMountPoint(m.Target, src.IsDir()); err != nil {
+				return
+			}
+		}
+
+		flags := uintptr(syscall.MS_BIND)
+		if m.Recursive {
+			flags |= syscall.MS_REC
+		}
+		return syscall.Mount(fdPath(source), m.Target, "", flags, "")
+
+	case mountTmpfs:
+		if st == nil {
+			if err = os.MkdirAll(m.Target, 0755); err != nil {
+				return
+			}
+		}
+		return syscall.Mount("tmpfs", m.Target, "tmpfs", m.Flags, m.Data)
+
+	case mountSymlink:
+		return os.Symlink(m.Source, m.Target)
+
+	case mountReadOnly, mountReadWrite:
+		if st == nil {
+			if m.Optional {
+				return nil
+			}
+			return os.ErrNotExist
+		}
+
+		if !isMountPoint(m.Target) {
+			if err = syscall.Mount(m.Target, m.Target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+				return
+			}
+		}
+		return remount(m.Target, m.Kind == mountReadOnly, m.Exclude)
+
+	case mountInaccessible:
+		switch {
+		case st == nil && m.Optional:
+			return nil
+		case st == nil:
+			return os.ErrNotExist
+		case st.IsDir():
+			return syscall.Mount("tmpfs", m.Target, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=000")
+		default:
+			if err = syscall.Mount(fdPath(inaccessible), m.Target, "", syscall.MS_BIND, ""); err != nil {
+				return
+			}
+			return remount(m.Target, true, nil)
+		}
+
+	default:
+		return fmt.Errorf("Unknown operation")
+	}
+}
+
+// openPath opens path for use as a mount source
+func openPath(path string) (*os.File, error) {
+	fd, err := syscall.Open(path, O_PATH|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// fdPath returns the path f can be referred to by, even if its original path is hidden
+func fdPath(f *os.File) string {
+	return "/proc/self/fd/" + strconv.Itoa(int(f.Fd()))
+}
+
+// createMountPoint creates a directory or an empty file at path to mount on
+func createMountPoint(path string, dir bool) error {
+	if dir {
+		return os.MkdirAll(path, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// remount changes the mount at path and the mounts below it to be read-only or writable.
+// Mounts at or below the paths in exclude are left as they are
+func remount(path string, readOnly bool, exclude []string) error {
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+
+	for _, mp := range mounts {
+		if !isBelow(mp, path) {
+			continue
+		}
+
+		excluded := false
+		for _, ex := range exclude {
+			if ex != path && isBelow(mp, ex) {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(mp, &st); err != nil {
+			if mp == path {
+				return err
+			}
+			// Submount, which can not be accessed
+			continue
+		}
+
+		flags := uintptr(st.Flags) & remountFlags
+		if readOnly {
+			flags |= syscall.MS_RDONLY
+		}
+		if err := syscall.Mount("", mp, "", syscall.MS_BIND|syscall.MS_REMOUNT|flags, ""); err != nil && mp == path {
+			return err
+		}
+	}
+	return nil
+}
+
+// isBelow reports whether path is p or is located below p
+func isBelow(path, p string) bool {
+	return path == p || p == "/" || strings.HasPrefix(path, p+"/")
+}
+
+func isMountPoint(path string) bool {
+	mounts, err := mountPoints()
+	if err != nil {
+		return false
+	}
+	for _, mp := range mounts {
+		if mp == path {
+			return true
+		}
+	}
+	return false
+}
+
+// mountPoints returns the mount points of the mount namespace of the process
+func mountPoints() (mounts []string, err error) {
+	var f *os.File
+	if f, err = os.Open("/proc/self/mountinfo"); err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 4 {
+			mounts = append(mounts, unescapeMountPoint(fields[4]))
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPoint resolves octal escape sequences of a path found in mountinfo(see proc(5))
+func unescapeMountPoint(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b = append(b, byte(n))
+				i += 3
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// loopbackUp brings up the loopback interface of the network namespace of the process
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq: interface name followed by flags
+	var ifr [40]byte
+	copy(ifr[:], "lo")
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	*(*uint16)(unsafe.Pointer(&ifr[syscall.IFNAMSIZ])) |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func intIDs(ids []uint32) []int {
+	out := make([]int, len(ids))
+	for i, id := range ids {
+		out[i] = int(id)
+	}
+	return out
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// startNotify starts the main process using cmd and waits until
+// it either signals readiness over the notification socket or exits
+func (sv *Unit) startNotify(cmd *exec.Cmd) (err error) {
+	if sv.NotifySocket == "" {
+		return ErrNoNotifySocket
+	}
+
+	sv.mutex.Lock()
+	sv.ready = false
+	sv.notified = ""
+	sv.readych = make(chan struct{})
+	readych := sv.readych
+	sv.mutex.Unlock()
+
+	if err = sv.startMain(cmd); err != nil {
+		return
+	}
+	sv.setMainPID(cmd.Process.Pid)
+
+	exitch := make(chan struct{})
+	go sv.supervise(cmd, exitch)
+
+	select {
+	case <-readych:
+		return nil
+	case <-exitch:
+		// Readiness could have been signaled right before the process exited
+		select {
+		case <-readych:
+			return nil
+		default:
+			return ErrNotReady
+		}
+	}
+}
+
+// notifySub returns the sub state of a running notify or dbus service
+func (sv *Unit) notifySub() string {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	switch {
+	case !sv.ready:
+		return start
+	case sv.notified != "":
+		return sv.notified
+	default:
+		return running
+	}
+}
+
+// mainPIDChanged reports whether the main PID was changed by a notification and
+// the new main process is alive
+func (sv *Unit) mainPIDChanged() bool {
+	sv.mutex.Lock()
+	pid, cmd := sv.mainPID, sv.main
+	sv.mutex.Unlock()
+
+	return cmd != nil && pid != cmd.Process.Pid && isAlive(pid)
+}
+
+// Owns reports whether the process with pid specified belongs to the service
+func (sv *Unit) Owns(pid int) bool {
+	sv.mutex.Lock()
+	main, control, cmd := sv.mainPID, sv.controlPID, sv.main
+	sv.mutex.Unlock()
+
+	if pid == main || pid == control && control > 0 {
+		return true
+	}
+
+	if cmd == nil {
+		return false
+	}
+
+	if pid == cmd.Process.Pid {
+		return true
+	}
+
+	// Main process leads the process group of the service
+	st, err := unit.ReadProcStat(pid)
+	return err == nil && st.PGID == cmd.Process.Pid
+}
+
+// tracks reports whether the process with pid specified is a member of the process group of
+// the service or a descendant of its main or control processes. sv.mutex must be held by the caller
+func (sv *Unit) tracks(pid int) bool {
+	roots := map[int]bool{}
+	for _, root := range []int{sv.mainPID, sv.controlPID} {
+		if root > 0 {
+			roots[root] = true
+		}
+	}
+
+	leader := 0
+	if sv.main != nil {
+		leader = sv.main.Process.Pid
+		roots[leader] = true
+	}
+
+	for pid > 1 {
+		if roots[pid] {
+			return true
+		}
+
+		st, err := unit.ReadProcStat(pid)
+		if err != nil || st.Zombie() {
+			return false
+		}
+		if leader > 0 && st.PGID == leader {
+			return true
+		}
+		pid = st.PPID
+	}
+	return false
+}
+
+// notifyAllowed reports whether the process with pid specified is allowed to send
+// notifications according to NotifyAccess
+func (sv *Unit) notifyAllowed(pid int) bool {
+	sv.mutex.Lock()
+	main, control, cmd := sv.mainPID, sv.controlPID, sv.main
+	sv.mutex.Unlock()
+
+	switch sv.Definition.Service.NotifyAccess {
+	case "main":
+		return pid == main
+	case "exec":
+		return pid == main || pid == control && control > 0 ||
+			cmd != nil && pid == cmd.Process.Pid
+	case "all":
+		return sv.Owns(pid)
+	default:
+		return false
+	}
+}
+
+// Notify processes the newline-separated list of variable assignments sent by the
+// process with pid specified over the notification socket(see sd_notify(3))
+func (sv *Unit) Notify(pid int, msg string) (err error) {
+	e := log.WithFields(log.Fields{
+		"pid": pid,
+		"msg": msg,
+	})
+	e.Debug("sv.Notify")
+
+	if !sv.notifyAllowed(pid) {
+		return ErrNotifyAccess
+	}
+
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	for _, line := range strings.Split(msg, "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch key, value := kv[0], kv[1]; key {
+		case "READY":
+			if value != "1" {
+				continue
+			}
+			sv.notified = ""
+			if sv.reloadch != nil {
+				// Reload requested by the manager is complete
+				close(sv.reloadch)
+				sv.reloadch = nil
+			}
+			if !sv.ready {
+				sv.ready = true
+				if sv.readych != nil {
+					close(sv.readych)
+				}
+			}
+
+		case "RELOADING":
+			if value == "1" {
+				sv.notified = reload
+			}
+
+		case "STOPPING":
+			if value == "1" {
+				sv.notified = stop
+			}
+
+		case "STATUS":
+			sv.statusText = value
+
+		case "ERRNO":
+			if sv.errno, err = strconv.Atoi(value); err != nil {
+				e.Warnf("Invalid ERRNO: %s", value)
+			}
+
+		case "WATCHDOG":
+			switch value {
+			case "1":
+				sv.pingWatchdog()
+			case "trigger":
+				sv.triggerWatchdog()
+			}
+
+		case "WATCHDOG_USEC":
+			var usec int64
+			if usec, err = strconv.ParseInt(value, 10, 64); err != nil || usec <= 0 {
+				e.Warnf("Invalid WATCHDOG_USEC: %s", value)
+				continue
+			}
+			sv.watchdogOverride = time.Duration(usec) * time.Microsecond
+			sv.pingWatchdog()
+
+		case "MAINPID":
+			var main int
+			if main, err = strconv.Atoi(value); err != nil || main <= 0 {
+				e.Warnf("Invalid MAINPID: %s", value)
+				continue
+			}
+			if !sv.tracks(main) {
+				// Processes outside of the service must not be supervised and stopped as its main process
+				e.Warnf("MAINPID %d does not belong to the service, ignoring", main)
+				continue
+			}
+			sv.mainPID = main
+			sv.mainStart = startTime(main)
+		}
+	}
+	return nil
+}
+
+// StatusText returns the status text sent by the service using STATUS= and ERRNO=
+func (sv *Unit) StatusText() (text string) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	text = sv.statusText
+	if sv.errno != 0 {
+		if text != "" {
+			text += " "
+		}
+		text += "(errno " + strconv.Itoa(sv.errno) + ": " + syscall.Errno(sv.errno).Error() + ")"
+	}
+	return
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+const DEFAULT_STANDARD_OUTPUT = "journal"
+
+// Syslog priority of lines logged without a priority prefix(see syslog(3))
+const DEFAULT_PRIORITY = 6
+
+// Syslog priority of the errors the manager encounters running the service
+const ERROR_PRIORITY = 3
+
+// Types of output connections accepted by StandardOutput and StandardError.
+// "socket" is not supported, since socket activation is not implemented
+var outputTypes = map[string]bool{
+	"journal":  true,
+	"null":     true,
+	"inherit":  true,
+	"file":     true,
+	"append":   true,
+	"truncate": true,
+	"tty":      true,
+}
+
+// parseOutput parses a value of StandardOutput or StandardError
+func parseOutput(s string) (typ, path string, err error) {
+	typ = s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		typ, path = s[:i], s[i+1:]
+	}
+
+	switch {
+	case !outputTypes[typ]:
+		return "", "", unit.ErrNotSupported
+	case typ == "file" || typ == "append" || typ == "truncate":
+		if !filepath.IsAbs(path) {
+			return "", "", unit.ErrPathNotAbs
+		}
+	case path != "":
+		return "", "", unit.ErrWrongVal
+	}
+	return
+}
+
+// SetOutputLog sets the function lines written by the processes of the service
+// to standard output or error connected to the journal are passed to
+func (sv *Unit) SetOutputLog(fn func(priority int, identifier, line string)) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.outputLog = fn
+}
+
+// logError logs an error the manager encountered running the service to the log of the
+// manager and, if set, to the log of the unit, the output of the service is logged to
+func (sv *Unit) logError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.WithField("ExecStart", sv.Definition.Service.ExecStart).Error(msg)
+
+	sv.mutex.Lock()
+	outputLog := sv.outputLog
+	sv.mutex.Unlock()
+
+	if outputLog != nil {
+		outputLog(ERROR_PRIORITY, sv.syslogIdentifier(sv.Cmd), msg)
+	}
+}
+
+// connectStdio connects standard input, output and error of cmd as specified in definition
+// and returns a function, which must be called once cmd is started
+func (sv *Unit) connectStdio(cmd *exec.Cmd) (started func(), err error) {
+	if sv.Definition.Service.StandardOutput == "" {
+		// Definition not parsed, leave cmd as is
+		return func() {}, nil
+	}
+
+	var tty *os.File
+	var files []*os.File
+	if tty, files, err = sv.connectInput(cmd); err != nil {
+		return nil, err
+	}
+	started = func() { closeFiles(files) }
+
+	var stdout, stderr *os.File
+	var owned bool
+	if stdout, owned, err = sv.openOutput(sv.Definition.Service.StandardOutput, os.Stdout, tty, cmd); err != nil {
+		closeFiles(files)
+		return nil, err
+	}
+	if owned {
+		files = append(files, stdout)
+	}
+
+	switch sv.Definition.Service.StandardError {
+	case "", "inherit":
+		// Same as standard output
+		stderr = stdout
+	default:
+		if stderr, owned, err = sv.openOutput(sv.Definition.Service.StandardError, os.Stderr, tty, cmd); err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		if owned {
+			files = append(files, stderr)
+		}
+	}
+
+	// nil *os.File must not be assigned to io.Writer
+	cmd.Stdout, cmd.Stderr = nil, nil
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	if stderr != nil {
+		cmd.Stderr = stderr
+	}
+	return
+}
+
+// openOutput opens the output specified by s for cmd and reports whether the file
+// returned is owned by the service and has to be closed once cmd is started.
+// nil file means /dev/null, inherit is the output of the manager. tty is the terminal
+// standard input is connected to, which is inherited instead, nil if none
+func (sv *Unit) openOutput(s string, inherit, tty *os.File, cmd *exec.Cmd) (f *os.File, owned bool, err error) {
+	if tty != nil {
+		inherit = tty
+	}
+
+	var typ, path string
+	if typ, path, err = parseOutput(s); err != nil {
+		return
+	}
+
+	sv.mutex.Lock()
+	outputLog := sv.outputLog
+	sv.mutex.Unlock()
+
+	switch {
+	case typ == "null":
+		return nil, false, nil
+
+	case typ == "inherit", typ == "journal" && outputLog == nil:
+		// Not attached to any log - use the output of the manager
+		return inherit, false, nil
+
+	case typ == "journal":
+		var r *os.File
+		if r, f, err = os.Pipe(); err != nil {
+			return
+		}
+		go logLines(r, outputLog, sv.syslogIdentifier(cmd), sv.Definition.Service.SyslogLevelPrefix)
+		return f, true, nil
+
+	case typ == "tty" && tty != nil:
+		// Terminal opened for standard input
+		return tty, false, nil
+
+	case typ == "tty":
+		f, err = os.OpenFile(sv.ttyPath(), os.O_WRONLY|syscall.O_NOCTTY, 0)
+
+	case typ == "file":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	case typ == "append":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	case typ == "truncate":
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	default:
+		panic("Unknown output type")
+	}
+	return f, err == nil, err
+}
+
+// syslogIdentifier returns the identifier the output of cmd is tagged with
+func (sv *Unit) syslogIdentifier(cmd *exec.Cmd) string {
+	if id := sv.Definition.Service.SyslogIdentifier; id != "" {
+		return id
+	}
+	return filepath.Base(cmd.Path)
+}
+
+// logLines passes each line read from r to fn until EOF.
+// If prefix is true, syslog priority prefixes("<N>") are parsed and stripped
+func logLines(r io.ReadCloser, fn func(priority int, identifier, line string), identifier string, prefix bool) {
+	defer r.Close()
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			priority := DEFAULT_PRIORITY
+			if prefix {
+				priority, line = parsePriority(line)
+			}
+			fn(priority, identifier, line)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parsePriority parses the syslog priority prefix of line, if any
+func parsePriority(line string) (priority int, rest string) {
+	if len(line) >= 3 && line[0] == '<' && line[2] == '>' {
+		if n, err := strconv.Atoi(line[1:2]); err == nil && n >= 0 && n <= 7 {
+			return n, line[3:]
+		}
+	}
+	return DEFAULT_PRIORITY, line
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
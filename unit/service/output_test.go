@@ -0,0 +1,152 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOutput(t *testing.T) {
+	for s, expected := range map[string][2]string{
+		"journal":          {"journal", ""},
+		"null":             {"null", ""},
+		"inherit":          {"inherit", ""},
+		"file:/tmp/out":    {"file", "/tmp/out"},
+		"append:/tmp/out":  {"append", "/tmp/out"},
+		"truncate:/tmp/ou": {"truncate", "/tmp/ou"},
+		"tty":              {"tty", ""},
+	} {
+		typ, path, err := parseOutput(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, [2]string{typ, path}, s)
+		}
+	}
+
+	for s, expected := range map[string]error{
+		"kmsg":         unit.ErrNotSupported,
+		"socket":       unit.ErrNotSupported,
+		"file:out":     unit.ErrPathNotAbs,
+		"journal:/tmp": unit.ErrWrongVal,
+	} {
+		_, _, err := parseOutput(s)
+		assert.Equal(t, expected, err, s)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for line, expected := range map[string]struct {
+		priority int
+		rest     string
+	}{
+		"<3>error":   {3, "error"},
+		"<7>":        {7, ""},
+		"<8>message": {DEFAULT_PRIORITY, "<8>message"},
+		"message":    {DEFAULT_PRIORITY, "message"},
+	} {
+		priority, rest := parsePriority(line)
+		assert.Equal(t, expected.priority, priority, line)
+		assert.Equal(t, expected.rest, rest, line)
+	}
+}
+
+type outputLine struct {
+	priority   int
+	identifier string
+	line       string
+}
+
+type testOutputLog struct {
+	lines []outputLine
+	mutex sync.Mutex
+}
+
+func (l *testOutputLog) log(priority int, identifier, line string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.lines = append(l.lines, outputLine{priority, identifier, line})
+}
+
+func (l *testOutputLog) Lines() []outputLine {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]outputLine{}, l.lines...)
+}
+
+func TestOutputJournal(t *testing.T) {
+	l := &testOutputLog{}
+
+	sv := &Unit{}
+	sv.SetOutputLog(l.log)
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStartPre=/bin/echo pre
+ExecStart=/bin/sh -c "echo out; echo '<3>err' >&2"`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	time.Sleep(100 * time.Millisecond)
+	lines := l.Lines()
+	// Output of different commands is read concurrently
+	assert.Len(t, lines, 3)
+	for _, line := range []outputLine{
+		{DEFAULT_PRIORITY, "echo", "pre"},
+		{DEFAULT_PRIORITY, "sh", "out"},
+		{3, "sh", "err"},
+	} {
+		assert.Contains(t, lines, line)
+	}
+
+	l = &testOutputLog{}
+
+	sv = &Unit{}
+	sv.SetOutputLog(l.log)
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c "echo '<3>out'; echo err >&2"
+StandardError=null
+SyslogIdentifier=test
+SyslogLevelPrefix=no`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []outputLine{
+		{DEFAULT_PRIORITY, "test", "<3>out"},
+	}, l.Lines())
+}
+
+func TestOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-output")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	require.NoError(t, ioutil.WriteFile(out, []byte("old contents\n"), 0644))
+
+	run := func(output string) string {
+		sv := Unit{}
+		require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/echo new
+StandardOutput=`+output+out)), "sv.Define")
+		require.NoError(t, sv.Start(), "sv.Start")
+
+		b, err := ioutil.ReadFile(out)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	assert.Equal(t, "new\ncontents\n", run("file:"))
+	assert.Equal(t, "new\ncontents\nnew\n", run("append:"))
+	assert.Equal(t, "new\n", run("truncate:"))
+}
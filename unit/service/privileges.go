@@ -0,0 +1,296 @@
+package service
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Capabilities in the order of their numbers (see capabilities(7))
+var capabilities = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid",
+	"cap_setpcap", "cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// Set of all capabilities known
+var allCapabilities = uint64(1)<<uint(len(capabilities)) - 1
+
+const (
+	CAP_SYS_RAWIO = 17
+	CAP_SYS_ADMIN = 21
+	CAP_MKNOD     = 27
+)
+
+// Secure bits settable with SecureBits= (see capabilities(7))
+const (
+	SECBIT_NOROOT                 = 1 << 0
+	SECBIT_NOROOT_LOCKED          = 1 << 1
+	SECBIT_NO_SETUID_FIXUP        = 1 << 2
+	SECBIT_NO_SETUID_FIXUP_LOCKED = 1 << 3
+	SECBIT_KEEP_CAPS              = 1 << 4
+	SECBIT_KEEP_CAPS_LOCKED       = 1 << 5
+)
+
+var secureBits = map[string]int{
+	"noroot":                 SECBIT_NOROOT,
+	"noroot-locked":          SECBIT_NOROOT_LOCKED,
+	"no-setuid-fixup":        SECBIT_NO_SETUID_FIXUP,
+	"no-setuid-fixup-locked": SECBIT_NO_SETUID_FIXUP_LOCKED,
+	"keep-caps":              SECBIT_KEEP_CAPS,
+	"keep-caps-locked":       SECBIT_KEEP_CAPS_LOCKED,
+}
+
+// prctl(2) options, which are not defined by package syscall
+const (
+	PR_SET_NO_NEW_PRIVS      = 38
+	PR_CAP_AMBIENT           = 47
+	PR_CAP_AMBIENT_RAISE     = 2
+	LINUX_CAPABILITY_VERSION = 0x20080522
+)
+
+// privileges holds the capability and privilege settings of the processes of the service
+type privileges struct {
+	// Capabilities kept in the bounding set, nil if the bounding set is not changed
+	BoundingSet *uint64 `json:",omitempty"`
+
+	// Capabilities raised in the ambient set
+	Ambient uint64 `json:",omitempty"`
+
+	SecureBits      int  `json:",omitempty"`
+	NoNewPrivileges bool `json:",omitempty"`
+
+	// Seccomp filter installed right before executing the command
+	Filter []syscall.SockFilter `json:",omitempty"`
+}
+
+// parsePrivileges parses the capability and privilege settings specified in def.
+// nil is returned if none are specified
+func parsePrivileges(def Definition) (p *privileges, merr unit.MultiError) {
+	p = &privileges{}
+	svc := def.Service
+
+	if set, ok := parseCapabilitySets(&merr, "CapabilityBoundingSet", svc.CapabilityBoundingSet); ok {
+		p.BoundingSet = &set
+	}
+	if svc.PrivateDevices {
+		// Device nodes must not be created or accessed directly either
+		set := allCapabilities
+		if p.BoundingSet != nil {
+			set = *p.BoundingSet
+		}
+		set &^= 1<<CAP_SYS_RAWIO | 1<<CAP_MKNOD
+		p.BoundingSet = &set
+	}
+	p.Ambient, _ = parseCapabilitySets(&merr, "AmbientCapabilities", svc.AmbientCapabilities)
+
+	for _, line := range svc.SecureBits {
+		for _, name := range strings.Fields(line) {
+			bit, ok := secureBits[name]
+			if !ok {
+				merr = append(merr, unit.ParseErr("SecureBits", unit.ParseErr(name, unit.ErrNotSupported)))
+			}
+			p.SecureBits |= bit
+		}
+	}
+
+	p.NoNewPrivileges = svc.NoNewPrivileges
+
+	if svc.RestrictSUIDSGID && nativeArch == 0 {
+		merr = append(merr, unit.ParseErr("RestrictSUIDSGID", unit.ErrNotSupported))
+	}
+
+	filter, ferr := parseSyscallFilter(def)
+	merr = append(merr, ferr...)
+	if svc.RestrictSUIDSGID {
+		filter.rules = append(append([]seccompRule{}, restrictSUIDSGIDRules...), filter.rules...)
+		// The rules would be bypassed by calls of other architectures
+		if filter.foreignAction == SECCOMP_RET_ALLOW {
+			filter.foreignAction = SECCOMP_RET_ERRNO | uint32(syscall.EPERM)
+		}
+	}
+	if len(filter.rules) > 0 || filter.defaultAction != SECCOMP_RET_ALLOW || filter.foreignAction != SECCOMP_RET_ALLOW {
+		p.Filter = compileFilter(filter.rules, filter.defaultAction, filter.foreignAction)
+	}
+
+	if p.BoundingSet == nil && p.Ambient == 0 && p.SecureBits == 0 && !p.NoNewPrivileges && p.Filter == nil {
+		return nil, merr
+	}
+	return p, merr
+}
+
+// parseCapabilitySets combines the capability sets specified in lines of option name.
+// A set prefixed by "~" is removed from the result, which then starts from all capabilities.
+// ok is false if no sets are specified
+func parseCapabilitySets(merr *unit.MultiError, name string, lines unit.Lines) (set uint64, ok bool) {
+	for _, line := range lines {
+		caps, invert, err := parseCapabilities(line)
+		if err != nil {
+			*merr = append(*merr, unit.ParseErr(name, err))
+			continue
+		}
+
+		switch {
+		case invert && !ok:
+			set = allCapabilities &^ caps
+		case invert:
+			set &^= caps
+		default:
+			set |= caps
+		}
+		ok = true
+	}
+	return
+}
+
+// parseCapabilities parses a space-separated list of capability names, optionally prefixed by "~"
+func parseCapabilities(s string) (set uint64, invert bool, err error) {
+	if strings.HasPrefix(s, "~") {
+		s, invert = s[1:], true
+	}
+
+	for _, name := range strings.Fields(s) {
+		n, ok := capabilityNumber(name)
+		if !ok {
+			return 0, invert, unit.ParseErr(name, ErrUnknownCapability)
+		}
+		set |= 1 << uint(n)
+	}
+	return
+}
+
+// capabilityNumber returns the number of capability specified by case-insensitive name
+func capabilityNumber(name string) (int, bool) {
+	name = strings.ToLower(name)
+	for n, c := range capabilities {
+		if c == name {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// prepare applies the settings, which need to be applied before the credentials are changed,
+// to the calling thread. changeUser specifies whether the user is changed from root
+func (p *privileges) prepare(changeUser bool) error {
+	if p.BoundingSet != nil {
+		for n := 0; n <= lastCapability(); n++ {
+			if *p.BoundingSet&(1<<uint(n)) != 0 {
+				continue
+			}
+			if err := prctl(syscall.PR_CAPBSET_DROP, uintptr(n), 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Ambient capabilities are raised from the permitted set, which has to survive the change of user
+	keepCaps := p.Ambient != 0 && changeUser && p.SecureBits&SECBIT_KEEP_CAPS_LOCKED == 0
+
+	switch {
+	case p.SecureBits != 0 && keepCaps:
+		return prctl(syscall.PR_SET_SECUREBITS, uintptr(p.SecureBits|SECBIT_KEEP_CAPS), 0)
+	case p.SecureBits != 0:
+		return prctl(syscall.PR_SET_SECUREBITS, uintptr(p.SecureBits), 0)
+	case keepCaps:
+		return prctl(syscall.PR_SET_KEEPCAPS, 1, 0)
+	}
+	return nil
+}
+
+// finish applies the remaining settings to the calling thread after the credentials are changed
+func (p *privileges) finish() error {
+	if p.Ambient != 0 {
+		hdr, data := capHeader{version: LINUX_CAPABILITY_VERSION}, [2]capData{}
+		if err := capget(&hdr, &data); err != nil {
+			return err
+		}
+		data[0].inheritable |= uint32(p.Ambient)
+		data[1].inheritable |= uint32(p.Ambient >> 32)
+		if err := capset(&hdr, &data); err != nil {
+			return err
+		}
+
+		for n := range capabilities {
+			if p.Ambient&(1<<uint(n)) == 0 {
+				continue
+			}
+			if err := prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_RAISE, uintptr(n)); err != nil {
+				return err
+			}
+		}
+	}
+
+	noNewPrivs := p.NoNewPrivileges
+	if len(p.Filter) > 0 && !noNewPrivs {
+		// Installing a filter without CAP_SYS_ADMIN requires no_new_privs
+		hdr, data := capHeader{version: LINUX_CAPABILITY_VERSION}, [2]capData{}
+		if err := capget(&hdr, &data); err != nil {
+			return err
+		}
+		noNewPrivs = data[0].effective&(1<<CAP_SYS_ADMIN) == 0
+	}
+	if noNewPrivs {
+		if err := prctl(PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+			return err
+		}
+	}
+
+	if len(p.Filter) > 0 {
+		return installFilter(p.Filter)
+	}
+	return nil
+}
+
+// lastCapability returns the number of the last capability supported by the kernel
+func lastCapability() int {
+	if b, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+			return n
+		}
+	}
+	return len(capabilities) - 1
+}
+
+func prctl(option, arg2, arg3 uintptr) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, option, arg2, arg3); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Arguments of capget(2) and capset(2)
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective, permitted, inheritable uint32
+}
+
+func capget(hdr *capHeader, data *[2]capData) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(data)), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func capset(hdr *capHeader, data *[2]capData) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(data)), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
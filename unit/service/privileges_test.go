@@ -0,0 +1,110 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCapabilitySets(t *testing.T) {
+	for _, test := range []struct {
+		lines    unit.Lines
+		expected uint64
+		ok       bool
+	}{
+		{nil, 0, false},
+		{unit.Lines{"CAP_CHOWN cap_kill"}, 1<<0 | 1<<5, true},
+		{unit.Lines{"CAP_CHOWN", "CAP_KILL"}, 1<<0 | 1<<5, true},
+		{unit.Lines{"~CAP_SYS_ADMIN"}, allCapabilities &^ (1 << CAP_SYS_ADMIN), true},
+		{unit.Lines{"CAP_CHOWN CAP_KILL", "~CAP_CHOWN"}, 1 << 5, true},
+		{unit.Lines{"~"}, allCapabilities, true},
+	} {
+		var merr unit.MultiError
+		set, ok := parseCapabilitySets(&merr, "CapabilityBoundingSet", test.lines)
+		assert.Empty(t, merr, "%v", test.lines)
+		assert.Equal(t, test.ok, ok, "%v", test.lines)
+		assert.Equal(t, test.expected, set, "%v", test.lines)
+	}
+}
+
+func TestDefinePrivileges(t *testing.T) {
+	for option, value := range map[string]string{
+		"CapabilityBoundingSet": "CAP_CHOWN CAP_NONEXISTENT",
+		"AmbientCapabilities":   "~cap_typo",
+		"SecureBits":            "noroot keep",
+	} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true")))
+	assert.Nil(t, sv.privileges)
+
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+CapabilityBoundingSet=CAP_NET_BIND_SERVICE
+SecureBits=noroot noroot-locked
+NoNewPrivileges=yes`)))
+	if assert.NotNil(t, sv.privileges) && assert.NotNil(t, sv.privileges.BoundingSet) {
+		assert.Equal(t, uint64(1<<10), *sv.privileges.BoundingSet)
+		assert.Equal(t, SECBIT_NOROOT|SECBIT_NOROOT_LOCKED, sv.privileges.SecureBits)
+		assert.True(t, sv.privileges.NoNewPrivileges)
+	}
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\nPrivateDevices=yes")))
+	if assert.NotNil(t, sv.privileges) && assert.NotNil(t, sv.privileges.BoundingSet) {
+		assert.Equal(t, allCapabilities&^(1<<CAP_SYS_RAWIO|1<<CAP_MKNOD), *sv.privileges.BoundingSet)
+	}
+}
+
+func TestPrivileges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Must be run as root")
+	}
+
+	for _, test := range []struct {
+		name, options, script, expected string
+	}{
+		{
+			"CapabilityBoundingSet",
+			"CapabilityBoundingSet=CAP_NET_BIND_SERVICE",
+			"grep CapBnd /proc/self/status",
+			"CapBnd:\t0000000000000400\n",
+		},
+		{
+			"AmbientCapabilities",
+			"User=nobody\nAmbientCapabilities=CAP_NET_BIND_SERVICE",
+			"grep -E \"^Cap(Eff|Amb)\" /proc/self/status",
+			"CapEff:\t0000000000000400\nCapAmb:\t0000000000000400\n",
+		},
+		{
+			"NoNewPrivileges",
+			"NoNewPrivileges=yes",
+			"grep NoNewPrivs /proc/self/status",
+			"NoNewPrivs:\t1\n",
+		},
+		{
+			"RestrictSUIDSGID",
+			"RestrictSUIDSGID=yes",
+			"f=$(mktemp) && chmod 0755 $f && echo plain; chmod 4755 $f 2>/dev/null || echo denied; rm $f",
+			"plain\ndenied\n",
+		},
+	} {
+		out, err := runSandboxed(t, test.options, test.script)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, out, test.name)
+		}
+	}
+}
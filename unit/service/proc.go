@@ -0,0 +1,184 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// isAlive reports whether process with pid specified exists and is not a zombie
+func isAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return false
+	}
+
+	st, err := unit.ReadProcStat(pid)
+	switch {
+	case err == nil:
+		return !st.Zombie()
+	case os.IsNotExist(err):
+		// If procfs is not available, rely on kill(2)
+		return !procfsMounted()
+	default:
+		return true
+	}
+}
+
+// startTime returns the start time of the process with pid specified, 0 if unknown
+func startTime(pid int) uint64 {
+	if pid <= 0 {
+		return 0
+	}
+	st, err := unit.ReadProcStat(pid)
+	if err != nil {
+		return 0
+	}
+	return st.Start
+}
+
+func procfsMounted() bool {
+	_, err := os.Stat("/proc/self/stat")
+	return err == nil
+}
+
+// readPIDFile reads the PID stored in file at path
+func readPIDFile(path string) (pid int, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(path); err != nil {
+		return
+	}
+
+	if pid, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+		return 0, err
+	}
+
+	if pid <= 0 {
+		return 0, ErrNoMainPID
+	}
+	return
+}
+
+// guessMainPID returns the PID of the only living process in process group pgid.
+// ErrNoMainPID is returned if there is none, or there is more than one
+func guessMainPID(pgid int) (pid int, err error) {
+	var names []string
+	if names, err = readDirNames("/proc"); err != nil {
+		return
+	}
+
+	for _, name := range names {
+		candidate, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		st, err := unit.ReadProcStat(candidate)
+		if err != nil || st.PGID != pgid || st.Zombie() {
+			continue
+		}
+
+		if pid != 0 {
+			// More than one process - can not guess
+			return 0, ErrNoMainPID
+		}
+		pid = candidate
+	}
+
+	if pid == 0 {
+		return 0, ErrNoMainPID
+	}
+	return
+}
+
+func readDirNames(path string) (names []string, err error) {
+	var dir *os.File
+	if dir, err = os.Open(path); err != nil {
+		return
+	}
+	defer dir.Close()
+
+	return dir.Readdirnames(0)
+}
+
+// Interval between checks of whether a process, which is not a child of the manager, is alive,
+// if its exit can not be waited for using a pidfd
+const POLL_INTERVAL = 100 * time.Millisecond
+
+// pidfd_open(2) system call number, which is the same on all architectures
+const SYS_PIDFD_OPEN = 434
+
+// waitPID blocks until process with pid specified exits
+func waitPID(pid int) {
+	if fd, err := pidfdOpen(pid); err == nil {
+		defer syscall.Close(fd)
+		if waitPidfd(fd) == nil {
+			return
+		}
+	}
+
+	// Kernel does not support pidfds
+	for isAlive(pid) {
+		time.Sleep(POLL_INTERVAL)
+	}
+}
+
+// pidfdOpen returns a file descriptor referring to the process with pid specified(see pidfd_open(2))
+func pidfdOpen(pid int) (int, error) {
+	fd, _, errno := syscall.Syscall(SYS_PIDFD_OPEN, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	syscall.CloseOnExec(int(fd))
+	return int(fd), nil
+}
+
+// waitPidfd blocks until the process referred to by pidfd fd exits, which makes fd readable
+func waitPidfd(fd int) (err error) {
+	var epfd int
+	if epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err != nil {
+		return
+	}
+	defer syscall.Close(epfd)
+
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}); err != nil {
+		return
+	}
+
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		n, err := syscall.EpollWait(epfd, events, -1)
+		switch {
+		case err == syscall.EINTR:
+		case err != nil:
+			return err
+		case n > 0:
+			return nil
+		}
+	}
+}
+
+// spawn starts cmd using sv.Supervisor, if set
+func (sv *Unit) spawn(cmd *exec.Cmd) error {
+	if sv.Supervisor == nil {
+		return cmd.Start()
+	}
+	return sv.Supervisor.Spawn(cmd)
+}
+
+// wait waits for cmd started by spawn to exit
+func (sv *Unit) wait(cmd *exec.Cmd) error {
+	if sv.Supervisor == nil {
+		return cmd.Wait()
+	}
+	return sv.Supervisor.Wait(cmd)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Signal sent to the main process of notify-reload services to request a reload
+const DEFAULT_RELOAD_SIGNAL = "SIGHUP"
+
+// CanReload reports whether the service can be reloaded, i.e. whether ExecReload
+// commands are specified or the service is of type notify-reload
+func (sv *Unit) CanReload() bool {
+	return len(sv.commands["ExecReload"]) > 0 || sv.Definition.Service.Type == "notify-reload"
+}
+
+// Reload runs ExecReload commands in the reload phase. The main process of notify-reload
+// services is then sent ReloadSignal and is waited for to report the completion of the
+// reload using READY=1. Reload fails if the commands fail, the main process exits or
+// the reload does not complete in TimeoutStartSec
+func (sv *Unit) Reload() (err error) {
+	log.WithField("ExecStart", sv.Definition.Service.ExecStart).Debug("sv.Reload")
+
+	if !sv.CanReload() {
+		return ErrNoReload
+	}
+	if sv.Active() != unit.Active {
+		return ErrNotRunning
+	}
+
+	timedOut := make(chan struct{})
+	if timeout := sv.Definition.Service.TimeoutStartSec; timeout > 0 && timeout != unit.Infinity {
+		timer := time.AfterFunc(timeout, func() {
+			close(timedOut)
+			sv.abortReload(sv.Definition.Service.TimeoutStopSec)
+		})
+		defer timer.Stop()
+	}
+
+	if err = sv.runControl(reload, sv.commands["ExecReload"]); err == nil && sv.Definition.Service.Type == "notify-reload" {
+		err = sv.reloadNotify(timedOut)
+	}
+
+	select {
+	case <-timedOut:
+		err = ErrReloadTimeout
+	default:
+	}
+	return
+}
+
+// abortReload terminates the reload control process, which did not complete in TimeoutStartSec.
+// It is sent KillSignal and FinalKillSignal, if it does not exit in timeout and SendSIGKILL is set
+func (sv *Unit) abortReload(timeout time.Duration) {
+	sv.mutex.Lock()
+	phase, pid := sv.phase, sv.controlPID
+	sv.mutex.Unlock()
+
+	log.WithField("ExecStart", sv.Definition.Service.ExecStart).Warn("Reload operation timed out")
+	if phase != reload || pid <= 0 {
+		return
+	}
+
+	def := sv.Definition.Service
+	sig, err := parseSignal(def.KillSignal)
+	if err != nil {
+		sig = syscall.SIGTERM
+	}
+	// Control processes lead their own process groups
+	syscall.Kill(-pid, sig)
+	if waitExit([]int{pid}, timeout) || !def.SendSIGKILL {
+		return
+	}
+
+	if sig, err = parseSignal(def.FinalKillSignal); err != nil {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(-pid, sig)
+}
+
+// reloadNotify sends ReloadSignal to the main process of the service and waits for it
+// to report the completion of the reload using READY=1 until timedOut is closed
+func (sv *Unit) reloadNotify(timedOut <-chan struct{}) (err error) {
+	sig, err := parseSignal(sv.Definition.Service.ReloadSignal)
+	if err != nil {
+		sig = syscall.SIGHUP
+	}
+
+	pid := sv.MainPID()
+	if pid <= 0 || !isAlive(pid) {
+		return ErrReloadExited
+	}
+
+	sv.mutex.Lock()
+	sv.notified = reload
+	sv.reloadch = make(chan struct{})
+	reloadch := sv.reloadch
+	sv.mutex.Unlock()
+
+	defer func() {
+		sv.mutex.Lock()
+		defer sv.mutex.Unlock()
+
+		sv.reloadch = nil
+		if sv.notified == reload {
+			sv.notified = ""
+		}
+	}()
+
+	if err = syscall.Kill(pid, sig); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reloadch:
+			return nil
+		case <-timedOut:
+			return ErrReloadTimeout
+		case <-ticker.C:
+			if !isAlive(pid) {
+				return ErrReloadExited
+			}
+		}
+	}
+}
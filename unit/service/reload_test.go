@@ -0,0 +1,169 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitSub waits for sv to reach the sub state specified for up to a second and reports whether it did
+func waitSub(sv *Unit, sub string) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if sv.Sub() == sub {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-reload-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+ExecReload=/bin/sh -c 'echo $MAINPID > `+out+`; sleep 0.2'`)))
+	assert.True(t, sv.CanReload())
+	assert.Equal(t, ErrNotRunning, sv.Reload(), "sv.Reload of a service not started")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+
+	errch := make(chan error)
+	go func() { errch <- sv.Reload() }()
+
+	assert.True(t, waitSub(sv, reload), "reload sub state")
+	assert.Equal(t, unit.Reloading, sv.Active())
+	require.NoError(t, <-errch, "sv.Reload")
+	assert.Equal(t, running, sv.Sub())
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(sv.MainPID())+"\n", string(b))
+}
+
+func TestReloadFail(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader("[Service]\nExecStart=/bin/sleep 10")))
+	require.NoError(t, sv.Start(), "sv.Start")
+	assert.False(t, sv.CanReload())
+	assert.Equal(t, ErrNoReload, sv.Reload())
+	require.NoError(t, sv.Stop(), "sv.Stop")
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+ExecReload=/bin/false`)))
+	require.NoError(t, sv.Start(), "sv.Start")
+	assert.Error(t, sv.Reload(), "failed ExecReload")
+	assert.Equal(t, running, sv.Sub(), "service keeps running")
+	require.NoError(t, sv.Stop(), "sv.Stop")
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+ExecReload=/bin/sleep 10
+TimeoutStartSec=300ms`)))
+	require.NoError(t, sv.Start(), "sv.Start")
+	start := time.Now()
+	assert.Equal(t, ErrReloadTimeout, sv.Reload())
+	assert.True(t, time.Since(start) < 5*time.Second, "reload process terminated")
+	assert.Equal(t, running, sv.Sub(), "service keeps running")
+	require.NoError(t, sv.Stop(), "sv.Stop")
+}
+
+func TestReloadTimeoutKill(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+ExecReload=/bin/sh -c 'trap "" TERM; while :; do sleep 0.1; done'
+TimeoutStartSec=300ms
+TimeoutStopSec=300ms`)))
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+
+	// Reload process ignoring KillSignal is killed with FinalKillSignal
+	errch := make(chan error, 1)
+	go func() { errch <- sv.Reload() }()
+
+	select {
+	case err := <-errch:
+		assert.Equal(t, ErrReloadTimeout, err, "sv.Reload")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reload process ignoring SIGTERM was not killed")
+	}
+	assert.Equal(t, running, sv.Sub(), "service keeps running")
+}
+
+func TestReloadNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-reload-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out, ready := filepath.Join(dir, "out"), filepath.Join(dir, "ready")
+
+	sv := &Unit{NotifySocket: "/run/test/notify"}
+	err = sv.Define(strings.NewReader("[Service]\nType=notify-reload\nExecStart=/bin/true\nReloadSignal=SIGFOO"))
+	if assert.IsType(t, unit.MultiError{}, err) {
+		assert.Equal(t, "ReloadSignal", err.(unit.MultiError)[0].(unit.ParseError).Source)
+	}
+
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=notify-reload
+ExecStart=/bin/sh -c 'trap "echo reload >> `+out+`" USR1; touch `+ready+`; while :; do sleep 0.05; done'
+ReloadSignal=USR1
+TimeoutStartSec=2s`)))
+	assert.Equal(t, "main", sv.Definition.Service.NotifyAccess)
+	assert.True(t, sv.CanReload())
+
+	// Service is ready once it handles ReloadSignal
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(ready); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		sv.Notify(sv.MainPID(), "READY=1")
+	}()
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+	assert.Equal(t, running, sv.Sub())
+
+	errch := make(chan error)
+	go func() { errch <- sv.Reload() }()
+
+	// Service is reloading until it reports the completion of the reload
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if b, _ := ioutil.ReadFile(out); string(b) == "reload\n" {
+			break
+		}
+	}
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, "reload\n", string(b), "ReloadSignal received")
+	assert.Equal(t, reload, sv.Sub())
+
+	require.NoError(t, sv.Notify(sv.MainPID(), "RELOADING=1"))
+	assert.Equal(t, reload, sv.Sub())
+	require.NoError(t, sv.Notify(sv.MainPID(), "READY=1"))
+	require.NoError(t, <-errch, "sv.Reload")
+	assert.Equal(t, running, sv.Sub())
+
+	// Reload, which is not reported to be complete, times out
+	sv.Definition.Service.TimeoutStartSec = 300 * time.Millisecond
+	assert.Equal(t, ErrReloadTimeout, sv.Reload())
+	assert.Equal(t, running, sv.Sub())
+}
@@ -0,0 +1,465 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Value of a resource limit meaning that there is no limit
+const RLIM_INFINITY = ^uint64(0)
+
+// Kinds of values resource limits are specified in
+const (
+	rlimitNumber = iota
+	rlimitSize
+	rlimitSeconds
+	rlimitMicroseconds
+	rlimitNice
+)
+
+// Resource limits settable with Limit*= options (see setrlimit(2))
+var rlimits = map[string]struct{ resource, kind int }{
+	"LimitCPU":        {0, rlimitSeconds},
+	"LimitFSIZE":      {1, rlimitSize},
+	"LimitDATA":       {2, rlimitSize},
+	"LimitSTACK":      {3, rlimitSize},
+	"LimitCORE":       {4, rlimitSize},
+	"LimitRSS":        {5, rlimitSize},
+	"LimitNPROC":      {6, rlimitNumber},
+	"LimitNOFILE":     {7, rlimitNumber},
+	"LimitMEMLOCK":    {8, rlimitSize},
+	"LimitAS":         {9, rlimitSize},
+	"LimitLOCKS":      {10, rlimitNumber},
+	"LimitSIGPENDING": {11, rlimitNumber},
+	"LimitMSGQUEUE":   {12, rlimitSize},
+	"LimitNICE":       {13, rlimitNice},
+	"LimitRTPRIO":     {14, rlimitNumber},
+	"LimitRTTIME":     {15, rlimitMicroseconds},
+}
+
+// Size suffixes accepted by Limit*= options, which take a size in bytes
+var sizeSuffixes = map[byte]uint64{
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+	'P': 1 << 50,
+	'E': 1 << 60,
+}
+
+// I/O scheduling classes (see ioprio_set(2))
+var ioSchedulingClasses = map[string]int{
+	"none":        0,
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// CPU scheduling policies (see sched_setscheduler(2))
+var cpuSchedulingPolicies = map[string]int{
+	"other": 0,
+	"fifo":  1,
+	"rr":    2,
+	"batch": 3,
+	"idle":  5,
+}
+
+// Default priority within an I/O scheduling class
+const DEFAULT_IO_SCHEDULING_PRIORITY = 4
+
+// resources holds the resource and scheduling settings applied to the processes of the service
+type resources struct {
+	// Resource limits mapped to the resources
+	Limits map[int]syscall.Rlimit `json:",omitempty"`
+
+	// Optional settings, nil if not specified
+	UMask, Nice, OOMScoreAdjust *int `json:",omitempty"`
+	IOPriority                  *int `json:",omitempty"` // class << 13 | priority
+	CPUPolicy, CPUPriority      *int `json:",omitempty"`
+
+	// CPU affinity mask, nil if not specified
+	CPUAffinity []uint64 `json:",omitempty"`
+}
+
+// empty reports whether no settings are specified
+func (res *resources) empty() bool {
+	return len(res.Limits) == 0 && res.UMask == nil && res.Nice == nil && res.OOMScoreAdjust == nil &&
+		res.IOPriority == nil && res.CPUPolicy == nil && res.CPUPriority == nil && res.CPUAffinity == nil
+}
+
+// limitValues returns values of the Limit*= options specified in the definition mapped to the option names
+func (def Definition) limitValues() map[string]string {
+	return map[string]string{
+		"LimitCPU":        def.Service.LimitCPU,
+		"LimitFSIZE":      def.Service.LimitFSIZE,
+		"LimitDATA":       def.Service.LimitDATA,
+		"LimitSTACK":      def.Service.LimitSTACK,
+		"LimitCORE":       def.Service.LimitCORE,
+		"LimitRSS":        def.Service.LimitRSS,
+		"LimitNPROC":      def.Service.LimitNPROC,
+		"LimitNOFILE":     def.Service.LimitNOFILE,
+		"LimitMEMLOCK":    def.Service.LimitMEMLOCK,
+		"LimitAS":         def.Service.LimitAS,
+		"LimitLOCKS":      def.Service.LimitLOCKS,
+		"LimitSIGPENDING": def.Service.LimitSIGPENDING,
+		"LimitMSGQUEUE":   def.Service.LimitMSGQUEUE,
+		"LimitNICE":       def.Service.LimitNICE,
+		"LimitRTPRIO":     def.Service.LimitRTPRIO,
+		"LimitRTTIME":     def.Service.LimitRTTIME,
+	}
+}
+
+// parseResources parses the resource and scheduling settings specified in def
+func parseResources(def Definition) (res *resources, merr unit.MultiError) {
+	res = &resources{Limits: map[int]syscall.Rlimit{}}
+	svc := def.Service
+
+	for name, value := range def.limitValues() {
+		if value == "" {
+			continue
+		}
+
+		lim, err := parseRlimit(value, rlimits[name].kind)
+		if err != nil {
+			merr = append(merr, unit.ParseErr(name, unit.ParseErr(value, err)))
+			continue
+		}
+		res.Limits[rlimits[name].resource] = lim
+	}
+
+	parseInt := func(name, value string, base, min, max int) *int {
+		if value == "" {
+			return nil
+		}
+		n, err := strconv.ParseInt(value, base, 32)
+		if err != nil || int(n) < min || int(n) > max {
+			merr = append(merr, unit.ParseErr(name, unit.ParseErr(value, unit.ErrWrongVal)))
+			return nil
+		}
+		i := int(n)
+		return &i
+	}
+
+	res.UMask = parseInt("UMask", svc.UMask, 8, 0, 0777)
+	res.Nice = parseInt("Nice", svc.Nice, 10, -20, 19)
+	res.OOMScoreAdjust = parseInt("OOMScoreAdjust", svc.OOMScoreAdjust, 10, -1000, 1000)
+
+	class, ok := ioSchedulingClasses[svc.IOSchedulingClass]
+	if !ok {
+		if p := parseInt("IOSchedulingClass", svc.IOSchedulingClass, 10, 0, 3); p != nil {
+			class = *p
+		}
+	}
+	if priority := parseInt("IOSchedulingPriority", svc.IOSchedulingPriority, 10, 0, 7); priority != nil || svc.IOSchedulingClass != "" {
+		if svc.IOSchedulingClass == "" {
+			class = ioSchedulingClasses["best-effort"]
+		}
+		ioprio := class<<13 | DEFAULT_IO_SCHEDULING_PRIORITY
+		if priority != nil {
+			ioprio = class<<13 | *priority
+		}
+		res.IOPriority = &ioprio
+	}
+
+	if svc.CPUSchedulingPolicy != "" {
+		policy, ok := cpuSchedulingPolicies[svc.CPUSchedulingPolicy]
+		if !ok {
+			merr = append(merr, unit.ParseErr("CPUSchedulingPolicy", unit.ParseErr(svc.CPUSchedulingPolicy, unit.ErrNotSupported)))
+		}
+		res.CPUPolicy = &policy
+	}
+	res.CPUPriority = parseInt("CPUSchedulingPriority", svc.CPUSchedulingPriority, 10, 0, 99)
+	if res.CPUPriority != nil && *res.CPUPriority > 0 &&
+		svc.CPUSchedulingPolicy != "fifo" && svc.CPUSchedulingPolicy != "rr" {
+		// Priorities above 0 are only valid for realtime policies
+		merr = append(merr, unit.ParseErr("CPUSchedulingPriority", unit.ParseErr(svc.CPUSchedulingPriority, ErrNotRealtime)))
+	}
+
+	for _, line := range svc.CPUAffinity {
+		var err error
+		if res.CPUAffinity, err = parseCPUSet(line, res.CPUAffinity); err != nil {
+			merr = append(merr, unit.ParseErr("CPUAffinity", unit.ParseErr(line, err)))
+		}
+	}
+
+	if res.empty() {
+		return nil, merr
+	}
+	return res, merr
+}
+
+// parseRlimit parses a value of a Limit*= option of kind specified, which is either
+// a single value setting both soft and hard limits or "soft:hard"
+func parseRlimit(s string, kind int) (lim syscall.Rlimit, err error) {
+	soft, hard := s, s
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		soft, hard = s[:i], s[i+1:]
+	}
+
+	if lim.Cur, err = parseRlimitValue(soft, kind); err != nil {
+		return
+	}
+	if lim.Max, err = parseRlimitValue(hard, kind); err != nil {
+		return
+	}
+	if lim.Cur > lim.Max {
+		return lim, unit.ErrWrongVal
+	}
+	return
+}
+
+func parseRlimitValue(s string, kind int) (v uint64, err error) {
+	if s == "infinity" {
+		return RLIM_INFINITY, nil
+	}
+
+	switch kind {
+	case rlimitSize:
+		mult := uint64(1)
+		if n := len(s); n > 0 {
+			if m, ok := sizeSuffixes[s[n-1]]; ok {
+				s, mult = s[:n-1], m
+			} else if s[n-1] == 'B' {
+				s = s[:n-1]
+			}
+		}
+		if v, err = strconv.ParseUint(s, 10, 64); err != nil || v > RLIM_INFINITY/mult {
+			return 0, unit.ErrWrongVal
+		}
+		return v * mult, nil
+
+	case rlimitSeconds, rlimitMicroseconds:
+		if kind == rlimitMicroseconds {
+			if v, err = strconv.ParseUint(s, 10, 64); err == nil {
+				// Plain numbers are microseconds
+				return v, nil
+			}
+		}
+
+		var d time.Duration
+		if d, err = unit.ParseTimespan(s); err != nil {
+			return
+		}
+		if d == unit.Infinity {
+			return RLIM_INFINITY, nil
+		}
+		if kind == rlimitSeconds {
+			return uint64((d + time.Second - 1) / time.Second), nil
+		}
+		return uint64(d / time.Microsecond), nil
+
+	case rlimitNice:
+		if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+			// Nice level, which is converted to the limit
+			var n int64
+			if n, err = strconv.ParseInt(s, 10, 32); err != nil || n < -20 || n > 19 {
+				return 0, unit.ErrWrongVal
+			}
+			return uint64(20 - n), nil
+		}
+		if v, err = strconv.ParseUint(s, 10, 64); err != nil || v > 40 {
+			return 0, unit.ErrWrongVal
+		}
+		return
+
+	default:
+		if v, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return 0, unit.ErrWrongVal
+		}
+		return
+	}
+}
+
+// parseCPUSet parses a list of CPU indices and ranges separated by whitespace or commas
+// and adds the CPUs to mask. An empty list resets the mask
+func parseCPUSet(s string, mask []uint64) ([]uint64, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	for _, f := range fields {
+		lo, hi := f, f
+		if i := strings.IndexByte(f, '-'); i >= 0 {
+			lo, hi = f[:i], f[i+1:]
+		}
+
+		first, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return mask, unit.ErrWrongVal
+		}
+		last, err := strconv.ParseUint(hi, 10, 16)
+		if err != nil || last < first {
+			return mask, unit.ErrWrongVal
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			for uint64(len(mask)) <= cpu/64 {
+				mask = append(mask, 0)
+			}
+			mask[cpu/64] |= 1 << (cpu % 64)
+		}
+	}
+	return mask, nil
+}
+
+// formatCPUSet returns the CPUs in mask as a list of indices and ranges
+func formatCPUSet(mask []uint64) string {
+	var ranges []string
+	for cpu, n := 0, len(mask)*64; cpu < n; cpu++ {
+		if mask[cpu/64]&(1<<uint(cpu%64)) == 0 {
+			continue
+		}
+
+		last := cpu
+		for last+1 < n && mask[(last+1)/64]&(1<<uint((last+1)%64)) != 0 {
+			last++
+		}
+		if last == cpu {
+			ranges = append(ranges, strconv.Itoa(cpu))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", cpu, last))
+		}
+		cpu = last
+	}
+	return strings.Join(ranges, " ")
+}
+
+// apply applies the settings to the calling process. Nice level and scheduling settings apply
+// to the calling thread, so it has to be the one executing the command
+func (res *resources) apply() error {
+	if res.UMask != nil {
+		syscall.Umask(*res.UMask)
+	}
+
+	resources := make([]int, 0, len(res.Limits))
+	for resource := range res.Limits {
+		resources = append(resources, resource)
+	}
+	sort.Ints(resources)
+
+	for _, resource := range resources {
+		lim := res.Limits[resource]
+		if err := prlimit(0, resource, &lim, nil); err != nil {
+			return fmt.Errorf("Failed to set resource limit %d: %s", resource, err)
+		}
+	}
+
+	if res.Nice != nil {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, *res.Nice); err != nil {
+			return fmt.Errorf("Failed to set nice level: %s", err)
+		}
+	}
+
+	if res.OOMScoreAdjust != nil {
+		if err := ioutil.WriteFile("/proc/self/oom_score_adj", []byte(strconv.Itoa(*res.OOMScoreAdjust)), 0644); err != nil {
+			return fmt.Errorf("Failed to adjust OOM score: %s", err)
+		}
+	}
+
+	if res.CPUAffinity != nil {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0,
+			uintptr(len(res.CPUAffinity)*8), uintptr(unsafe.Pointer(&res.CPUAffinity[0]))); errno != 0 {
+			return fmt.Errorf("Failed to set CPU affinity: %s", errno)
+		}
+	}
+
+	if res.IOPriority != nil {
+		const IOPRIO_WHO_PROCESS = 1
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_IOPRIO_SET, IOPRIO_WHO_PROCESS, 0, uintptr(*res.IOPriority)); errno != 0 {
+			return fmt.Errorf("Failed to set I/O scheduling: %s", errno)
+		}
+	}
+
+	if res.CPUPolicy != nil || res.CPUPriority != nil {
+		param := struct{ priority int32 }{}
+		if res.CPUPriority != nil {
+			param.priority = int32(*res.CPUPriority)
+		}
+
+		var errno syscall.Errno
+		if res.CPUPolicy != nil {
+			_, _, errno = syscall.RawSyscall(syscall.SYS_SCHED_SETSCHEDULER, 0, uintptr(*res.CPUPolicy), uintptr(unsafe.Pointer(&param)))
+		} else {
+			_, _, errno = syscall.RawSyscall(syscall.SYS_SCHED_SETPARAM, 0, uintptr(unsafe.Pointer(&param)), 0)
+		}
+		if errno != 0 {
+			return fmt.Errorf("Failed to set CPU scheduling: %s", errno)
+		}
+	}
+	return nil
+}
+
+// prlimit sets the resource limit of the process with pid specified to lim, if not nil,
+// and stores the previous limit in old, if not nil. Zero pid means the calling process
+func prlimit(pid, resource int, lim, old *syscall.Rlimit) error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(lim)), uintptr(unsafe.Pointer(old)), 0, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// resourceProperties adds the resource and scheduling settings of the processes of the
+// service to props. Settings not specified are reported as inherited from the manager
+func (sv *Unit) resourceProperties(props map[string]string) {
+	res := sv.resources
+	if res == nil {
+		res = &resources{}
+	}
+
+	for name, rl := range rlimits {
+		lim, ok := res.Limits[rl.resource]
+		if !ok {
+			if err := prlimit(0, rl.resource, nil, &lim); err != nil {
+				continue
+			}
+		}
+		props[name] = formatRlimit(lim.Max)
+		props[name+"Soft"] = formatRlimit(lim.Cur)
+	}
+
+	optional := func(v *int, def int) int {
+		if v == nil {
+			return def
+		}
+		return *v
+	}
+
+	props["UMask"] = fmt.Sprintf("%04o", optional(res.UMask, 0022))
+	props["Nice"] = strconv.Itoa(optional(res.Nice, 0))
+	props["OOMScoreAdjust"] = strconv.Itoa(optional(res.OOMScoreAdjust, 0))
+	props["CPUAffinity"] = formatCPUSet(res.CPUAffinity)
+
+	ioprio := optional(res.IOPriority, ioSchedulingClasses["best-effort"]<<13|DEFAULT_IO_SCHEDULING_PRIORITY)
+	props["IOSchedulingClass"] = nameOf(ioSchedulingClasses, ioprio>>13)
+	props["IOSchedulingPriority"] = strconv.Itoa(ioprio & (1<<13 - 1))
+
+	props["CPUSchedulingPolicy"] = nameOf(cpuSchedulingPolicies, optional(res.CPUPolicy, 0))
+	props["CPUSchedulingPriority"] = strconv.Itoa(optional(res.CPUPriority, 0))
+}
+
+func formatRlimit(v uint64) string {
+	if v == RLIM_INFINITY {
+		return "infinity"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// nameOf returns the name v is mapped to in names, or v formatted as a number if there is none
+func nameOf(names map[string]int, v int) string {
+	for name, n := range names {
+		if n == v {
+			return name
+		}
+	}
+	return strconv.Itoa(v)
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRlimit(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		kind     int
+		expected syscall.Rlimit
+	}{
+		{"1024", rlimitNumber, syscall.Rlimit{Cur: 1024, Max: 1024}},
+		{"1024:4096", rlimitNumber, syscall.Rlimit{Cur: 1024, Max: 4096}},
+		{"1024:infinity", rlimitNumber, syscall.Rlimit{Cur: 1024, Max: RLIM_INFINITY}},
+		{"0", rlimitSize, syscall.Rlimit{Cur: 0, Max: 0}},
+		{"4K:1M", rlimitSize, syscall.Rlimit{Cur: 4096, Max: 1 << 20}},
+		{"10", rlimitSeconds, syscall.Rlimit{Cur: 10, Max: 10}},
+		{"1min:1h", rlimitSeconds, syscall.Rlimit{Cur: 60, Max: 3600}},
+		{"500", rlimitMicroseconds, syscall.Rlimit{Cur: 500, Max: 500}},
+		{"2s", rlimitMicroseconds, syscall.Rlimit{Cur: 2000000, Max: 2000000}},
+		{"-5", rlimitNice, syscall.Rlimit{Cur: 25, Max: 25}},
+		{"30", rlimitNice, syscall.Rlimit{Cur: 30, Max: 30}},
+	} {
+		lim, err := parseRlimit(test.value, test.kind)
+		if assert.NoError(t, err, test.value) {
+			assert.Equal(t, test.expected, lim, test.value)
+		}
+	}
+
+	for _, test := range []struct {
+		value string
+		kind  int
+	}{
+		{"", rlimitNumber},
+		{"foo", rlimitNumber},
+		{"2048:1024", rlimitNumber},
+		{"1X", rlimitSize},
+		{"50", rlimitNice},
+		{"+20", rlimitNice},
+	} {
+		_, err := parseRlimit(test.value, test.kind)
+		assert.Error(t, err, test.value)
+	}
+}
+
+func TestCPUSet(t *testing.T) {
+	mask, err := parseCPUSet("0 2-4,65", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0x1d, 0x2}, mask)
+	assert.Equal(t, "0 2-4 65", formatCPUSet(mask))
+
+	mask, err = parseCPUSet("1", mask)
+	require.NoError(t, err)
+	assert.Equal(t, "0-4 65", formatCPUSet(mask))
+
+	mask, err = parseCPUSet("", mask)
+	require.NoError(t, err)
+	assert.Nil(t, mask, "empty assignment")
+
+	for _, s := range []string{"a", "3-1", "1-"} {
+		_, err = parseCPUSet(s, nil)
+		assert.Equal(t, unit.ErrWrongVal, err, s)
+	}
+}
+
+func TestDefineResources(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true`)), "sv.Define")
+	assert.Nil(t, sv.resources, "no settings")
+
+	for option, value := range map[string]string{
+		"LimitNOFILE":           "many",
+		"Nice":                  "20",
+		"UMask":                 "0999",
+		"OOMScoreAdjust":        "-1001",
+		"IOSchedulingClass":     "fast",
+		"IOSchedulingPriority":  "8",
+		"CPUSchedulingPolicy":   "deadline",
+		"CPUSchedulingPriority": "100",
+		"CPUAffinity":           "x",
+	} {
+		sv = &Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+
+	// Priorities above 0 require a realtime policy
+	for options, valid := range map[string]bool{
+		"CPUSchedulingPriority=10":                            false,
+		"CPUSchedulingPolicy=batch\nCPUSchedulingPriority=10": false,
+		"CPUSchedulingPolicy=other\nCPUSchedulingPriority=0":  true,
+		"CPUSchedulingPolicy=rr\nCPUSchedulingPriority=10":    true,
+		"CPUSchedulingPolicy=fifo\nCPUSchedulingPriority=99":  true,
+	} {
+		sv = &Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + options))
+		if valid {
+			assert.NoError(t, err, options)
+		} else if assert.IsType(t, unit.MultiError{}, err, options) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, options) {
+				assert.Equal(t, "CPUSchedulingPriority", merr[0].(unit.ParseError).Source, options)
+			}
+		}
+	}
+}
+
+func TestResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-resources")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	script := `ulimit -n; ulimit -Hn; umask; cat /proc/self/oom_score_adj; grep Cpus_allowed_list /proc/self/status; cut -d" " -f19 /proc/self/stat`
+	pre, main := filepath.Join(dir, "pre"), filepath.Join(dir, "main")
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStartPre=/bin/sh -c 'exec >`+pre+`; `+script+`'
+ExecStart=/bin/sh -c '`+script+`'
+StandardOutput=file:`+main+`
+LimitNOFILE=100:200
+UMask=0027
+OOMScoreAdjust=500
+CPUAffinity=0
+Nice=5`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	expected := "100\n200\n0027\n500\nCpus_allowed_list:\t0\n5\n"
+	for _, path := range []string{pre, main} {
+		b, err := ioutil.ReadFile(path)
+		require.NoError(t, err, path)
+		assert.Equal(t, expected, string(b), path)
+	}
+
+	props := sv.Properties()
+	assert.Equal(t, "200", props["LimitNOFILE"])
+	assert.Equal(t, "100", props["LimitNOFILESoft"])
+	assert.Equal(t, "0027", props["UMask"])
+	assert.Equal(t, "5", props["Nice"])
+	assert.Equal(t, "500", props["OOMScoreAdjust"])
+	assert.Equal(t, "0", props["CPUAffinity"])
+	assert.Equal(t, "best-effort", props["IOSchedulingClass"])
+	assert.Equal(t, "other", props["CPUSchedulingPolicy"])
+	assert.Equal(t, "oneshot", props["Type"])
+	assert.Contains(t, props, "LimitCORE")
+}
+
+func TestResourcesFail(t *testing.T) {
+	// Limit above the maximum number of open files can not be set
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+LimitNOFILE=infinity`)), "sv.Define")
+
+	err := sv.Start()
+	if assert.IsType(t, &SandboxError{}, err, "sv.Start") {
+		assert.Contains(t, err.Error(), "Failed to set resource limit")
+	}
+	assert.Equal(t, failed, sv.Sub(), "sv.Sub")
+}
@@ -0,0 +1,354 @@
+package service
+
+import (
+	"math"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Default time to sleep before restarting a service
+const DEFAULT_RESTART_SEC = 100 * time.Millisecond
+
+// Results of a service run
+const (
+	resultSuccess  = "success"
+	resultExitCode = "exit-code"
+	resultSignal   = "signal"
+	resultCoreDump = "core-dump"
+	resultTimeout  = "timeout"
+	resultWatchdog = "watchdog"
+
+	// Exit status of the main process could not be determined, e.g. because
+	// it was not a child of the manager
+	resultUnknown = "unknown"
+)
+
+// Restart policies mapped to results they restart the service on (see systemd.service(5))
+var restartPolicies = map[string]map[string]bool{
+	"no": {},
+	"on-success": {
+		resultSuccess: true,
+	},
+	"on-failure": {
+		resultUnknown:  true,
+		resultExitCode: true,
+		resultSignal:   true,
+		resultCoreDump: true,
+		resultTimeout:  true,
+		resultWatchdog: true,
+	},
+	"on-abnormal": {
+		resultSignal:   true,
+		resultCoreDump: true,
+		resultTimeout:  true,
+		resultWatchdog: true,
+	},
+	"on-abort": {
+		resultSignal:   true,
+		resultCoreDump: true,
+	},
+	"on-watchdog": {
+		resultWatchdog: true,
+	},
+	"always": {
+		resultSuccess:  true,
+		resultUnknown:  true,
+		resultExitCode: true,
+		resultSignal:   true,
+		resultCoreDump: true,
+		resultTimeout:  true,
+		resultWatchdog: true,
+	},
+}
+
+// Signals, termination by which is considered a clean exit
+var cleanSignals = map[syscall.Signal]bool{
+	syscall.SIGHUP:  true,
+	syscall.SIGINT:  true,
+	syscall.SIGTERM: true,
+	syscall.SIGPIPE: true,
+}
+
+// exitResult returns the result of a run, which ended with exit status st.
+// nil st means that the exit status of the process is unknown
+func exitResult(st *unit.ExitStatus) string {
+	switch {
+	case st == nil:
+		return resultUnknown
+	case st.Signal == "" && st.Code == 0:
+		return resultSuccess
+	case st.Signal == "":
+		return resultExitCode
+	}
+
+	if sig, err := parseSignal(st.Signal); err == nil && cleanSignals[sig] {
+		return resultSuccess
+	}
+	if st.CoreDump {
+		return resultCoreDump
+	}
+	return resultSignal
+}
+
+// statusResult returns the result of the run of the main process, which exited with status st,
+// nil if unknown. Exit statuses in SuccessExitStatus are considered a success
+func (sv *Unit) statusResult(st *unit.ExitStatus) string {
+	if sv.ignoresFailure() || sv.successStatus.contains(st) {
+		// Failure of the main process is considered a success
+		return resultSuccess
+	}
+	return exitResult(st)
+}
+
+// AutoRestart returns a channel, which receives a value each time the service
+// requests to be restarted according to its restart policy
+func (sv *Unit) AutoRestart() <-chan struct{} {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	return sv.restartChan()
+}
+
+func (sv *Unit) restartChan() chan struct{} {
+	if sv.restartch == nil {
+		sv.restartch = make(chan struct{}, 1)
+	}
+	return sv.restartch
+}
+
+// NRestarts returns the number of times the service was restarted automatically
+// since it was last started explicitly
+func (sv *Unit) NRestarts() int {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	return sv.nRestarts
+}
+
+// ResetFailed resets the counter of automatic restarts of the service
+func (sv *Unit) ResetFailed() {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.nRestarts = 0
+}
+
+// supervise waits for the main process of the service started by cmd to exit
+// and handles the exit. exitch, if not nil, gets closed once cmd exits
+func (sv *Unit) supervise(cmd *exec.Cmd, exitch chan struct{}) {
+	sv.waitMain(cmd)
+	if exitch != nil {
+		close(exitch)
+	}
+
+	st := exitStatusOf(cmd.ProcessState)
+
+	// Main PID could have been changed using MAINPID=
+	sv.mutex.Lock()
+	pid := sv.mainPID
+	sv.mutex.Unlock()
+
+	if pid != cmd.Process.Pid && isAlive(pid) {
+		st = sv.waitOrphan(pid)
+	}
+
+	sv.setExitStatus(st)
+	sv.exited(cmd, sv.statusResult(st))
+}
+
+// supervisePID waits for the main process with pid specified, which is not a child
+// of the manager, to exit and handles the exit
+func (sv *Unit) supervisePID(cmd *exec.Cmd, pid int) {
+	if pid == 0 {
+		return
+	}
+
+	st := sv.waitOrphan(pid)
+	if st != nil {
+		sv.setExitStatus(st)
+	}
+
+	result := sv.statusResult(st)
+
+	sv.mutex.Lock()
+	if st == nil && sv.stopping {
+		// Process was terminated by the manager
+		result = resultSuccess
+	}
+	sv.mutex.Unlock()
+
+	sv.exited(cmd, result)
+}
+
+// waitOrphan waits for the process with pid specified, which is not a child of the manager,
+// to exit. Its exit status is returned if the process was reparented to the manager and
+// reaped by it, nil otherwise
+func (sv *Unit) waitOrphan(pid int) *unit.ExitStatus {
+	orphanch := make(chan struct{})
+
+	sv.mutex.Lock()
+	sv.orphanPID, sv.orphanch, sv.orphanExit = pid, orphanch, nil
+	sv.mutex.Unlock()
+
+	defer func() {
+		sv.mutex.Lock()
+		if sv.orphanch == orphanch {
+			sv.orphanch = nil
+		}
+		sv.mutex.Unlock()
+	}()
+
+	exitch := make(chan struct{})
+	go func() {
+		waitPID(pid)
+		close(exitch)
+	}()
+
+	select {
+	case <-orphanch:
+	case <-exitch:
+		if st, err := unit.ReadProcStat(pid); err != nil || st.PPID != os.Getpid() {
+			break
+		}
+		// The process is a zombie child of the manager, which is about to be reaped
+		select {
+		case <-orphanch:
+		case <-time.After(POLL_INTERVAL):
+		}
+	}
+
+	select {
+	case <-orphanch:
+		sv.mutex.Lock()
+		defer sv.mutex.Unlock()
+
+		return sv.orphanExit
+	default:
+		return nil
+	}
+}
+
+// ProcessExited handles the exit of the orphaned process of the service with pid specified,
+// which was reparented to the manager and reaped by it
+func (sv *Unit) ProcessExited(pid int, status syscall.WaitStatus) {
+	log.WithFields(log.Fields{
+		"pid":    pid,
+		"status": status,
+	}).Debugf("sv.ProcessExited")
+
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	if sv.orphanch == nil || pid != sv.orphanPID {
+		return
+	}
+	sv.orphanExit = waitExitStatus(status)
+	close(sv.orphanch)
+	sv.orphanch = nil
+}
+
+// handleExit records the result of the run of cmd and schedules a restart if
+// the restart policy requires one. Otherwise runtime directories are removed, unless the service remains active
+func (sv *Unit) handleExit(cmd *exec.Cmd, result string) {
+	e := log.WithFields(log.Fields{
+		"ExecStart": sv.Definition.Service.ExecStart,
+		"result":    result,
+	})
+	e.Debug("sv.handleExit")
+
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	if cmd == sv.main {
+		// Main process was reaped, its PID may be reused by another process
+		sv.mainPID = 0
+	}
+	if cmd != sv.main || sv.startFailed {
+		// Service had been started again already or the failure was handled on start
+		return
+	}
+
+	sv.result = result
+	sv.scheduleRestart(result)
+	if !sv.restarting && !sv.Definition.Service.RemainAfterExit {
+		sv.removeRuntimeDirectories(true)
+	}
+}
+
+// scheduleRestart schedules a restart of the service if the restart policy requires
+// one for result specified. Exit statuses of the main process in RestartPreventExitStatus
+// and RestartForceExitStatus override the policy. sv.mutex must be held by the caller
+func (sv *Unit) scheduleRestart(result string) {
+	restart := restartPolicies[sv.Definition.Service.Restart][result]
+	switch {
+	case sv.preventStatus.contains(sv.exitStatus):
+		restart = false
+	case sv.forceStatus.contains(sv.exitStatus):
+		restart = true
+	}
+	if sv.stopping || !restart {
+		return
+	}
+
+	delay := sv.restartDelay()
+	log.WithField("result", result).Debugf("Restarting in %v", delay)
+
+	sv.cancelRestart()
+	sv.restarting = true
+	sv.restartTimer = time.AfterFunc(delay, sv.requestRestart)
+}
+
+// requestRestart notifies the listener of AutoRestart channel
+func (sv *Unit) requestRestart() {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	if !sv.restarting {
+		// Restart was cancelled
+		return
+	}
+	sv.restarting = false
+	sv.autoRestarted = true
+	sv.nRestarts++
+
+	select {
+	case sv.restartChan() <- struct{}{}:
+	default:
+		// A restart is already pending
+	}
+}
+
+// cancelRestart cancels a scheduled restart, if any
+func (sv *Unit) cancelRestart() {
+	sv.restarting = false
+	if sv.restartTimer != nil {
+		sv.restartTimer.Stop()
+		sv.restartTimer = nil
+	}
+}
+
+// restartDelay returns the time to sleep before the next restart.
+// If RestartSteps and RestartMaxDelaySec are set, the delay grows exponentially
+// from RestartSec to RestartMaxDelaySec over RestartSteps restarts
+func (sv *Unit) restartDelay() time.Duration {
+	min := sv.Definition.Service.RestartSec
+	max := sv.Definition.Service.RestartMaxDelaySec
+	steps := sv.Definition.Service.RestartSteps
+
+	if steps <= 0 || max <= min || max == unit.Infinity || min <= 0 {
+		return min
+	}
+
+	n := sv.nRestarts
+	if n >= steps {
+		return max
+	}
+
+	return time.Duration(float64(min) * math.Pow(float64(max)/float64(min), float64(n)/float64(steps)))
+}
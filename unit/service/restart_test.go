@@ -0,0 +1,162 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitResult(t *testing.T) {
+	assert.Equal(t, resultUnknown, exitResult(nil), "unknown exit status")
+
+	for cmd, expected := range map[string]string{
+		"exit 0":        resultSuccess,
+		"exit 3":        resultExitCode,
+		"kill -TERM $$": resultSuccess,
+		"kill -KILL $$": resultSignal,
+	} {
+		c := exec.Command("sh", "-c", cmd)
+		c.Run()
+		assert.Equal(t, expected, exitResult(exitStatusOf(c.ProcessState)), cmd)
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	sv := Unit{}
+	sv.Definition.Service.RestartSec = time.Second
+
+	assert.Equal(t, time.Second, sv.restartDelay(), "no backoff")
+
+	sv.Definition.Service.RestartSteps = 2
+	sv.Definition.Service.RestartMaxDelaySec = 4 * time.Second
+
+	for n, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		sv.nRestarts = n
+		assert.Equal(t, expected, sv.restartDelay(), "backoff")
+	}
+
+	sv.Definition.Service.RestartMaxDelaySec = unit.Infinity
+	assert.Equal(t, time.Second, sv.restartDelay(), "infinite RestartMaxDelaySec")
+}
+
+func TestAutoRestartUnknownStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-restart-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pidfile := filepath.Join(dir, "pid")
+
+	// Daemon process is not a child of the manager, so its exit status is unknown
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=forking
+ExecStart=/bin/sh -c '/bin/sleep 0.2 & echo $! > `+pidfile+`'
+PIDFile=`+pidfile+`
+Restart=on-failure
+RestartSec=10ms`)))
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+
+	select {
+	case <-sv.AutoRestart():
+		assert.Equal(t, resultUnknown, sv.Result())
+		assert.Nil(t, sv.ExitStatus())
+	case <-time.After(2 * time.Second):
+		t.Error("Service was not restarted after its exit status could not be determined")
+	}
+}
+
+func TestAutoRestart(t *testing.T) {
+	for policy, restarts := range map[string]bool{
+		"no":          false,
+		"on-success":  false,
+		"on-failure":  true,
+		"on-abnormal": false,
+		"always":      true,
+	} {
+		sv := Unit{}
+		sv.Definition.Service.Type = "simple"
+		sv.Definition.Service.Restart = policy
+		sv.Definition.Service.RestartSec = 10 * time.Millisecond
+		sv.Cmd = exec.Command("sh", "-c", "exit 1")
+
+		if !assert.NoError(t, sv.Start(), policy) {
+			continue
+		}
+
+		select {
+		case <-sv.AutoRestart():
+			assert.True(t, restarts, policy)
+			assert.Equal(t, 1, sv.NRestarts(), policy)
+			assert.Equal(t, resultExitCode, sv.Result(), policy)
+
+			// Service can be started again
+			assert.NoError(t, sv.Start(), policy)
+		case <-time.After(time.Second):
+			assert.False(t, restarts, policy)
+			assert.Zero(t, sv.NRestarts(), policy)
+		}
+	}
+
+	// Stopped services are not restarted
+	sv := Unit{}
+	sv.Definition.Service.Type = "simple"
+	sv.Definition.Service.Restart = "always"
+	sv.Definition.Service.RestartSec = time.Hour
+	sv.Cmd = exec.Command("sleep", "60")
+
+	if assert.NoError(t, sv.Start()) {
+		assert.NoError(t, sv.Stop())
+
+		time.Sleep(100 * time.Millisecond)
+		assert.NotEqual(t, autoRestart, sv.Sub())
+		assert.Zero(t, sv.NRestarts())
+	}
+}
+
+func TestNRestartsReset(t *testing.T) {
+	sv := &Unit{}
+	sv.Definition.Service.Type = "simple"
+	sv.Definition.Service.Restart = "on-failure"
+	sv.Definition.Service.RestartSec = 10 * time.Millisecond
+	sv.Cmd = exec.Command("sh", "-c", "exit 1")
+
+	restarted := func() bool {
+		select {
+		case <-sv.AutoRestart():
+			return true
+		case <-time.After(time.Second):
+			t.Error("Service was not restarted")
+			return false
+		}
+	}
+
+	// Automatic restarts are counted
+	require.NoError(t, sv.Start(), "sv.Start")
+	require.True(t, restarted())
+	require.NoError(t, sv.Start(), "sv.Start on restart")
+	require.True(t, restarted())
+	assert.Equal(t, 2, sv.NRestarts(), "automatic restarts")
+
+	sv.ResetFailed()
+	assert.Zero(t, sv.NRestarts(), "after sv.ResetFailed")
+
+	require.NoError(t, sv.Start(), "sv.Start on restart")
+	require.True(t, restarted())
+	assert.Equal(t, 1, sv.NRestarts(), "automatic restart after sv.ResetFailed")
+
+	// Explicit start of the stopped service restarts the count
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	sv.Cmd = exec.Command("sleep", "60")
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+	assert.Zero(t, sv.NRestarts(), "after explicit start")
+}
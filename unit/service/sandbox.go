@@ -0,0 +1,515 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+var protectSystemModes = map[string]bool{
+	"no":     true,
+	"yes":    true,
+	"full":   true,
+	"strict": true,
+}
+
+var protectHomeModes = map[string]bool{
+	"no":        true,
+	"yes":       true,
+	"read-only": true,
+	"tmpfs":     true,
+}
+
+// Paths made read-only by ProtectSystem= modes
+var protectSystemPaths = map[string][]string{
+	"yes":    {"/usr", "/boot", "/efi"},
+	"full":   {"/usr", "/boot", "/efi", "/etc"},
+	"strict": {"/"},
+}
+
+// Paths affected by ProtectHome=
+var protectHomePaths = []string{"/home", "/root", "/run/user"}
+
+// API file systems, which are not made read-only by ProtectSystem=strict
+var apiPaths = []string{"/dev", "/proc", "/sys"}
+
+// Device nodes available with PrivateDevices=
+var privateDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// Kinds of operations performed to set up the mount namespace of a process
+const (
+	mountBind         = "bind"
+	mountTmpfs        = "tmpfs"
+	mountSymlink      = "symlink"
+	mountReadOnly     = "read-only"
+	mountReadWrite    = "read-write"
+	mountInaccessible = "inaccessible"
+)
+
+// sandboxMount is an operation performed to set up the mount namespace of a process
+type sandboxMount struct {
+	Kind   string
+	Source string `json:",omitempty"`
+	Target string
+
+	// Mount flags and data of tmpfs mounts
+	Flags uintptr `json:",omitempty"`
+	Data  string  `json:",omitempty"`
+
+	// Whether bind mounts include submounts
+	Recursive bool `json:",omitempty"`
+
+	// Whether the operation is skipped if the source or target does not exist
+	Optional bool `json:",omitempty"`
+
+	// Mount points, which are not affected by read-only operations
+	Exclude []string `json:",omitempty"`
+}
+
+// sandboxConfig is sent to the sandbox helper, which sets up the namespaces and executes the command
+type sandboxConfig struct {
+	// Command to execute
+	Path string
+	Args []string
+
+	// Root and working directories, both optional
+	Root string `json:",omitempty"`
+	Dir  string `json:",omitempty"`
+
+	Mounts         []sandboxMount `json:",omitempty"`
+	PrivateNetwork bool           `json:",omitempty"`
+
+	// Credentials the command is run with, nil meaning those of the manager
+	Credential *syscall.Credential `json:",omitempty"`
+
+	// Capability and privilege settings, nil if not specified
+	Privileges *privileges `json:",omitempty"`
+
+	// Resource and scheduling settings, nil if not specified
+	Resources *resources `json:",omitempty"`
+
+	// Empty file with no permissions, which is mounted over inaccessible files
+	Inaccessible string `json:",omitempty"`
+
+	// Whether WATCHDOG_PID is set to the PID of the process
+	WatchdogPID bool `json:",omitempty"`
+}
+
+// usesSandbox reports whether any sandboxing options are specified in def
+func (def Definition) usesSandbox() bool {
+	svc := def.Service
+	return svc.PrivateTmp || svc.PrivateDevices || svc.PrivateNetwork ||
+		svc.ProtectSystem != "" && svc.ProtectSystem != "no" || svc.ProtectHome != "" && svc.ProtectHome != "no" ||
+		len(svc.ReadOnlyPaths) > 0 || len(svc.ReadWritePaths) > 0 || len(svc.InaccessiblePaths) > 0 ||
+		len(svc.BindPaths) > 0 || len(svc.BindReadOnlyPaths) > 0 || len(svc.TemporaryFileSystem) > 0 ||
+		svc.RootDirectory != ""
+}
+
+// checkSandbox returns errors found in the sandboxing options specified in def
+func checkSandbox(def Definition) (merr unit.MultiError) {
+	svc := def.Service
+
+	if svc.ProtectSystem != "" && !protectSystemModes[svc.ProtectSystem] {
+		merr = append(merr, unit.ParseErr("ProtectSystem", unit.ParseErr(svc.ProtectSystem, unit.ErrNotSupported)))
+	}
+	if svc.ProtectHome != "" && !protectHomeModes[svc.ProtectHome] {
+		merr = append(merr, unit.ParseErr("ProtectHome", unit.ParseErr(svc.ProtectHome, unit.ErrNotSupported)))
+	}
+	if svc.RootDirectory != "" && !filepath.IsAbs(svc.RootDirectory) {
+		merr = append(merr, unit.ParseErr("RootDirectory", unit.ParseErr(svc.RootDirectory, unit.ErrPathNotAbs)))
+	}
+
+	for name, lines := range map[string]unit.Lines{
+		"ReadOnlyPaths":     svc.ReadOnlyPaths,
+		"ReadWritePaths":    svc.ReadWritePaths,
+		"InaccessiblePaths": svc.InaccessiblePaths,
+	} {
+		for _, line := range lines {
+			for _, path := range strings.Fields(line) {
+				if _, p := sandboxPath(path); !filepath.IsAbs(p) {
+					merr = append(merr, unit.ParseErr(name, unit.ParseErr(path, unit.ErrPathNotAbs)))
+				}
+			}
+		}
+	}
+
+	for name, lines := range map[string]unit.Lines{
+		"BindPaths":         svc.BindPaths,
+		"BindReadOnlyPaths": svc.BindReadOnlyPaths,
+	} {
+		for _, line := range lines {
+			for _, spec := range strings.Fields(line) {
+				if _, err := parseBind(spec); err != nil {
+					merr = append(merr, unit.ParseErr(name, unit.ParseErr(spec, err)))
+				}
+			}
+		}
+	}
+
+	for _, line := range svc.TemporaryFileSystem {
+		for _, spec := range strings.Fields(line) {
+			if _, err := parseTmpfs(spec); err != nil {
+				merr = append(merr, unit.ParseErr("TemporaryFileSystem", unit.ParseErr(spec, err)))
+			}
+		}
+	}
+	return
+}
+
+// sandboxPath strips the prefixes from path and reports whether it is optional
+func sandboxPath(path string) (optional bool, p string) {
+	optional = strings.HasPrefix(path, "-")
+	path = strings.TrimPrefix(path, "-")
+
+	// Paths are always relative to RootDirectory
+	return optional, strings.TrimPrefix(path, "+")
+}
+
+// parseBind parses a value of BindPaths= or BindReadOnlyPaths= in "source[:destination[:options]]" format
+func parseBind(spec string) (m sandboxMount, err error) {
+	parts := strings.SplitN(spec, ":", 3)
+
+	m = sandboxMount{Kind: mountBind, Recursive: true}
+	m.Optional, m.Source = sandboxPath(parts[0])
+	m.Target = m.Source
+	if len(parts) > 1 {
+		m.Target = parts[1]
+	}
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "rbind":
+		case "norbind":
+			m.Recursive = false
+		default:
+			return m, unit.ErrNotSupported
+		}
+	}
+
+	if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Target) {
+		return m, unit.ErrPathNotAbs
+	}
+	return
+}
+
+// Flags of tmpfs mounts accepted by TemporaryFileSystem=
+var tmpfsFlags = map[string]uintptr{
+	"ro":     syscall.MS_RDONLY,
+	"nosuid": syscall.MS_NOSUID,
+	"nodev":  syscall.MS_NODEV,
+	"noexec": syscall.MS_NOEXEC,
+}
+
+// parseTmpfs parses a value of TemporaryFileSystem= in "path[:options]" format
+func parseTmpfs(spec string) (m sandboxMount, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+
+	m = sandboxMount{Kind: mountTmpfs, Target: parts[0], Flags: syscall.MS_NODEV | syscall.MS_STRICTATIME}
+	if !filepath.IsAbs(m.Target) {
+		return m, unit.ErrPathNotAbs
+	}
+
+	data := []string{"mode=0755"}
+	if len(parts) > 1 {
+		for _, opt := range strings.Split(parts[1], ",") {
+			if flag, ok := tmpfsFlags[opt]; ok {
+				m.Flags |= flag
+			} else if opt != "" {
+				data = append(data, opt)
+			}
+		}
+	}
+	m.Data = strings.Join(data, ",")
+	return
+}
+
+// prepareSandbox creates the directories shared by the processes of the service,
+// which are mounted in their namespaces
+func (sv *Unit) prepareSandbox() (err error) {
+	sv.cleanupSandbox()
+	if !sv.Definition.usesSandbox() {
+		return nil
+	}
+
+	if sv.runtimeDir, err = ioutil.TempDir("", "systemgo-private-"); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(sv.runtimeDir, "inaccessible"), nil, 0); err != nil {
+		return
+	}
+
+	if !sv.Definition.Service.PrivateTmp {
+		return nil
+	}
+
+	if err = mkdirSticky(filepath.Join(sv.runtimeDir, "tmp")); err != nil {
+		return
+	}
+
+	var dir string
+	if dir, err = ioutil.TempDir("/var/tmp", "systemgo-private-"); err != nil {
+		return
+	}
+	sv.varTmpDir = dir
+	return mkdirSticky(filepath.Join(dir, "tmp"))
+}
+
+func mkdirSticky(path string) error {
+	if err := os.Mkdir(path, 0777); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0777|os.ModeSticky)
+}
+
+// cleanupSandbox removes the directories created by prepareSandbox
+func (sv *Unit) cleanupSandbox() {
+	for _, dir := range []*string{&sv.runtimeDir, &sv.varTmpDir} {
+		if *dir != "" {
+			os.RemoveAll(*dir)
+			*dir = ""
+		}
+	}
+}
+
+// sandboxMounts returns the operations setting up the mount namespace of the processes
+// of the service. Paths made writable are not affected by read-only operations
+func (sv *Unit) sandboxMounts() (mounts []sandboxMount) {
+	svc := sv.Definition.Service
+	root := svc.RootDirectory
+
+	var readOnly, readWrite, inaccessible []sandboxMount
+	add := func(ms *[]sandboxMount, kind, path string) {
+		optional, p := sandboxPath(path)
+		*ms = append(*ms, sandboxMount{Kind: kind, Target: filepath.Join(root, p), Optional: optional})
+	}
+
+	for _, line := range svc.BindPaths {
+		for _, spec := range strings.Fields(line) {
+			m, _ := parseBind(spec)
+			m.Target = filepath.Join(root, m.Target)
+			mounts = append(mounts, m)
+		}
+	}
+	for _, line := range svc.BindReadOnlyPaths {
+		for _, spec := range strings.Fields(line) {
+			m, _ := parseBind(spec)
+			m.Target = filepath.Join(root, m.Target)
+			mounts = append(mounts, m)
+			readOnly = append(readOnly, sandboxMount{Kind: mountReadOnly, Target: m.Target, Optional: m.Optional})
+		}
+	}
+
+	for _, line := range svc.TemporaryFileSystem {
+		for _, spec := range strings.Fields(line) {
+			m, _ := parseTmpfs(spec)
+			m.Target = filepath.Join(root, m.Target)
+			mounts = append(mounts, m)
+		}
+	}
+
+	if svc.PrivateTmp {
+		mounts = append(mounts,
+			sandboxMount{Kind: mountBind, Source: filepath.Join(sv.runtimeDir, "tmp"), Target: filepath.Join(root, "/tmp")},
+			sandboxMount{Kind: mountBind, Source: filepath.Join(sv.varTmpDir, "tmp"), Target: filepath.Join(root, "/var/tmp")},
+		)
+	}
+
+	if svc.PrivateDevices {
+		dev := filepath.Join(root, "/dev")
+		mounts = append(mounts, sandboxMount{Kind: mountTmpfs, Target: dev, Flags: syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_STRICTATIME, Data: "mode=0755"})
+		for _, name := range privateDevices {
+			mounts = append(mounts, sandboxMount{Kind: mountBind, Source: filepath.Join("/dev", name), Target: filepath.Join(dev, name), Optional: true})
+		}
+		mounts = append(mounts,
+			sandboxMount{Kind: mountBind, Source: "/dev/pts", Target: filepath.Join(dev, "pts"), Optional: true},
+			sandboxMount{Kind: mountSymlink, Source: "pts/ptmx", Target: filepath.Join(dev, "ptmx")},
+			sandboxMount{Kind: mountTmpfs, Target: filepath.Join(dev, "shm"), Flags: syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_STRICTATIME, Data: "mode=1777"},
+			sandboxMount{Kind: mountSymlink, Source: "/proc/self/fd", Target: filepath.Join(dev, "fd")},
+			sandboxMount{Kind: mountSymlink, Source: "/proc/self/fd/0", Target: filepath.Join(dev, "stdin")},
+			sandboxMount{Kind: mountSymlink, Source: "/proc/self/fd/1", Target: filepath.Join(dev, "stdout")},
+			sandboxMount{Kind: mountSymlink, Source: "/proc/self/fd/2", Target: filepath.Join(dev, "stderr")},
+		)
+	}
+
+	for _, path := range protectSystemPaths[svc.ProtectSystem] {
+		add(&readOnly, mountReadOnly, "-"+path)
+	}
+
+	for _, path := range protectHomePaths {
+		switch svc.ProtectHome {
+		case "yes":
+			add(&inaccessible, mountInaccessible, "-"+path)
+		case "read-only":
+			add(&readOnly, mountReadOnly, "-"+path)
+		case "tmpfs":
+			if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+				mounts = append(mounts, sandboxMount{Kind: mountTmpfs, Target: filepath.Join(root, path),
+					Flags: syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_STRICTATIME, Data: "mode=0755"})
+			}
+		}
+	}
+
+	for _, line := range svc.ReadOnlyPaths {
+		for _, path := range strings.Fields(line) {
+			add(&readOnly, mountReadOnly, path)
+		}
+	}
+	for _, line := range svc.ReadWritePaths {
+		for _, path := range strings.Fields(line) {
+			add(&readWrite, mountReadWrite, path)
+		}
+	}
+	for _, line := range svc.InaccessiblePaths {
+		for _, path := range strings.Fields(line) {
+			add(&inaccessible, mountInaccessible, path)
+		}
+	}
+
+	// Managed directories are writable and are made available under RootDirectory
+	for _, k := range directoryKinds {
+		for _, path := range sv.directoryPaths(k.kind) {
+			if root == "" {
+				add(&readWrite, mountReadWrite, "-"+path)
+			} else {
+				mounts = append(mounts, sandboxMount{Kind: mountBind, Source: path, Target: filepath.Join(root, path), Recursive: true})
+			}
+		}
+	}
+
+	// Mounts set up above, which are writable, and API file systems stay writable
+	var writable []string
+	for _, m := range mounts {
+		if (m.Kind == mountBind || m.Kind == mountTmpfs) && m.Flags&syscall.MS_RDONLY == 0 && !isReadOnly(readOnly, m.Target) {
+			writable = append(writable, m.Target)
+		}
+	}
+	for _, path := range apiPaths {
+		writable = append(writable, filepath.Join(root, path))
+	}
+	for i := range readOnly {
+		readOnly[i].Exclude = writable
+	}
+
+	mounts = append(mounts, readOnly...)
+	mounts = append(mounts, readWrite...)
+	return append(mounts, inaccessible...)
+}
+
+func isReadOnly(readOnly []sandboxMount, path string) bool {
+	for _, m := range readOnly {
+		if m.Target == path {
+			return true
+		}
+	}
+	return false
+}
+
+// startCmd starts cmd running command c in the sandbox and with the privileges specified
+// in definition, unless c is run with full privileges, and with the resource and scheduling
+// settings applied. If main is set, cmd starts the main process, which is told its PID if the watchdog is enabled.
+// The settings are applied by the sandbox helper before the command is executed, failure to
+// apply them is reported before cmd is considered started
+func (sv *Unit) startCmd(cmd *exec.Cmd, c unit.ExecCommand, main bool) (err error) {
+	sandboxed := (sv.Definition.usesSandbox() || sv.privileges != nil) && !c.FullPrivileges
+	watchdog := main && len(sv.watchdogEnv()) > 0
+	if !sandboxed && !watchdog && sv.resources == nil {
+		return sv.spawn(cmd)
+	}
+
+	cfg := sandboxConfig{
+		Path:        cmd.Path,
+		Args:        cmd.Args,
+		Dir:         cmd.Dir,
+		Resources:   sv.resources,
+		WatchdogPID: watchdog,
+	}
+	if sandboxed {
+		cfg.Root = sv.Definition.Service.RootDirectory
+		cfg.Mounts = sv.sandboxMounts()
+		cfg.PrivateNetwork = sv.Definition.Service.PrivateNetwork
+		cfg.Privileges = sv.privileges
+	}
+	if sv.runtimeDir != "" {
+		cfg.Inaccessible = filepath.Join(sv.runtimeDir, "inaccessible")
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := *cmd.SysProcAttr
+	cfg.Credential = attr.Credential
+
+	var b []byte
+	if b, err = json.Marshal(cfg); err != nil {
+		return
+	}
+
+	// Configuration is sent over a socket, setup errors are sent back over it
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	conn, helperConn := os.NewFile(uintptr(fds[0]), "sandbox"), os.NewFile(uintptr(fds[1]), "sandbox")
+	defer conn.Close()
+
+	// The helper is started instead of the command and replaced by it once the sandbox is set up.
+	// Credentials are changed by the helper after setting up the mounts
+	path, args, dir, extra := cmd.Path, cmd.Args, cmd.Dir, cmd.ExtraFiles
+	cmd.Path, cmd.Args, cmd.Dir = "/proc/self/exe", []string{SANDBOX_HELPER, strconv.Itoa(3 + len(extra))}, ""
+	cmd.ExtraFiles = append(append([]*os.File{}, extra...), helperConn)
+	cmd.SysProcAttr.Credential = nil
+	if len(cfg.Mounts) > 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+	}
+	if cfg.PrivateNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if os.Geteuid() != 0 && sandboxed && sv.Definition.usesSandbox() {
+		// Unprivileged managers set up the namespaces as root of a new user namespace
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}}
+	}
+
+	err = sv.spawn(cmd)
+	helperConn.Close()
+
+	cmd.Path, cmd.Args, cmd.Dir, cmd.ExtraFiles = path, args, dir, extra
+	*cmd.SysProcAttr = attr
+	if err != nil {
+		return
+	}
+
+	// Configuration is written once the helper runs, since it may exceed the socket buffer
+	if _, err = conn.Write(b); err == nil {
+		err = syscall.Shutdown(int(conn.Fd()), syscall.SHUT_WR)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		sv.wait(cmd)
+		return
+	}
+
+	// The descriptor is closed on exec of the command, setup errors are written otherwise
+	msg, _ := ioutil.ReadAll(conn)
+	if len(msg) > 0 {
+		sv.wait(cmd)
+		return &SandboxError{string(msg)}
+	}
+	return nil
+}
+
+// SandboxError is returned when the sandbox of a process could not be set up
+type SandboxError struct {
+	Reason string
+}
+
+func (err *SandboxError) Error() string {
+	return "Failed to set up sandbox: " + err.Reason
+}
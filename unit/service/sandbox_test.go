@@ -0,0 +1,225 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	// Test binary is re-executed as the sandbox helper
+	RunSandboxHelper()
+	os.Exit(m.Run())
+}
+
+func TestParseBind(t *testing.T) {
+	for spec, expected := range map[string]sandboxMount{
+		"/a":             {Kind: mountBind, Source: "/a", Target: "/a", Recursive: true},
+		"-/a:/b":         {Kind: mountBind, Source: "/a", Target: "/b", Recursive: true, Optional: true},
+		"/a:/b:norbind":  {Kind: mountBind, Source: "/a", Target: "/b"},
+		"/a:/b:rbind":    {Kind: mountBind, Source: "/a", Target: "/b", Recursive: true},
+		"+/a:/b:rbind":   {Kind: mountBind, Source: "/a", Target: "/b", Recursive: true},
+		"-+/a:/b:rbind":  {Kind: mountBind, Source: "/a", Target: "/b", Recursive: true, Optional: true},
+		"/a/b/c:/d/e/f:": {},
+	} {
+		m, err := parseBind(spec)
+		if expected.Kind == "" {
+			assert.Error(t, err, spec)
+			continue
+		}
+		if assert.NoError(t, err, spec) {
+			assert.Equal(t, expected, m, spec)
+		}
+	}
+
+	for _, spec := range []string{"a", "/a:b", "/a:/b:foo"} {
+		_, err := parseBind(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestDefineSandbox(t *testing.T) {
+	for option, value := range map[string]string{
+		"ProtectSystem":       "maybe",
+		"ProtectHome":         "partially",
+		"ReadOnlyPaths":       "usr",
+		"InaccessiblePaths":   "-home",
+		"BindPaths":           "/a:b",
+		"TemporaryFileSystem": "tmp",
+		"RootDirectory":       "root",
+	} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+}
+
+// runSandboxed runs script in a oneshot service with the sandboxing options
+// specified and returns its output
+func runSandboxed(t *testing.T, options, script string) (string, error) {
+	dir, err := ioutil.TempDir("", "systemgo-sandbox-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c '`+script+`'
+
+StandardOutput=file:`+out+`
+StandardError=inherit
+`+options)), "sv.Define")
+
+	err = sv.Start()
+
+	b, _ := ioutil.ReadFile(out)
+	return string(b), err
+}
+
+func TestSandbox(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Must be run as root")
+	}
+
+	dir, err := ioutil.TempDir("", "systemgo-sandbox-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"ro", "rw", "hidden", "src"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hidden", "secret"), []byte("secret"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "src", "file"), []byte("bound"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644))
+
+	for _, test := range []struct {
+		name, options, script, expected string
+	}{
+		{
+			"PrivateTmp",
+			"PrivateTmp=yes",
+			"touch /tmp/private-test /var/tmp/private-test && ls /tmp /var/tmp",
+			"/tmp:\nprivate-test\n\n/var/tmp:\nprivate-test\n",
+		},
+		{
+			"ReadOnlyPaths",
+			"ReadOnlyPaths=" + dir + "\nReadWritePaths=" + filepath.Join(dir, "rw"),
+			"touch " + filepath.Join(dir, "ro", "f") + " 2>/dev/null || echo ro; touch " + filepath.Join(dir, "rw", "f") + " && echo rw",
+			"ro\nrw\n",
+		},
+		{
+			"ProtectSystem",
+			"ProtectSystem=strict\nReadWritePaths=" + filepath.Join(dir, "rw"),
+			"touch /etc/systemgo-test 2>/dev/null || echo ro; touch " + filepath.Join(dir, "rw", "g") + " && echo rw",
+			"ro\nrw\n",
+		},
+		{
+			"ProtectSystem with PrivateTmp",
+			"ProtectSystem=strict\nPrivateTmp=yes",
+			"touch /etc/systemgo-test 2>/dev/null || echo ro; touch /tmp/f /var/tmp/f && echo rw",
+			"ro\nrw\n",
+		},
+		{
+			"InaccessiblePaths",
+			"InaccessiblePaths=" + filepath.Join(dir, "hidden") + " " + filepath.Join(dir, "secret") + " -/nonexistent",
+			"ls " + filepath.Join(dir, "hidden") + "; cat " + filepath.Join(dir, "secret") + "; echo done",
+			"done\n",
+		},
+		{
+			"BindPaths",
+			"BindReadOnlyPaths=" + filepath.Join(dir, "src") + ":" + filepath.Join(dir, "ro", "dst") + "\nBindPaths=-/nonexistent",
+			"cat " + filepath.Join(dir, "ro", "dst", "file") + "; touch " + filepath.Join(dir, "ro", "dst", "new") + " 2>/dev/null || echo ro",
+			"boundro\n",
+		},
+		{
+			"TemporaryFileSystem",
+			"TemporaryFileSystem=" + filepath.Join(dir, "hidden") + ":mode=0700",
+			"ls " + filepath.Join(dir, "hidden") + "; stat -c %a " + filepath.Join(dir, "hidden"),
+			"700\n",
+		},
+		{
+			"PrivateDevices",
+			"PrivateDevices=yes",
+			"ls /dev | grep -v pts; echo test >/dev/null && echo ok; mknod /tmp/systemgo-test-null c 1 3 2>/dev/null || echo denied",
+			"fd\nfull\nnull\nptmx\nrandom\nshm\nstderr\nstdin\nstdout\ntty\nurandom\nzero\nok\ndenied\n",
+		},
+		{
+			"PrivateNetwork",
+			"PrivateNetwork=yes",
+			"tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d \" \"",
+			"lo\n",
+		},
+	} {
+		out, err := runSandboxed(t, test.options, test.script)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, out, test.name)
+		}
+	}
+
+	// Mounts do not propagate to the manager
+	_, err = os.Stat("/tmp/private-test")
+	assert.True(t, os.IsNotExist(err), "private /tmp")
+	_, err = os.Stat(filepath.Join(dir, "ro", "f"))
+	assert.True(t, os.IsNotExist(err), "read-only path")
+	b, err := ioutil.ReadFile(filepath.Join(dir, "secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(b), "inaccessible path")
+}
+
+func TestSandboxRootDirectory(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Must be run as root")
+	}
+
+	root, err := ioutil.TempDir("", "systemgo-root-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "marker"), []byte("root"), 0644))
+
+	var binds []string
+	for _, path := range []string{"/bin", "/lib", "/lib64", "/usr", "/tmp"} {
+		binds = append(binds, "-"+path)
+	}
+
+	dir, err := ioutil.TempDir("", "systemgo-sandbox-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out, err := runSandboxed(t, "RootDirectory="+root+"\nBindReadOnlyPaths="+strings.Join(binds, " ")+"\nBindPaths="+dir+":/work\nWorkingDirectory=/work", "cat /marker; echo; pwd")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "root\n/work\n", out)
+	}
+}
+
+func TestSandboxFailure(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Must be run as root")
+	}
+
+	_, err := runSandboxed(t, "BindPaths=/nonexistent", "echo started")
+	if assert.IsType(t, &SandboxError{}, err) {
+		assert.Contains(t, err.Error(), "/nonexistent")
+	}
+
+	// Commands run with full privileges are not sandboxed
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=+/bin/true
+BindPaths=/nonexistent`)), "sv.Define")
+	assert.NoError(t, sv.Start(), "full privileges")
+}
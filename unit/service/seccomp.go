@@ -0,0 +1,486 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// Return values of seccomp filters (see seccomp(2))
+const (
+	SECCOMP_RET_KILL_PROCESS = 0x80000000
+	SECCOMP_RET_ERRNO        = 0x00050000
+	SECCOMP_RET_ALLOW        = 0x7fff0000
+)
+
+// Filter mode of PR_SET_SECCOMP
+const SECCOMP_MODE_FILTER = 2
+
+// Offsets of the fields of struct seccomp_data
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// Permission bits denied by RestrictSUIDSGID=
+const suidsgidBits = syscall.S_ISUID | syscall.S_ISGID
+
+// argCond is satisfied if any of the bits in Mask are set in the lower 32 bits of argument Arg
+type argCond struct {
+	Arg  int
+	Mask uint32
+}
+
+// seccompRule specifies the action taken on calls of system call Name, which satisfy all Conds
+type seccompRule struct {
+	Name   string
+	Conds  []argCond
+	Action uint32
+}
+
+// Rules denying the creation of set-user-ID and set-group-ID files
+var restrictSUIDSGIDRules = []seccompRule{
+	{"chmod", []argCond{{1, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"fchmod", []argCond{{1, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"fchmodat", []argCond{{2, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"fchmodat2", []argCond{{2, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"creat", []argCond{{1, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"open", []argCond{{1, syscall.O_CREAT}, {2, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"openat", []argCond{{2, syscall.O_CREAT}, {3, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"mknod", []argCond{{1, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+	{"mknodat", []argCond{{2, suidsgidBits}}, SECCOMP_RET_ERRNO | uint32(syscall.EPERM)},
+}
+
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// compileFilter compiles rules into a seccomp BPF program, which takes the action of the
+// first matching rule and defaultAction if none match. System calls of architectures other
+// than the native one are subject to foreignAction. Rules of system calls unknown to the
+// architecture are skipped
+func compileFilter(rules []seccompRule, defaultAction, foreignAction uint32) (prog []syscall.SockFilter) {
+	prog = []syscall.SockFilter{
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nativeArch, 1, 0),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, foreignAction),
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr),
+	}
+	if x32SyscallBit != 0 {
+		prog = append(prog,
+			bpfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32SyscallBit, 0, 1),
+			bpfStmt(syscall.BPF_RET|syscall.BPF_K, foreignAction),
+		)
+	}
+
+	for _, rule := range rules {
+		nr, ok := syscallNumbers[rule.Name]
+		if !ok {
+			continue
+		}
+
+		// On mismatch, jump past the action to the next rule. Conditions load the
+		// arguments, so the system call number is reloaded after them
+		n := len(rule.Conds)
+		prog = append(prog, bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(nr), 0, uint8(2*n+1)))
+		for i, cond := range rule.Conds {
+			prog = append(prog,
+				bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, uint32(seccompDataArgs+8*cond.Arg)),
+				bpfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, cond.Mask, 0, uint8(2*(n-i-1)+1)),
+			)
+		}
+		prog = append(prog, bpfStmt(syscall.BPF_RET|syscall.BPF_K, rule.Action))
+		if n > 0 {
+			prog = append(prog, bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr))
+		}
+	}
+
+	return append(prog, bpfStmt(syscall.BPF_RET|syscall.BPF_K, defaultAction))
+}
+
+// installFilter installs the seccomp filter prog in the calling thread
+func installFilter(prog []syscall.SockFilter) error {
+	fprog := syscall.SockFprog{
+		Len:    uint16(len(prog)),
+		Filter: &prog[0],
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Groups of system calls, which can be referred to by SystemCallFilter= (see systemd.exec(5)).
+// Names prefixed by "@" refer to other groups
+var syscallGroups = map[string][]string{
+	"@aio": {
+		"io_cancel", "io_destroy", "io_getevents", "io_pgetevents", "io_pgetevents_time64",
+		"io_setup", "io_submit", "io_uring_enter", "io_uring_register", "io_uring_setup",
+	},
+	"@basic-io": {
+		"_llseek", "close", "close_range", "dup", "dup2", "dup3", "lseek", "pread64", "preadv",
+		"preadv2", "pwrite64", "pwritev", "pwritev2", "read", "readv", "write", "writev",
+	},
+	"@chown": {
+		"chown", "chown32", "fchown", "fchown32", "fchownat", "lchown", "lchown32",
+	},
+	"@clock": {
+		"adjtimex", "clock_adjtime", "clock_adjtime64", "clock_settime", "clock_settime64",
+		"settimeofday", "stime",
+	},
+	"@cpu-emulation": {
+		"modify_ldt", "subpage_prot", "switch_endian", "vm86", "vm86old",
+	},
+	"@debug": {
+		"lookup_dcookie", "perf_event_open", "pidfd_getfd", "ptrace", "rtas",
+		"s390_runtime_instr", "sys_debug_setcontext",
+	},
+	"@default": {
+		"arch_prctl", "brk", "cacheflush", "clock_getres", "clock_getres_time64", "clock_gettime",
+		"clock_gettime64", "clock_nanosleep", "clock_nanosleep_time64", "execve", "exit",
+		"exit_group", "futex", "futex_time64", "futex_waitv", "get_robust_list", "get_thread_area",
+		"getegid", "getegid32", "geteuid", "geteuid32", "getgid", "getgid32", "getgroups",
+		"getgroups32", "getpgid", "getpgrp", "getpid", "getppid", "getrandom", "getresgid",
+		"getresgid32", "getresuid", "getresuid32", "getrlimit", "getsid", "gettid", "gettimeofday",
+		"getuid", "getuid32", "membarrier", "mmap", "mmap2", "mprotect", "munmap", "nanosleep",
+		"pause", "prlimit64", "restart_syscall", "rseq", "rt_sigreturn", "sched_getaffinity",
+		"sched_yield", "set_robust_list", "set_thread_area", "set_tid_address", "set_tls",
+		"sigreturn", "time", "ugetrlimit",
+	},
+	"@file-system": {
+		"access", "chdir", "chmod", "close", "creat", "faccessat", "faccessat2", "fallocate",
+		"fchdir", "fchmod", "fchmodat", "fchmodat2", "fcntl", "fcntl64", "fgetxattr", "flistxattr",
+		"fremovexattr", "fsetxattr", "fstat", "fstat64", "fstatat64", "fstatfs", "fstatfs64",
+		"ftruncate", "ftruncate64", "futimesat", "getcwd", "getdents", "getdents64", "getxattr",
+		"inotify_add_watch", "inotify_init", "inotify_init1", "inotify_rm_watch", "lgetxattr",
+		"link", "linkat", "listxattr", "llistxattr", "lremovexattr", "lsetxattr", "lstat",
+		"lstat64", "mkdir", "mkdirat", "mknod", "mknodat", "munmap", "newfstatat", "oldfstat",
+		"oldlstat", "oldstat", "open", "openat", "openat2", "readlink", "readlinkat",
+		"removexattr", "rename", "renameat", "renameat2", "rmdir", "setxattr", "stat", "stat64",
+		"statfs", "statfs64", "statx", "symlink", "symlinkat", "truncate", "truncate64", "unlink",
+		"unlinkat", "utime", "utimensat", "utimensat_time64", "utimes",
+	},
+	"@io-event": {
+		"_newselect", "epoll_create", "epoll_create1", "epoll_ctl", "epoll_ctl_old", "epoll_pwait",
+		"epoll_pwait2", "epoll_wait", "epoll_wait_old", "eventfd", "eventfd2", "poll", "ppoll",
+		"ppoll_time64", "pselect6", "pselect6_time64", "select",
+	},
+	"@ipc": {
+		"ipc", "memfd_create", "mq_getsetattr", "mq_notify", "mq_open", "mq_timedreceive",
+		"mq_timedreceive_time64", "mq_timedsend", "mq_timedsend_time64", "mq_unlink", "msgctl",
+		"msgget", "msgrcv", "msgsnd", "pipe", "pipe2", "process_madvise", "process_vm_readv",
+		"process_vm_writev", "semctl", "semget", "semop", "semtimedop", "semtimedop_time64",
+		"shmat", "shmctl", "shmdt", "shmget",
+	},
+	"@keyring": {
+		"add_key", "keyctl", "request_key",
+	},
+	"@memlock": {
+		"mlock", "mlock2", "mlockall", "munlock", "munlockall",
+	},
+	"@module": {
+		"delete_module", "finit_module", "init_module",
+	},
+	"@mount": {
+		"chroot", "fsconfig", "fsmount", "fsopen", "fspick", "listmount", "mount", "mount_setattr",
+		"move_mount", "open_tree", "pivot_root", "statmount", "umount", "umount2",
+	},
+	"@network-io": {
+		"accept", "accept4", "bind", "connect", "getpeername", "getsockname", "getsockopt",
+		"listen", "recv", "recvfrom", "recvmmsg", "recvmmsg_time64", "recvmsg", "send",
+		"sendmmsg", "sendmsg", "sendto", "setsockopt", "shutdown", "socket", "socketcall",
+		"socketpair",
+	},
+	"@obsolete": {
+		"_sysctl", "afs_syscall", "bdflush", "break", "create_module", "ftime", "get_kernel_syms",
+		"getpmsg", "gtty", "idle", "lock", "mpx", "prof", "profil", "putpmsg", "query_module",
+		"security", "sgetmask", "ssetmask", "stty", "sysfs", "tuxcall", "ulimit", "uselib", "ustat",
+		"vserver",
+	},
+	"@privileged": {
+		"@chown", "@clock", "@module", "@raw-io", "@reboot", "@swap", "_sysctl", "acct", "bpf",
+		"capset", "chroot", "fanotify_init", "fanotify_mark", "nfsservctl", "open_by_handle_at",
+		"pivot_root", "quotactl", "quotactl_fd", "setdomainname", "setfsuid", "setfsuid32",
+		"setgroups", "setgroups32", "sethostname", "setresuid", "setresuid32", "setreuid",
+		"setreuid32", "setuid", "setuid32", "vhangup",
+	},
+	"@process": {
+		"capget", "clone", "clone3", "execveat", "fork", "getrusage", "kill", "pidfd_open",
+		"pidfd_send_signal", "prctl", "rt_sigqueueinfo", "rt_tgsigqueueinfo", "setns",
+		"swapcontext", "tgkill", "times", "tkill", "unshare", "vfork", "wait4", "waitid", "waitpid",
+	},
+	"@raw-io": {
+		"ioperm", "iopl", "pciconfig_iobase", "pciconfig_read", "pciconfig_write",
+		"s390_pci_mmio_read", "s390_pci_mmio_write",
+	},
+	"@reboot": {
+		"kexec_file_load", "kexec_load", "reboot",
+	},
+	"@resources": {
+		"ioprio_set", "mbind", "migrate_pages", "move_pages", "nice", "sched_setaffinity",
+		"sched_setattr", "sched_setparam", "sched_setscheduler", "set_mempolicy",
+		"set_mempolicy_home_node", "setpriority", "setrlimit",
+	},
+	"@setuid": {
+		"setgid", "setgid32", "setgroups", "setgroups32", "setregid", "setregid32", "setresgid",
+		"setresgid32", "setresuid", "setresuid32", "setreuid", "setreuid32", "setuid", "setuid32",
+	},
+	"@signal": {
+		"rt_sigaction", "rt_sigpending", "rt_sigprocmask", "rt_sigsuspend", "rt_sigtimedwait",
+		"rt_sigtimedwait_time64", "sigaction", "sigaltstack", "signal", "signalfd", "signalfd4",
+		"sigpending", "sigprocmask", "sigsuspend",
+	},
+	"@swap": {
+		"swapoff", "swapon",
+	},
+	"@sync": {
+		"fdatasync", "fsync", "msync", "sync", "sync_file_range", "sync_file_range2", "syncfs",
+	},
+	"@system-service": {
+		"@aio", "@basic-io", "@chown", "@default", "@file-system", "@io-event", "@ipc", "@keyring",
+		"@memlock", "@network-io", "@process", "@resources", "@setuid", "@signal", "@sync",
+		"@timer", "arm_fadvise64_64", "capget", "capset", "copy_file_range", "fadvise64",
+		"fadvise64_64", "flock", "get_mempolicy", "getcpu", "getpriority", "ioctl", "ioprio_get",
+		"kcmp", "madvise", "mremap", "name_to_handle_at", "oldolduname", "olduname", "personality",
+		"readahead", "readdir", "remap_file_pages", "sched_get_priority_max",
+		"sched_get_priority_min", "sched_getattr", "sched_getparam", "sched_getscheduler",
+		"sched_rr_get_interval", "sched_rr_get_interval_time64", "sendfile", "sendfile64",
+		"setfsgid", "setfsgid32", "setfsuid", "setfsuid32", "setpgid", "setsid", "splice",
+		"sysinfo", "tee", "umask", "uname", "userfaultfd", "vmsplice",
+	},
+	"@timer": {
+		"alarm", "getitimer", "setitimer", "timer_create", "timer_delete", "timer_getoverrun",
+		"timer_gettime", "timer_gettime64", "timer_settime", "timer_settime64", "timerfd_create",
+		"timerfd_gettime", "timerfd_gettime64", "timerfd_settime", "timerfd_settime64", "times",
+	},
+}
+
+// System calls always allowed by allow lists, since they are needed to execute the command
+// and to exit
+var alwaysAllowedSyscalls = []string{
+	"execve", "exit", "exit_group", "getrlimit", "rt_sigreturn", "sigreturn",
+}
+
+// Error numbers SystemCallErrorNumber= and SystemCallFilter= accept by name
+var errnoNames = map[string]syscall.Errno{
+	"EPERM":        syscall.EPERM,
+	"ENOENT":       syscall.ENOENT,
+	"ESRCH":        syscall.ESRCH,
+	"EINTR":        syscall.EINTR,
+	"EIO":          syscall.EIO,
+	"ENXIO":        syscall.ENXIO,
+	"E2BIG":        syscall.E2BIG,
+	"EBADF":        syscall.EBADF,
+	"EAGAIN":       syscall.EAGAIN,
+	"ENOMEM":       syscall.ENOMEM,
+	"EACCES":       syscall.EACCES,
+	"EFAULT":       syscall.EFAULT,
+	"EBUSY":        syscall.EBUSY,
+	"EEXIST":       syscall.EEXIST,
+	"ENODEV":       syscall.ENODEV,
+	"ENOTDIR":      syscall.ENOTDIR,
+	"EISDIR":       syscall.EISDIR,
+	"EINVAL":       syscall.EINVAL,
+	"ENFILE":       syscall.ENFILE,
+	"EMFILE":       syscall.EMFILE,
+	"ENOTTY":       syscall.ENOTTY,
+	"ENOSPC":       syscall.ENOSPC,
+	"EROFS":        syscall.EROFS,
+	"ENOSYS":       syscall.ENOSYS,
+	"EOPNOTSUPP":   syscall.EOPNOTSUPP,
+	"ENOTSUP":      syscall.ENOTSUP,
+	"EAFNOSUPPORT": syscall.EAFNOSUPPORT,
+	"ECONNREFUSED": syscall.ECONNREFUSED,
+}
+
+// Maximum error number a filter can return
+const MAX_ERRNO = 4095
+
+// syscallFilter is a parsed system call filter
+type syscallFilter struct {
+	rules                        []seccompRule
+	defaultAction, foreignAction uint32
+}
+
+// parseSyscallFilter parses SystemCallFilter=, SystemCallErrorNumber= and SystemCallArchitectures=
+// specified in def. The first line of SystemCallFilter= determines whether the filter is an allow
+// list or, if prefixed by "~", a deny list. Allow lists implicitly include @default. Subsequent lines
+// of the other kind remove system calls from the list. System calls of other architectures are denied,
+// if a filter is specified
+func parseSyscallFilter(def Definition) (f syscallFilter, merr unit.MultiError) {
+	f.defaultAction, f.foreignAction = SECCOMP_RET_ALLOW, SECCOMP_RET_ALLOW
+	svc := def.Service
+
+	if nativeArch == 0 {
+		for name, set := range map[string]bool{
+			"SystemCallFilter":        len(svc.SystemCallFilter) > 0,
+			"SystemCallArchitectures": len(svc.SystemCallArchitectures) > 0,
+		} {
+			if set {
+				merr = append(merr, unit.ParseErr(name, unit.ErrNotSupported))
+			}
+		}
+		return
+	}
+
+	denyAction := uint32(SECCOMP_RET_KILL_PROCESS)
+	if svc.SystemCallErrorNumber != "" {
+		action, err := parseErrnoAction(svc.SystemCallErrorNumber)
+		if err != nil {
+			merr = append(merr, unit.ParseErr("SystemCallErrorNumber", unit.ParseErr(svc.SystemCallErrorNumber, err)))
+		}
+		denyAction = action
+	}
+
+	for _, line := range svc.SystemCallArchitectures {
+		for _, arch := range strings.Fields(line) {
+			if arch != "native" {
+				merr = append(merr, unit.ParseErr("SystemCallArchitectures", unit.ParseErr(arch, unit.ErrNotSupported)))
+			}
+		}
+		f.foreignAction = SECCOMP_RET_KILL_PROCESS
+	}
+
+	// System calls listed mapped to the actions taken
+	listed := map[string]uint32{}
+	allow := false
+	for i, line := range svc.SystemCallFilter {
+		invert := strings.HasPrefix(line, "~")
+		if invert {
+			line = line[1:]
+		}
+		if i == 0 {
+			allow = !invert
+			if allow {
+				// System calls needed by any program, e.g. by the dynamic loader, are allowed
+				names, _ := expandSyscall("@default")
+				for _, name := range names {
+					listed[name] = SECCOMP_RET_ALLOW
+				}
+			}
+		}
+
+		for _, entry := range strings.Fields(line) {
+			action := denyAction
+			if allow {
+				action = SECCOMP_RET_ALLOW
+			}
+
+			name := entry
+			if j := strings.IndexByte(entry, ':'); j >= 0 {
+				var err error
+				name = entry[:j]
+				// Error numbers only apply to system calls denied
+				if action, err = parseErrnoAction(entry[j+1:]); err != nil || allow && !invert {
+					merr = append(merr, unit.ParseErr("SystemCallFilter", unit.ParseErr(entry, unit.ErrWrongVal)))
+					continue
+				}
+			}
+
+			names, err := expandSyscall(name)
+			if err != nil {
+				merr = append(merr, unit.ParseErr("SystemCallFilter", unit.ParseErr(name, err)))
+				continue
+			}
+			for _, name := range names {
+				if invert == allow {
+					delete(listed, name)
+				} else {
+					listed[name] = action
+				}
+			}
+		}
+	}
+
+	if len(svc.SystemCallFilter) == 0 {
+		return
+	}
+
+	// Rules match the system call numbers of the native architecture only, so calls
+	// of other architectures and x32 calls, which would bypass them, are denied
+	if f.foreignAction == SECCOMP_RET_ALLOW {
+		f.foreignAction = denyAction
+	}
+
+	if allow {
+		for _, name := range alwaysAllowedSyscalls {
+			listed[name] = SECCOMP_RET_ALLOW
+		}
+		f.defaultAction = denyAction
+	}
+
+	names := make([]string, 0, len(listed))
+	for name := range listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f.rules = append(f.rules, seccompRule{Name: name, Action: listed[name]})
+	}
+	return
+}
+
+// expandSyscall returns the system calls referred to by name of a system call or a group
+func expandSyscall(name string) ([]string, error) {
+	if !strings.HasPrefix(name, "@") {
+		if !isKnownSyscall(name) {
+			return nil, ErrUnknownSyscall
+		}
+		return []string{name}, nil
+	}
+
+	group, ok := syscallGroups[name]
+	if !ok {
+		return nil, ErrUnknownSyscall
+	}
+
+	var names []string
+	for _, member := range group {
+		if strings.HasPrefix(member, "@") {
+			expanded, _ := expandSyscall(member)
+			names = append(names, expanded...)
+		} else {
+			names = append(names, member)
+		}
+	}
+	return names, nil
+}
+
+// isKnownSyscall reports whether name is a system call of the native architecture or a member
+// of a group, which may be specific to other architectures
+func isKnownSyscall(name string) bool {
+	if _, ok := syscallNumbers[name]; ok {
+		return true
+	}
+	for _, group := range syscallGroups {
+		for _, member := range group {
+			if member == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseErrnoAction parses an error number specified by name or number, or "kill"
+func parseErrnoAction(s string) (uint32, error) {
+	if s == "kill" {
+		return SECCOMP_RET_KILL_PROCESS, nil
+	}
+	if errno, ok := errnoNames[s]; ok {
+		return SECCOMP_RET_ERRNO | uint32(errno), nil
+	}
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil && n <= MAX_ERRNO {
+		return SECCOMP_RET_ERRNO | uint32(n), nil
+	}
+	return 0, unit.ErrWrongVal
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyscallFilter(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("System call filtering is not supported")
+	}
+
+	def := Definition{}
+	def.Service.SystemCallFilter = unit.Lines{"@sync uname", "~fsync"}
+	def.Service.SystemCallErrorNumber = "EPERM"
+
+	f, merr := parseSyscallFilter(def)
+	require.Empty(t, merr)
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|uint32(syscall.EPERM)), f.defaultAction)
+	assert.Equal(t, uint32(SECCOMP_RET_ERRNO|uint32(syscall.EPERM)), f.foreignAction)
+
+	allowed := map[string]bool{}
+	for _, rule := range f.rules {
+		assert.Equal(t, uint32(SECCOMP_RET_ALLOW), rule.Action, rule.Name)
+		allowed[rule.Name] = true
+	}
+	for _, name := range []string{"uname", "fdatasync", "syncfs", "execve", "exit_group", "mmap", "brk", "set_tid_address"} {
+		assert.True(t, allowed[name], name)
+	}
+	assert.False(t, allowed["fsync"])
+
+	def = Definition{}
+	def.Service.SystemCallFilter = unit.Lines{"~@reboot uname:ENOSYS", "reboot"}
+	def.Service.SystemCallArchitectures = unit.Lines{"native"}
+
+	f, merr = parseSyscallFilter(def)
+	require.Empty(t, merr)
+	assert.Equal(t, uint32(SECCOMP_RET_ALLOW), f.defaultAction)
+	assert.Equal(t, uint32(SECCOMP_RET_KILL_PROCESS), f.foreignAction)
+
+	denied := map[string]uint32{}
+	for _, rule := range f.rules {
+		denied[rule.Name] = rule.Action
+	}
+	assert.Equal(t, map[string]uint32{
+		"kexec_file_load": SECCOMP_RET_KILL_PROCESS,
+		"kexec_load":      SECCOMP_RET_KILL_PROCESS,
+		"uname":           SECCOMP_RET_ERRNO | uint32(syscall.ENOSYS),
+	}, denied)
+}
+
+// runFilter runs the seccomp program prog on a call of system call nr of architecture arch
+// with arguments specified and returns the action taken
+func runFilter(prog []syscall.SockFilter, arch, nr uint32, args ...uint32) uint32 {
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			switch off := int(ins.K); {
+			case off == seccompDataNr:
+				acc = nr
+			case off == seccompDataArch:
+				acc = arch
+			case off >= seccompDataArgs && (off-seccompDataArgs)/8 < len(args):
+				acc = args[(off-seccompDataArgs)/8]
+			default:
+				acc = 0
+			}
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K:
+			pc += jump(ins, acc == ins.K)
+		case syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+			pc += jump(ins, acc >= ins.K)
+		case syscall.BPF_JMP | syscall.BPF_JSET | syscall.BPF_K:
+			pc += jump(ins, acc&ins.K != 0)
+		case syscall.BPF_RET | syscall.BPF_K:
+			return ins.K
+		default:
+			panic("Unknown instruction")
+		}
+	}
+	panic("Program did not return")
+}
+
+func jump(ins syscall.SockFilter, cond bool) int {
+	if cond {
+		return int(ins.Jt)
+	}
+	return int(ins.Jf)
+}
+
+func TestCompileFilterForeign(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("System call filtering is not supported")
+	}
+
+	const foreignArch = 0x40000003 // AUDIT_ARCH_I386
+	nr := uint32(syscallNumbers["chroot"])
+
+	for _, filter := range []string{"~chroot", "@system-service"} {
+		def := Definition{}
+		def.Service.SystemCallFilter = unit.Lines{filter}
+
+		f, merr := parseSyscallFilter(def)
+		require.Empty(t, merr, filter)
+		prog := compileFilter(f.rules, f.defaultAction, f.foreignAction)
+
+		assert.NotEqual(t, uint32(SECCOMP_RET_ALLOW), runFilter(prog, nativeArch, nr), filter)
+		assert.NotEqual(t, uint32(SECCOMP_RET_ALLOW), runFilter(prog, foreignArch, nr), filter+" of foreign architecture")
+		if x32SyscallBit != 0 {
+			assert.NotEqual(t, uint32(SECCOMP_RET_ALLOW), runFilter(prog, nativeArch, nr|x32SyscallBit), filter+" of x32 ABI")
+		}
+		assert.Equal(t, uint32(SECCOMP_RET_ALLOW), runFilter(prog, nativeArch, uint32(syscallNumbers["read"])), filter)
+	}
+}
+
+func TestRestrictSUIDSGIDForeign(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("System call filtering is not supported")
+	}
+
+	const foreignArch = 0x40000003 // AUDIT_ARCH_I386
+	nr := uint32(syscallNumbers["chmod"])
+
+	def := Definition{}
+	def.Service.RestrictSUIDSGID = true
+	p, merr := parsePrivileges(def)
+	require.Empty(t, merr)
+	require.NotNil(t, p)
+
+	assert.NotEqual(t, uint32(SECCOMP_RET_ALLOW), runFilter(p.Filter, nativeArch, nr, 0, syscall.S_ISUID))
+	assert.Equal(t, uint32(SECCOMP_RET_ALLOW), runFilter(p.Filter, nativeArch, nr, 0, 0755))
+	assert.NotEqual(t, uint32(SECCOMP_RET_ALLOW), runFilter(p.Filter, foreignArch, nr, 0, syscall.S_ISUID), "foreign architecture")
+	if x32SyscallBit != 0 {
+		assert.NotEqual(t, uint32(SECCOMP_RET_ALLOW), runFilter(p.Filter, nativeArch, nr|x32SyscallBit, 0, syscall.S_ISUID), "x32 ABI")
+	}
+}
+
+func TestDefineSyscallFilter(t *testing.T) {
+	if nativeArch == 0 {
+		t.Skip("System call filtering is not supported")
+	}
+
+	for option, value := range map[string]string{
+		"SystemCallFilter":        "read wirte",
+		"SystemCallErrorNumber":   "EWHATEVER",
+		"SystemCallArchitectures": "native mips",
+	} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\n" + option + "=" + value))
+		if assert.IsType(t, unit.MultiError{}, err, option) {
+			merr := err.(unit.MultiError)
+			if assert.Len(t, merr, 1, option) {
+				assert.Equal(t, option, merr[0].(unit.ParseError).Source, option)
+			}
+		}
+	}
+
+	for _, value := range []string{"@nonexistent", "read:EPERM", "~read:EWHATEVER"} {
+		sv := Unit{}
+		err := sv.Define(strings.NewReader("[Service]\nExecStart=/bin/true\nSystemCallFilter=" + value))
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSyscallFilter(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Must be run as root")
+	}
+	if nativeArch == 0 {
+		t.Skip("System call filtering is not supported")
+	}
+
+	for _, test := range []struct {
+		name, options, script, expected string
+	}{
+		{
+			"Deny list",
+			"SystemCallFilter=~chroot\nSystemCallErrorNumber=EPERM",
+			"echo ok; chroot / true 2>/dev/null || echo denied",
+			"ok\ndenied\n",
+		},
+		{
+			"Allow list",
+			"SystemCallFilter=@system-service\nSystemCallFilter=~@setuid\nSystemCallErrorNumber=EACCES",
+			"echo ok; chroot / true 2>/dev/null || echo denied",
+			"ok\ndenied\n",
+		},
+		{
+			// Dynamically linked shell is loaded with the system calls of @default
+			"Allow list without @default",
+			"SystemCallFilter=@basic-io @file-system @signal @process ioctl uname\nSystemCallErrorNumber=EPERM",
+			"echo ok",
+			"ok\n",
+		},
+		{
+			"Error number of system call",
+			"SystemCallFilter=~chroot:ENOSYS",
+			"chroot / true 2>&1 | grep -q \"not implemented\" && echo denied",
+			"denied\n",
+		},
+	} {
+		out, err := runSandboxed(t, test.options, test.script)
+		if assert.NoError(t, err, test.name) {
+			assert.Equal(t, test.expected, out, test.name)
+		}
+	}
+
+	_, err := runSandboxed(t, "SystemCallFilter=~chroot", "chroot / true")
+	assert.Error(t, err, "killed")
+}
@@ -3,8 +3,13 @@ package service
 
 import (
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/plasma-umass/systemgo/unit"
 
@@ -13,8 +18,18 @@ import (
 
 const DEFAULT_TYPE = "simple"
 
+// Default time to wait for the start of a service to complete
+const DEFAULT_TIMEOUT_START_SEC = 90 * time.Second
+
+// Value of a timeout, which is not specified in definition
+const unset time.Duration = -1
+
+// Maximum time the execution of the main process of an idle service is delayed for
+const IDLE_TIMEOUT = 5 * time.Second
+
 const (
 	dead         = "dead"
+	condition    = "condition"
 	startPre     = "startPre"
 	start        = "start"
 	startPost    = "startPost"
@@ -32,32 +47,219 @@ const (
 	autoRestart  = "autoRestart"
 )
 
+var notifyAccess = map[string]bool{
+	"none": true,
+	"main": true,
+	"exec": true,
+	"all":  true,
+}
+
 var supported = map[string]bool{
-	"oneshot": true,
-	"simple":  true,
-	"forking": false,
-	"dbus":    false,
-	"notify":  false,
-	"idle":    false,
+	"oneshot":       true,
+	"simple":        true,
+	"exec":          true,
+	"forking":       true,
+	"dbus":          true,
+	"notify":        true,
+	"idle":          true,
+	"notify-reload": true,
 }
 
 // Service unit
 type Unit struct {
 	Definition
 	*exec.Cmd
+
+	// Path to the notification socket passed to service processes
+	NotifySocket string
+
+	// TimeoutStartSec used if not specified in definition, DEFAULT_TIMEOUT_START_SEC if zero
+	DefaultTimeoutStartSec time.Duration
+
+	// Function blocking until no jobs other than those waiting for it are running or
+	// timeout elapses, used to delay the execution of idle services. nil if not supported
+	IdleWait func(timeout time.Duration)
+
+	// Address of the bus BusName of dbus services is watched on, DEFAULT_BUS_ADDRESS if empty
+	BusAddress string
+
+	// Supervisor starting and waiting for the processes of the service, which reaps
+	// their orphaned descendants. nil if the processes are started by the service itself
+	Supervisor unit.ProcessSupervisor
+
+	// PID of the main process of the service, 0 if unknown or once the process has exited
+	mainPID int
+
+	// Start times of the main and control processes and of the leader of the process group of
+	// the service(see proc(5)), telling them apart from processes reusing their PIDs
+	mainStart, controlStart, leaderStart uint64
+
+	// Command the main process of the current run was started with, nil if not started yet,
+	// and the state of the process once it was waited for. The embedded Cmd only specifies
+	// the main process, exec.Cmd can only be started once
+	main      *exec.Cmd
+	mainState *os.ProcessState
+
+	// Environment the processes of the current run are started in
+	env []string
+
+	// State of the service as reported over the notification socket or,
+	// for dbus services, whether BusName is owned
+	ready      bool
+	readych    chan struct{}
+	reloadch   chan struct{}
+	notified   string
+	statusText string
+	errno      int
+
+	// Result of the last run of the service
+	result string
+
+	// Main process, which is not a child of the manager, waited for and a channel
+	// closed once it is reaped by the manager, along with its exit status
+	orphanPID  int
+	orphanch   chan struct{}
+	orphanExit *unit.ExitStatus
+
+	// Exit status of the main process of the last run, nil if unknown
+	exitStatus *unit.ExitStatus
+
+	// Exit statuses specified by SuccessExitStatus, RestartPreventExitStatus and RestartForceExitStatus
+	successStatus, preventStatus, forceStatus exitStatusSet
+
+	// Lifecycle phase a control process is being run in and its PID
+	phase      string
+	controlPID int
+
+	// Whether the last start of the service failed before the service became active
+	startFailed bool
+
+	// Whether the last start of the service did not complete in time
+	timedOut bool
+
+	// Parsed commands of the Exec*= options mapped to the option names
+	commands map[string][]unit.ExecCommand
+
+	// Credentials the processes of the service are run with, nil if not specified
+	creds *credentials
+
+	// Resource and scheduling settings of the processes of the service, nil if not specified
+	resources *resources
+
+	// Capability and privilege settings of the processes of the service, nil if not specified
+	privileges *privileges
+
+	// Managed directories specified in definition mapped to their kinds
+	directories map[string]directories
+
+	// Credentials specified in definition
+	credentialSpecs credentialSpecs
+
+	// Private credentials directory of the invocation, empty if not created,
+	// and whether tmpfs is mounted on it
+	credentialsDir     string
+	credentialsMounted bool
+
+	// Directories shared by the sandboxed processes of the service, empty if not created
+	runtimeDir, varTmpDir string
+
+	// Function the output of the processes of the service is logged with
+	outputLog func(priority int, identifier, line string)
+
+	// Watchdog state: the timer, timeout set using WATCHDOG_USEC=, whether the
+	// watchdog expired and a channel closed once the processes are terminated
+	watchdogTimer    *time.Timer
+	watchdogOverride time.Duration
+	watchdogFired    bool
+	watchdogDone     chan struct{}
+
+	// Automatic restart state. autoRestarted is set once a restart is requested,
+	// until the service is started or stopped
+	restartch     chan struct{}
+	restartTimer  *time.Timer
+	restarting    bool
+	autoRestarted bool
+	stopping      bool
+	nRestarts     int
+
+	mutex sync.Mutex
 }
 
 // Service unit definition
 type Definition struct {
 	unit.Definition
 	Service struct {
-		Type                            string
-		ExecStart, ExecStop, ExecReload string
-		//Restart                         string
-		//RestartSec                      int
-		RemainAfterExit  bool
-		WorkingDirectory string
-		//PIDFile          string
+		Type                                   string
+		BusName                                string
+		ExecCondition, ExecStartPre, ExecStart unit.Lines
+		ExecStartPost, ExecReload              unit.Lines
+		ExecStop, ExecStopPost                 unit.Lines
+		Restart                                string
+		RestartSec, RestartMaxDelaySec         time.Duration
+		RestartSteps                           int
+		SuccessExitStatus                      unit.Lines
+		RestartPreventExitStatus               unit.Lines
+		RestartForceExitStatus                 unit.Lines
+		RemainAfterExit                        bool
+		Environment, EnvironmentFile           unit.Lines
+		PassEnvironment, UnsetEnvironment      unit.Lines
+		User, Group                            string
+		SupplementaryGroups                    unit.Lines
+		KillMode                               string
+		KillSignal, FinalKillSignal            string
+		SendSIGKILL, SendSIGHUP                bool
+		TimeoutStartSec, TimeoutStopSec        time.Duration
+		TimeoutSec, TimeoutAbortSec            time.Duration
+		WatchdogSec                            time.Duration
+		WatchdogSignal                         string
+		ReloadSignal                           string
+		LimitCPU, LimitFSIZE, LimitDATA        string
+		LimitSTACK, LimitCORE, LimitRSS        string
+		LimitNPROC, LimitNOFILE, LimitMEMLOCK  string
+		LimitAS, LimitLOCKS, LimitSIGPENDING   string
+		LimitMSGQUEUE, LimitNICE, LimitRTPRIO  string
+		LimitRTTIME                            string
+		UMask, Nice, OOMScoreAdjust            string
+		CPUAffinity                            unit.Lines
+		IOSchedulingClass                      string
+		IOSchedulingPriority                   string
+		CPUSchedulingPolicy                    string
+		CPUSchedulingPriority                  string
+		PrivateTmp, PrivateDevices             bool
+		PrivateNetwork                         bool
+		ProtectSystem, ProtectHome             string
+		ReadOnlyPaths, ReadWritePaths          unit.Lines
+		InaccessiblePaths                      unit.Lines
+		BindPaths, BindReadOnlyPaths           unit.Lines
+		TemporaryFileSystem                    unit.Lines
+		RootDirectory                          string
+		CapabilityBoundingSet                  unit.Lines
+		AmbientCapabilities, SecureBits        unit.Lines
+		NoNewPrivileges, RestrictSUIDSGID      bool
+		SystemCallFilter                       unit.Lines
+		SystemCallErrorNumber                  string
+		SystemCallArchitectures                unit.Lines
+		StandardInput                          string
+		StandardInputText, StandardInputData   unit.Lines
+		TTYPath                                string
+		TTYReset, TTYVHangup                   bool
+		StandardOutput, StandardError          string
+		SyslogIdentifier                       string
+		SyslogLevelPrefix                      bool
+		WorkingDirectory                       string
+		RuntimeDirectory, StateDirectory       unit.Lines
+		CacheDirectory, LogsDirectory          unit.Lines
+		ConfigurationDirectory                 unit.Lines
+		RuntimeDirectoryMode                   string
+		StateDirectoryMode, CacheDirectoryMode string
+		LogsDirectoryMode                      string
+		ConfigurationDirectoryMode             string
+		RuntimeDirectoryPreserve               string
+		LoadCredential, SetCredential          unit.Lines
+		ImportCredential                       unit.Lines
+		PIDFile                                string
+		GuessMainPID                           bool
+		NotifyAccess                           string
 	}
 }
 
@@ -73,8 +275,25 @@ func Supported(typ string) (is bool) {
 func (sv *Unit) Define(r io.Reader /*, errch chan<- error*/) (err error) {
 	log.WithField("r", r).Debugf("sv.Define")
 
-	def := Definition{}
+	def := Definition{Definition: unit.NewDefinition()}
 	def.Service.Type = DEFAULT_TYPE
+	def.Service.GuessMainPID = true
+	def.Service.Restart = "no"
+	def.Service.RestartSec = DEFAULT_RESTART_SEC
+	def.Service.KillMode = DEFAULT_KILL_MODE
+	def.Service.KillSignal = "SIGTERM"
+	def.Service.FinalKillSignal = "SIGKILL"
+	def.Service.SendSIGKILL = true
+	def.Service.WatchdogSignal = DEFAULT_WATCHDOG_SIGNAL
+	def.Service.ReloadSignal = DEFAULT_RELOAD_SIGNAL
+	def.Service.StandardOutput = DEFAULT_STANDARD_OUTPUT
+	def.Service.StandardError = "inherit"
+	def.Service.SyslogLevelPrefix = true
+	def.Service.RuntimeDirectoryPreserve = "no"
+	def.Service.TimeoutStartSec = unset
+	def.Service.TimeoutStopSec = unset
+	def.Service.TimeoutSec = unset
+	def.Service.TimeoutAbortSec = unset
 
 	if err = unit.ParseDefinition(r, &def); err != nil {
 		return
@@ -84,81 +303,666 @@ func (sv *Unit) Define(r io.Reader /*, errch chan<- error*/) (err error) {
 
 	// Check definition for errors
 	switch {
-	case def.Service.ExecStart == "":
+	case len(def.Service.ExecStart) == 0:
 		merr = append(merr, unit.ParseErr("ExecStart", unit.ErrNotSet))
 
 	case !Supported(def.Service.Type):
 		merr = append(merr, unit.ParseErr("Type", unit.ParseErr(def.Service.Type, unit.ErrNotSupported)))
+
+	case def.Service.Type == "dbus" && def.Service.BusName == "":
+		merr = append(merr, unit.ParseErr("BusName", unit.ErrNotSet))
+	}
+
+	commands := map[string][]unit.ExecCommand{}
+	for name, lines := range def.execLines() {
+		for _, line := range lines {
+			cmds, err := unit.ParseCommandLine(line)
+			if err != nil {
+				merr = append(merr, unit.ParseErr(name, err))
+				continue
+			}
+			commands[name] = append(commands[name], cmds...)
+		}
+	}
+
+	if len(commands["ExecStart"]) > 1 && def.Service.Type != "oneshot" {
+		merr = append(merr, unit.ParseErr("ExecStart", ErrMultipleExecStart))
+	}
+
+	if _, ok := restartPolicies[def.Service.Restart]; !ok {
+		merr = append(merr, unit.ParseErr("Restart", unit.ParseErr(def.Service.Restart, unit.ErrNotSupported)))
+	}
+
+	for _, line := range def.Service.Environment {
+		if _, err := unit.ParseEnvironment(line); err != nil {
+			merr = append(merr, unit.ParseErr("Environment", err))
+		}
+	}
+
+	for _, path := range def.Service.EnvironmentFile {
+		if !filepath.IsAbs(strings.TrimPrefix(path, "-")) {
+			merr = append(merr, unit.ParseErr("EnvironmentFile", unit.ParseErr(path, unit.ErrPathNotAbs)))
+		}
+	}
+
+	for _, line := range def.Service.PassEnvironment {
+		for _, key := range strings.Fields(line) {
+			if !unit.ValidEnvName(key) {
+				merr = append(merr, unit.ParseErr("PassEnvironment", unit.ParseErr(key, unit.ErrWrongVal)))
+			}
+		}
+	}
+
+	for _, line := range def.Service.UnsetEnvironment {
+		for _, v := range strings.Fields(line) {
+			if key := strings.SplitN(v, "=", 2)[0]; !unit.ValidEnvName(key) {
+				merr = append(merr, unit.ParseErr("UnsetEnvironment", unit.ParseErr(v, unit.ErrWrongVal)))
+			}
+		}
+	}
+
+	if !killModes[def.Service.KillMode] {
+		merr = append(merr, unit.ParseErr("KillMode", unit.ParseErr(def.Service.KillMode, unit.ErrNotSupported)))
+	}
+
+	if _, err := parseSignal(def.Service.KillSignal); err != nil {
+		merr = append(merr, unit.ParseErr("KillSignal", unit.ParseErr(def.Service.KillSignal, err)))
+	}
+
+	if _, err := parseSignal(def.Service.FinalKillSignal); err != nil {
+		merr = append(merr, unit.ParseErr("FinalKillSignal", unit.ParseErr(def.Service.FinalKillSignal, err)))
+	}
+
+	if _, err := parseSignal(def.Service.WatchdogSignal); err != nil {
+		merr = append(merr, unit.ParseErr("WatchdogSignal", unit.ParseErr(def.Service.WatchdogSignal, err)))
+	}
+
+	if _, err := parseSignal(def.Service.ReloadSignal); err != nil {
+		merr = append(merr, unit.ParseErr("ReloadSignal", unit.ParseErr(def.Service.ReloadSignal, err)))
+	}
+
+	if def.Service.StandardInput == "" {
+		def.Service.StandardInput = DEFAULT_STANDARD_INPUT
+		if len(def.Service.StandardInputText) > 0 || len(def.Service.StandardInputData) > 0 {
+			def.Service.StandardInput = "data"
+		}
+	}
+	if _, _, err := parseInput(def.Service.StandardInput); err != nil {
+		merr = append(merr, unit.ParseErr("StandardInput", unit.ParseErr(def.Service.StandardInput, err)))
+	}
+	if _, err := inputData(def); err != nil {
+		merr = append(merr, err)
+	}
+	if def.Service.TTYPath != "" && !filepath.IsAbs(def.Service.TTYPath) {
+		merr = append(merr, unit.ParseErr("TTYPath", unit.ParseErr(def.Service.TTYPath, unit.ErrPathNotAbs)))
+	}
+
+	if _, _, err := parseOutput(def.Service.StandardOutput); err != nil {
+		merr = append(merr, unit.ParseErr("StandardOutput", unit.ParseErr(def.Service.StandardOutput, err)))
+	}
+
+	if _, _, err := parseOutput(def.Service.StandardError); err != nil {
+		merr = append(merr, unit.ParseErr("StandardError", unit.ParseErr(def.Service.StandardError, err)))
+	}
+
+	res, rerr := parseResources(def)
+	merr = append(merr, rerr...)
+	merr = append(merr, checkSandbox(def)...)
+	dirs, derr := parseDirectories(def)
+	merr = append(merr, derr...)
+	specs, crerr := parseCredentials(def)
+	merr = append(merr, crerr...)
+	priv, perr := parsePrivileges(def)
+	merr = append(merr, perr...)
+
+	merr = append(merr, def.CheckStartLimit()...)
+	_, cerr := def.ParseConditions()
+	merr = append(merr, cerr...)
+
+	success := parseExitStatusSet(&merr, "SuccessExitStatus", def.Service.SuccessExitStatus)
+	prevent := parseExitStatusSet(&merr, "RestartPreventExitStatus", def.Service.RestartPreventExitStatus)
+	force := parseExitStatusSet(&merr, "RestartForceExitStatus", def.Service.RestartForceExitStatus)
+
+	if def.Service.PIDFile != "" && !filepath.IsAbs(def.Service.PIDFile) {
+		merr = append(merr, unit.ParseErr("PIDFile", unit.ErrPathNotAbs))
+	}
+
+	switch def.Service.NotifyAccess {
+	case "":
+		if def.Service.Type == "notify" || def.Service.Type == "notify-reload" {
+			def.Service.NotifyAccess = "main"
+		} else {
+			def.Service.NotifyAccess = "none"
+		}
+	default:
+		if !notifyAccess[def.Service.NotifyAccess] {
+			merr = append(merr, unit.ParseErr("NotifyAccess", unit.ParseErr(def.Service.NotifyAccess, unit.ErrNotSupported)))
+		}
 	}
 
 	if len(merr) > 0 {
 		return merr
 	}
 
-	sv.Definition = def
+	sv.setTimeouts(&def)
 
-	cmd := strings.Fields(def.Service.ExecStart)
-	sv.Cmd = exec.Command(cmd[0], cmd[1:]...)
+	sv.Definition = def
+	sv.commands = commands
+	sv.resources = res
+	sv.privileges = priv
+	sv.directories = dirs
+	sv.credentialSpecs = specs
+	sv.successStatus, sv.preventStatus, sv.forceStatus = success, prevent, force
+
+	main := commands["ExecStart"][0]
+	sv.Cmd = exec.Command(main.Path, main.Argv[1:]...)
+	sv.Cmd.Args[0] = main.Argv[0]
 	sv.Cmd.Dir = sv.Definition.Service.WorkingDirectory
 
 	return nil
 }
 
-// Start executes the command specified in service definition
+// setTimeouts sets the timeouts not specified in def to their defaults
+func (sv *Unit) setTimeouts(def *Definition) {
+	timeouts := &def.Service
+
+	if timeouts.TimeoutSec != unset {
+		// TimeoutSec sets both start and stop timeouts
+		if timeouts.TimeoutStartSec == unset {
+			timeouts.TimeoutStartSec = timeouts.TimeoutSec
+		}
+		if timeouts.TimeoutStopSec == unset {
+			timeouts.TimeoutStopSec = timeouts.TimeoutSec
+		}
+	}
+
+	if timeouts.TimeoutStartSec == unset {
+		timeouts.TimeoutStartSec = DEFAULT_TIMEOUT_START_SEC
+		if sv.DefaultTimeoutStartSec != 0 {
+			timeouts.TimeoutStartSec = sv.DefaultTimeoutStartSec
+		}
+	}
+	if timeouts.TimeoutStopSec == unset {
+		timeouts.TimeoutStopSec = DEFAULT_TIMEOUT_STOP_SEC
+	}
+	if timeouts.TimeoutAbortSec == unset {
+		timeouts.TimeoutAbortSec = timeouts.TimeoutStopSec
+	}
+
+	// Zero disables the timeout
+	for _, timeout := range []*time.Duration{&timeouts.TimeoutStartSec, &timeouts.TimeoutStopSec, &timeouts.TimeoutAbortSec} {
+		if *timeout == 0 {
+			*timeout = unit.Infinity
+		}
+	}
+}
+
+// execLines returns command lines specified in the definition mapped to the option names
+func (def Definition) execLines() map[string]unit.Lines {
+	return map[string]unit.Lines{
+		"ExecCondition": def.Service.ExecCondition,
+		"ExecStartPre":  def.Service.ExecStartPre,
+		"ExecStart":     def.Service.ExecStart,
+		"ExecStartPost": def.Service.ExecStartPost,
+		"ExecReload":    def.Service.ExecReload,
+		"ExecStop":      def.Service.ExecStop,
+		"ExecStopPost":  def.Service.ExecStopPost,
+	}
+}
+
+// Start executes the commands specified in service definition
 func (sv *Unit) Start() (err error) {
 	e := log.WithField("ExecStart", sv.Definition.Service.ExecStart)
 
 	e.Debug("sv.Start")
 
+	sv.prepareStart()
+
+	var timer *time.Timer
+	aborted := make(chan struct{})
+	if timeout := sv.Definition.Service.TimeoutStartSec; timeout > 0 && timeout != unit.Infinity {
+		timer = time.AfterFunc(timeout, func() {
+			sv.abortStart()
+			close(aborted)
+		})
+	}
+
+	var ok bool
+	ok, err = sv.start()
+
+	if timer != nil && !timer.Stop() {
+		// The timer fired before the start completed, so the start
+		// failed even if the commands completed in the meantime
+		<-aborted
+		if err == nil {
+			err = ErrStartTimeout
+		}
+	}
+
+	switch {
+	case err != nil:
+		return sv.failStart(err)
+	case !ok:
+		e.Info("Condition check failed, skipping")
+		// Nothing runs, so the resources set up for the processes are released
+		sv.cleanupSandbox()
+		sv.removeCredentials()
+		sv.removeRuntimeDirectories(true)
+		return nil
+	}
+
+	if cmd, state := sv.mainCmd(); sv.Definition.Service.Type == "oneshot" {
+		sv.exited(cmd, sv.statusResult(exitStatusOf(state)))
+	} else {
+		sv.startWatchdog(cmd)
+	}
+
+	e.Debug("started")
+	return nil
+}
+
+// start runs the commands starting the service and reports whether its conditions were met
+func (sv *Unit) start() (ok bool, err error) {
+	if sv.creds, err = sv.resolveCredentials(); err != nil {
+		sv.logError("Failed to determine user credentials: %s", err)
+		return
+	}
+
+	if err = sv.createDirectories(); err != nil {
+		sv.logError("Failed to set up managed directories: %s", err)
+		return
+	}
+
+	if err = sv.setupCredentials(); err != nil {
+		sv.logError("Failed to set up credentials: %s", err)
+		return
+	}
+
+	var env []string
+	if env, err = sv.environment(); err != nil {
+		sv.logError("Failed to determine environment: %s", err)
+		return
+	}
+	sv.mutex.Lock()
+	sv.env = env
+	sv.mutex.Unlock()
+
+	cmd := sv.newMainCmd(env)
+
+	if err = sv.prepareSandbox(); err != nil {
+		sv.logError("Failed to prepare sandbox: %s", err)
+		return
+	}
+
+	if ok, err = sv.checkCondition(); err != nil {
+		sv.logError("Condition check failed: %s", err)
+		return
+	} else if !ok {
+		return
+	}
+
+	if err = sv.runControl(startPre, sv.commands["ExecStartPre"]); err != nil {
+		sv.logError("ExecStartPre command failed: %s", err)
+		return
+	}
+
+	var started func()
+	if started, err = sv.connectStdio(cmd); err != nil {
+		sv.logError("Failed to connect standard input and output: %s", err)
+		return
+	}
+	defer started()
+
 	switch sv.Definition.Service.Type {
-	case "simple":
-		if err = sv.Cmd.Start(); err == nil {
-			go sv.Cmd.Wait()
+	case "simple", "exec", "idle":
+		// The main process is considered started once it is executed, so that
+		// failure to execute it fails the start
+		if sv.Definition.Service.Type == "idle" && sv.IdleWait != nil {
+			sv.IdleWait(IDLE_TIMEOUT)
+		}
+		if err = sv.startMain(cmd); err == nil {
+			sv.setMainPID(cmd.Process.Pid)
+			go sv.supervise(cmd, nil)
 		}
 	case "oneshot":
-		err = sv.Cmd.Run()
+		err = sv.runMain(cmd)
+		if cmd.ProcessState != nil {
+			sv.setExitStatus(exitStatusOf(cmd.ProcessState))
+		}
+		if err != nil && (sv.ignoresFailure() || sv.successStatus.contains(sv.ExitStatus())) {
+			err = nil
+		}
+		if cmds := sv.commands["ExecStart"]; err == nil && len(cmds) > 1 {
+			// Remaining commands are run one after another, like control processes
+			err = sv.runControl(start, cmds[1:])
+		}
+	case "forking":
+		err = sv.startForking(cmd)
+	case "notify", "notify-reload":
+		err = sv.startNotify(cmd)
+	case "dbus":
+		err = sv.startDbus(cmd)
 	default:
 		panic("Unknown service type")
 	}
+	if err != nil {
+		sv.logError("Failed to start main process: %s", err)
+		return
+	}
+
+	if err = sv.runControl(startPost, sv.commands["ExecStartPost"]); err != nil {
+		sv.logError("ExecStartPost command failed: %s", err)
+	}
+	return true, err
+}
 
-	e.WithField("err", err).Debug("started")
+// abortStart terminates the processes of the service, which did not start in TimeoutStartSec
+func (sv *Unit) abortStart() {
+	sv.mutex.Lock()
+	sv.timedOut = true
+	sv.mutex.Unlock()
+
+	log.WithField("ExecStart", sv.Definition.Service.ExecStart).Warn("Start operation timed out, terminating")
+	sv.terminate(stopSigterm, stopSigkill, sv.Definition.Service.TimeoutStopSec)
+}
+
+// startTimedOut reports whether the start of the service did not complete in TimeoutStartSec
+func (sv *Unit) startTimedOut() bool {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	return sv.timedOut
+}
+
+// startForking runs cmd, waits for it to exit and determines the main PID
+// of the daemon process it forked off
+func (sv *Unit) startForking(cmd *exec.Cmd) (err error) {
+	e := log.WithField("ExecStart", sv.Definition.Service.ExecStart)
+
+	if err = sv.runMain(cmd); err != nil {
+		if cmd.ProcessState != nil {
+			sv.setExitStatus(exitStatusOf(cmd.ProcessState))
+		}
+		return
+	}
+
+	var pid int
+	if path := sv.Definition.Service.PIDFile; path != "" {
+		// The PID file is waited for to appear for the rest of TimeoutStartSec
+		for ; ; time.Sleep(POLL_INTERVAL / 2) {
+			if pid, err = readPIDFile(path); err == nil || sv.startTimedOut() {
+				break
+			}
+		}
+		if err != nil {
+			e.WithField("PIDFile", path).Errorf("Failed to read PID file: %s", err)
+			return
+		}
+	} else if sv.Definition.Service.GuessMainPID {
+		if pid, err = guessMainPID(cmd.Process.Pid); err != nil {
+			// The service is still considered running, it just can not be supervised reliably
+			e.Warnf("Failed to guess main PID: %s", err)
+			err = nil
+		}
+	}
+
+	e.WithField("pid", pid).Debug("main PID")
+	sv.setMainPID(pid)
+	go sv.supervisePID(cmd, pid)
 	return
 }
 
-// Stop stops execution of the command specified in service definition
-func (sv *Unit) Stop() (err error) {
-	if cmd := strings.Fields(sv.Definition.Service.ExecStop); len(cmd) > 0 {
-		return exec.Command(cmd[0], cmd[1:]...).Run()
+// newMainCmd returns a new command starting the main process specified by sv.Cmd in
+// environment env, with the variables in the arguments substituted
+func (sv *Unit) newMainCmd(env []string) *exec.Cmd {
+	cmd := &exec.Cmd{
+		Path:        sv.Cmd.Path,
+		Args:        sv.Cmd.Args,
+		Env:         env,
+		Dir:         sv.Cmd.Dir,
+		Stdin:       sv.Cmd.Stdin,
+		Stdout:      sv.Cmd.Stdout,
+		Stderr:      sv.Cmd.Stderr,
+		ExtraFiles:  sv.Cmd.ExtraFiles,
+		SysProcAttr: &syscall.SysProcAttr{},
+	}
+	if sv.Cmd.SysProcAttr != nil {
+		*cmd.SysProcAttr = *sv.Cmd.SysProcAttr
 	}
-	if sv.Cmd.Process != nil {
-		return sv.Cmd.Process.Kill()
+
+	if cmds := sv.commands["ExecStart"]; len(cmds) > 0 {
+		// Substitute the variables in the environment the process is run in
+		if argv := cmds[0].Expand(environ(env)); len(argv) > 0 {
+			cmd.Args = argv
+		}
+		cmd.SysProcAttr.Credential = sv.credential(cmds[0])
 	}
+
+	// Put the processes of the service in a new group, so that they can be found
+	// when guessing the main PID, matching notifications and stopping the service
+	cmd.SysProcAttr.Setpgid = true
+	return cmd
+}
+
+// startMain starts the main process of the current run using cmd
+func (sv *Unit) startMain(cmd *exec.Cmd) error {
+	if err := sv.startCmd(cmd, sv.mainCommand(), true); err != nil {
+		return err
+	}
+
+	sv.mutex.Lock()
+	sv.main = cmd
+	sv.leaderStart = startTime(cmd.Process.Pid)
+	sv.mutex.Unlock()
 	return nil
 }
 
+// waitMain waits for the main process started by cmd to exit and records its state
+func (sv *Unit) waitMain(cmd *exec.Cmd) error {
+	err := sv.wait(cmd)
+
+	sv.mutex.Lock()
+	if cmd == sv.main {
+		sv.mainState = cmd.ProcessState
+	}
+	sv.mutex.Unlock()
+	return err
+}
+
+// runMain starts the main process using cmd and waits for it to complete
+func (sv *Unit) runMain(cmd *exec.Cmd) error {
+	if err := sv.startMain(cmd); err != nil {
+		return err
+	}
+	return sv.waitMain(cmd)
+}
+
+// mainCmd returns the command the main process of the current run was started with,
+// nil if it was not started, and the state of the process, nil if it was not waited for
+func (sv *Unit) mainCmd() (*exec.Cmd, *os.ProcessState) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+	return sv.main, sv.mainState
+}
+
+// prepareStart resets the state left from the previous run of the service
+func (sv *Unit) prepareStart() {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.stopping = false
+	sv.startFailed = false
+	sv.timedOut = false
+	sv.result = ""
+	sv.exitStatus = nil
+	sv.mainPID = 0
+	sv.cancelRestart()
+	if !sv.autoRestarted {
+		// Restarts are counted since the last explicit start
+		sv.nRestarts = 0
+	}
+	sv.autoRestarted = false
+	sv.stopWatchdog()
+	sv.watchdogOverride = 0
+	sv.watchdogFired = false
+	sv.watchdogDone = nil
+	sv.main = nil
+	sv.mainState = nil
+}
+
+// environ returns env or the environment of the manager, if env is nil
+func environ(env []string) []string {
+	if env == nil {
+		return os.Environ()
+	}
+	return env
+}
+
+// setEnv returns env with variable key set to value.
+// If env is nil, the environment of the manager is used
+func setEnv(env []string, key, value string) []string {
+	env = environ(env)
+
+	for i, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
+
+func (sv *Unit) setMainPID(pid int) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.mainPID = pid
+	sv.mainStart = startTime(pid)
+}
+
+// MainPID returns the PID of the main process of the service if it is running, 0 otherwise
+func (sv *Unit) MainPID() int {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	if isAlive(sv.mainPID) && startTime(sv.mainPID) == sv.mainStart {
+		return sv.mainPID
+	}
+	return 0
+}
+
+// Properties returns the settings of the service as shown by systemctl show
+func (sv *Unit) Properties() map[string]string {
+	def := sv.Definition.Service
+	props := map[string]string{
+		"Type":         def.Type,
+		"Restart":      def.Restart,
+		"NotifyAccess": def.NotifyAccess,
+		"KillMode":     def.KillMode,
+		"Result":       sv.Result(),
+	}
+	if st := sv.ExitStatus(); st != nil {
+		props["ExecMainStatus"] = st.String()
+	}
+	if def.BusName != "" {
+		props["BusName"] = def.BusName
+	}
+	sv.resourceProperties(props)
+	sv.directoryProperties(props)
+	return props
+}
+
+// Stop runs ExecStop commands, terminates the processes of the service according
+// to KillMode and runs ExecStopPost commands afterwards
+func (sv *Unit) Stop() (err error) {
+	sv.mutex.Lock()
+	sv.stopping = true
+	sv.cancelRestart()
+	sv.autoRestarted = false
+	sv.stopWatchdog()
+	sv.mutex.Unlock()
+
+	return sv.runStop(true)
+}
+
 // Sub reports the sub status of a service
 func (sv *Unit) Sub() string {
 	log.WithField("sv", sv).Debugf("sv.Sub")
 
-	switch {
-	case sv.Cmd.Process == nil:
+	cmd, state := sv.mainCmd()
+
+	sv.mutex.Lock()
+	restarting := sv.restarting
+	sv.mutex.Unlock()
+
+	switch phase, startFailed := sv.state(); {
+	case restarting:
+		// Service is waiting to be restarted
+		return autoRestart
+
+	case phase != "":
+		// A control process is running
+		return phase
+
+	case startFailed:
+		return failed
+
+	case cmd == nil:
 		// Service has not been started yet
 		return dead
 
-	case sv.Cmd.ProcessState == nil:
+	case state == nil:
+		switch sv.Definition.Service.Type {
+		case "forking", "oneshot":
+			// Start command has not exited yet
+			return start
+		case "notify", "notify-reload", "dbus":
+			return sv.notifySub()
+		}
 		// Wait has not returned yet
 		return running
 
-	case sv.ProcessState.Exited(), sv.ProcessState.Success():
+	case (sv.Definition.Service.Type == "notify" || sv.Definition.Service.Type == "notify-reload") && sv.mainPIDChanged():
+		// Main PID was changed using MAINPID= and the new main process is running
+		return sv.notifySub()
+
+	case sv.Definition.Service.Type == "forking" && state.Success():
+		sv.mutex.Lock()
+		pid, result := sv.mainPID, sv.result
+		sv.mutex.Unlock()
+
+		if result == "" && (pid == 0 || isAlive(pid)) {
+			// Main PID is either alive or unknown and its exit was not handled yet
+			return running
+		}
+		if !sv.succeeded(state) {
+			// Exit status of the main process is considered a failure
+			return failed
+		}
 		if sv.Definition.Service.RemainAfterExit {
 			return exited
 		}
 		return dead
 
-	default:
-		// Service process has finished, but did not return a 0 exit code
+	case !sv.succeeded(state):
+		// Main process has finished, but its result is considered a failure
 		return failed
+
+	case sv.Definition.Service.RemainAfterExit:
+		return exited
+
+	default:
+		return dead
+	}
+}
+
+// succeeded reports whether the last run of the service, the main process of which
+// has exited with state, succeeded
+func (sv *Unit) succeeded(state *os.ProcessState) bool {
+	if result := sv.Result(); result != "" {
+		return result == resultSuccess
 	}
+	// Exit has not been handled yet
+	return sv.statusResult(exitStatusOf(state)) == resultSuccess
 }
 
 // Active reports activation status of a service
@@ -175,7 +979,7 @@ func (sv *Unit) Active() unit.Activation {
 		return unit.Reloading
 	case running, exited:
 		return unit.Active
-	case start, startPre, startPost, autoRestart:
+	case condition, start, startPre, startPost, autoRestart:
 		return unit.Activating
 	case stop, stopSigabrt, stopPost, stopSigkill, stopSigterm, finalSigkill, finalSigterm:
 		return unit.Deactivating
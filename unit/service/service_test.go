@@ -2,23 +2,30 @@ package service
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/plasma-umass/systemgo/unit"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDefine(t *testing.T) {
-	sv := Unit{}
+	sv := &Unit{}
 	assert.NoError(t, sv.Define(strings.NewReader(`[Service]
 ExecStart=/bin/echo test`)), "sv.Define")
 	assert.Equal(t, sv.Definition.Service.Type, DEFAULT_TYPE, "sv.Definition.Service.Type")
 
 	var err error
 
-	sv = Unit{}
+	sv = &Unit{}
 	if err = sv.Define(strings.NewReader(`[Service]`)); assert.Error(t, err, "sv.Define with wrong definition") {
 		if me, ok := err.(unit.MultiError); assert.True(t, ok, "error is MultiError") {
 			if pe, ok := me[0].(unit.ParseError); assert.True(t, ok, "error is ParseError") {
@@ -27,6 +34,59 @@ ExecStart=/bin/echo test`)), "sv.Define")
 			}
 		}
 	}
+
+	sv = &Unit{}
+	if err = sv.Define(strings.NewReader(`[Service]
+Type=forking
+ExecStart=/bin/echo test
+PIDFile=run/test.pid`)); assert.Error(t, err, "sv.Define with relative PIDFile") {
+		if me, ok := err.(unit.MultiError); assert.True(t, ok, "error is MultiError") {
+			if pe, ok := me[0].(unit.ParseError); assert.True(t, ok, "error is ParseError") {
+				assert.Equal(t, "PIDFile", pe.Source)
+				assert.Equal(t, unit.ErrPathNotAbs, pe.Err)
+			}
+		}
+	}
+
+	sv = &Unit{}
+	if err = sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/echo first
+ExecStart=/bin/echo second`)); assert.Error(t, err, "sv.Define with multiple ExecStart") {
+		if me, ok := err.(unit.MultiError); assert.True(t, ok, "error is MultiError") {
+			if pe, ok := me[0].(unit.ParseError); assert.True(t, ok, "error is ParseError") {
+				assert.Equal(t, "ExecStart", pe.Source)
+				assert.Equal(t, ErrMultipleExecStart, pe.Err)
+			}
+		}
+	}
+
+	sv = &Unit{}
+	if assert.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/echo first
+ExecStart=/bin/echo second`)), "sv.Define oneshot with multiple ExecStart") {
+		assert.Equal(t, []string{"/bin/echo", "first"}, sv.Cmd.Args)
+	}
+
+	sv = &Unit{}
+	if assert.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=-@/bin/sh shell -c "echo \"$1\"" 'first arg'`)), "sv.Define with quoted arguments") {
+		assert.Equal(t, "/bin/sh", sv.Cmd.Path)
+		assert.Equal(t, []string{"shell", "-c", `echo "$1"`, "first arg"}, sv.Cmd.Args)
+	}
+
+	sv = &Unit{}
+	if err = sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/echo "test`)); assert.Error(t, err, "sv.Define with malformed command line") {
+		if me, ok := err.(unit.MultiError); assert.True(t, ok, "error is MultiError") {
+			if pe, ok := me[0].(unit.ParseError); assert.True(t, ok, "error is ParseError") {
+				assert.Equal(t, "ExecStart", pe.Source)
+				if pe, ok := pe.Err.(unit.ParseError); assert.True(t, ok, "error is ParseError") {
+					assert.Equal(t, unit.ErrUnterminatedQuote, pe.Err)
+				}
+			}
+		}
+	}
 }
 
 // Simple service type test
@@ -39,8 +99,9 @@ func TestStartSimple(t *testing.T) {
 	sv.Cmd = exec.Command("sleep", "60")
 
 	assert.NoError(t, sv.Start(), "sv.Start")
-	assert.NotNil(t, sv.Cmd.Process)
-	assert.Nil(t, sv.Cmd.ProcessState)
+	cmd, state := sv.mainCmd()
+	assert.NotNil(t, cmd)
+	assert.Nil(t, state)
 }
 
 func TestStartOneshot(t *testing.T) {
@@ -52,29 +113,217 @@ func TestStartOneshot(t *testing.T) {
 	sv.Cmd = exec.Command("echo", "test")
 
 	assert.NoError(t, sv.Start(), "sv.Start")
-	assert.NotNil(t, sv.Cmd.Process)
-	if assert.NotNil(t, sv.Cmd.ProcessState) {
-		assert.True(t, sv.Cmd.ProcessState.Success())
+	cmd, state := sv.mainCmd()
+	assert.NotNil(t, cmd)
+	if assert.NotNil(t, state) {
+		assert.True(t, state.Success())
 	}
 
 }
 
+func TestStartExec(t *testing.T) {
+	sv := Unit{}
+	assert.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=exec
+ExecStart=/nonexistent/binary`)), "sv.Define")
+
+	assert.Error(t, sv.Start(), "sv.Start of a missing binary")
+	assert.Equal(t, failed, sv.Sub())
+
+	assert.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=exec
+ExecStart=/bin/sleep 60`)), "sv.Define")
+
+	if assert.NoError(t, sv.Start(), "sv.Start") {
+		assert.Equal(t, running, sv.Sub())
+		assert.NoError(t, sv.Stop(), "sv.Stop")
+	}
+}
+
+func TestStartIdle(t *testing.T) {
+	var waited time.Duration
+	sv := Unit{IdleWait: func(timeout time.Duration) { waited = timeout }}
+	assert.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=idle
+ExecStart=/bin/sleep 60`)), "sv.Define")
+
+	if assert.NoError(t, sv.Start(), "sv.Start") {
+		assert.Equal(t, IDLE_TIMEOUT, waited, "IdleWait")
+		assert.Equal(t, running, sv.Sub())
+		assert.NoError(t, sv.Stop(), "sv.Stop")
+	}
+}
+
+func TestStartForking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forking-test")
+	if !assert.NoError(t, err, "ioutil.TempDir") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	pidfile := filepath.Join(dir, "test.pid")
+
+	// PID file specified
+	sv := &Unit{}
+	sv.Definition.Service.Type = "forking"
+	sv.Definition.Service.PIDFile = pidfile
+	sv.Cmd = exec.Command("sh", "-c", "sleep 60 & echo $! > "+pidfile)
+
+	if assert.NoError(t, sv.Start(), "sv.Start") {
+		pid := sv.MainPID()
+		assert.NotZero(t, pid, "sv.MainPID")
+		cmd, _ := sv.mainCmd()
+		assert.NotEqual(t, cmd.Process.Pid, pid, "sv.MainPID")
+		assert.Equal(t, running, sv.Sub())
+		assert.Equal(t, unit.Active, sv.Active())
+
+		assert.NoError(t, sv.Stop(), "sv.Stop")
+		for i := 0; i < 100 && sv.MainPID() != 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Zero(t, sv.MainPID(), "sv.MainPID after sv.Stop")
+		assert.Equal(t, dead, sv.Sub())
+	}
+
+	// Main PID guessed
+	sv = &Unit{}
+	sv.Definition.Service.Type = "forking"
+	sv.Definition.Service.GuessMainPID = true
+	sv.Cmd = exec.Command("sh", "-c", "sleep 60 &")
+
+	if assert.NoError(t, sv.Start(), "sv.Start") {
+		pid := sv.MainPID()
+		if assert.NotZero(t, pid, "sv.MainPID") {
+			assert.NoError(t, syscall.Kill(pid, syscall.SIGKILL))
+		}
+	}
+
+	// PID file never written
+	sv = &Unit{}
+	sv.Definition.Service.Type = "forking"
+	sv.Definition.Service.PIDFile = filepath.Join(dir, "missing.pid")
+	sv.Definition.Service.TimeoutStartSec = 300 * time.Millisecond
+	sv.Cmd = exec.Command("true")
+
+	assert.Equal(t, ErrStartTimeout, sv.Start(), "sv.Start with missing PID file")
+}
+
+func TestStartNotify(t *testing.T) {
+	sv := &Unit{}
+	sv.Definition.Service.Type = "notify"
+	sv.Definition.Service.NotifyAccess = "main"
+	sv.Cmd = exec.Command("sleep", "60")
+
+	assert.Equal(t, ErrNoNotifySocket, sv.Start(), "sv.Start without notification socket")
+
+	sv.NotifySocket = "/run/test/notify"
+
+	errch := make(chan error, 1)
+	go func() {
+		var pid int
+		for pid = sv.MainPID(); pid == 0; pid = sv.MainPID() {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		assert.Equal(t, start, sv.Sub(), "sv.Sub before READY=1")
+		assert.Equal(t, ErrNotifyAccess, sv.Notify(pid+1, "READY=1"), "sv.Notify from a foreign process")
+
+		errch <- sv.Notify(pid, "STATUS=Serving\nREADY=1")
+	}()
+
+	if assert.NoError(t, sv.Start(), "sv.Start") {
+		assert.NoError(t, <-errch, "sv.Notify")
+		cmd, _ := sv.mainCmd()
+		assert.Contains(t, cmd.Env, "NOTIFY_SOCKET=/run/test/notify")
+		assert.Equal(t, running, sv.Sub())
+		assert.Equal(t, "Serving", sv.StatusText())
+
+		pid := sv.MainPID()
+		assert.NoError(t, sv.Notify(pid, "RELOADING=1"))
+		assert.Equal(t, unit.Reloading, sv.Active())
+
+		assert.NoError(t, sv.Notify(pid, "READY=1\nERRNO=2"))
+		assert.Equal(t, running, sv.Sub())
+		assert.Contains(t, sv.StatusText(), "errno 2")
+
+		assert.NoError(t, sv.Notify(pid, "STOPPING=1"))
+		assert.Equal(t, unit.Deactivating, sv.Active())
+
+		assert.NoError(t, cmd.Process.Kill())
+	}
+
+	sv = &Unit{NotifySocket: "/run/test/notify"}
+	sv.Definition.Service.Type = "notify"
+	sv.Definition.Service.NotifyAccess = "main"
+	sv.Cmd = exec.Command("true")
+
+	assert.Equal(t, ErrNotReady, sv.Start(), "sv.Start of a process exiting before READY=1")
+}
+
+func TestNotifyMainPID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-notify-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "child.pid")
+
+	sv := &Unit{NotifySocket: "/run/test/notify"}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=notify
+NotifyAccess=all
+ExecStart=/bin/sh -c 'sleep 60 & echo $! > `+pidfile+`; wait'`)), "sv.Define")
+
+	go func() {
+		for sv.MainPID() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		sv.Notify(sv.MainPID(), "READY=1")
+	}()
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+	main := sv.MainPID()
+
+	// Process outside of the service is not made the main process
+	foreign := exec.Command("/bin/sleep", "60")
+	foreign.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	require.NoError(t, foreign.Start())
+	defer foreign.Wait()
+	defer foreign.Process.Kill()
+
+	require.NoError(t, sv.Notify(main, "MAINPID="+strconv.Itoa(foreign.Process.Pid)))
+	assert.Equal(t, main, sv.MainPID(), "sv.MainPID after MAINPID of a foreign process")
+
+	// Child of the main process is
+	var child int
+	for i := 0; i < 100 && child == 0; i++ {
+		child, _ = readPIDFile(pidfile)
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NotZero(t, child, "PID of the child")
+	require.NoError(t, sv.Notify(main, "MAINPID="+strconv.Itoa(child)))
+	assert.Equal(t, child, sv.MainPID(), "sv.MainPID after MAINPID of a child")
+
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	assert.True(t, isAlive(foreign.Process.Pid), "foreign process was killed")
+}
+
 func TestActive(t *testing.T) {
 	// Oneshot service
-	sv := Unit{}
+	sv := &Unit{}
 	sv.Cmd = exec.Command("echo", "test")
 
 	sv.Definition.Service.Type = "oneshot"
 	sv.Definition.Service.RemainAfterExit = true
 	if assert.NoError(t, sv.Cmd.Run(), "oneshot Cmd.Run()") {
+		sv.main, sv.mainState = sv.Cmd, sv.Cmd.ProcessState
 		assert.Equal(t, unit.Active, sv.Active(), fmt.Sprintf("oneshot service - %s", sv.Active()))
 	}
 
 	// Simple service
-	sv = Unit{}
+	sv = &Unit{}
 	sv.Cmd = exec.Command("sleep", "60")
 	sv.Definition.Service.Type = "simple"
 	if assert.NoError(t, sv.Cmd.Start(), "simple Cmd.Run()") {
+		sv.main = sv.Cmd
 		assert.Equal(t, unit.Active, sv.Active(), fmt.Sprintf("simple service - %s", sv.Active()))
 	}
 	// TODO
@@ -87,7 +336,7 @@ func TestActive(t *testing.T) {
 	//sv = &Unit{}
 	//assert.Equal(t, unit.Reloading, sv.Active())
 
-	//sv = Unit{}
+	//sv = &Unit{}
 	//assert.Equal(t, unit.Inactive, sv.Active())
 
 }
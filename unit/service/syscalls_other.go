@@ -0,0 +1,11 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package service
+
+// System call filtering is not supported on this architecture
+const nativeArch = 0
+
+const x32SyscallBit = 0
+
+var syscallNumbers = map[string]int{}
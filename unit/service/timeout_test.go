@@ -0,0 +1,103 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeouts(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true`)), "sv.Define")
+	assert.Equal(t, DEFAULT_TIMEOUT_START_SEC, sv.Definition.Service.TimeoutStartSec)
+	assert.Equal(t, DEFAULT_TIMEOUT_STOP_SEC, sv.Definition.Service.TimeoutStopSec)
+	assert.Equal(t, DEFAULT_TIMEOUT_STOP_SEC, sv.Definition.Service.TimeoutAbortSec)
+
+	sv = &Unit{DefaultTimeoutStartSec: time.Minute}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+TimeoutSec=10s
+TimeoutStopSec=0`)), "sv.Define")
+	assert.Equal(t, 10*time.Second, sv.Definition.Service.TimeoutStartSec)
+	assert.Equal(t, unit.Infinity, sv.Definition.Service.TimeoutStopSec)
+	assert.Equal(t, unit.Infinity, sv.Definition.Service.TimeoutAbortSec)
+
+	sv = &Unit{DefaultTimeoutStartSec: time.Minute}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+TimeoutAbortSec=infinity`)), "sv.Define")
+	assert.Equal(t, time.Minute, sv.Definition.Service.TimeoutStartSec)
+	assert.Equal(t, unit.Infinity, sv.Definition.Service.TimeoutAbortSec)
+}
+
+func TestStartTimeout(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c "sleep 60 & sleep 60"
+TimeoutStartSec=300ms`)), "sv.Define")
+
+	begin := time.Now()
+	assert.Equal(t, ErrStartTimeout, sv.Start(), "sv.Start")
+	assert.True(t, time.Since(begin) < 5*time.Second, "start was not aborted in time")
+
+	assert.Equal(t, failed, sv.Sub(), "sv.Sub")
+	assert.Equal(t, resultTimeout, sv.Result(), "sv.Result")
+	assert.Empty(t, sv.processes(), "processes of the service are alive")
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStartPre=/bin/sleep 60
+ExecStart=/bin/sleep 60
+TimeoutStartSec=300ms`)), "sv.Define")
+
+	assert.Equal(t, ErrStartTimeout, sv.Start(), "sv.Start with hung ExecStartPre")
+	cmd, _ := sv.mainCmd()
+	assert.Nil(t, cmd, "main process started")
+}
+
+func TestStartTimeoutAbort(t *testing.T) {
+	// Processes ignoring SIGTERM are killed after TimeoutStopSec
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/sh -c 'trap "" TERM; while true; do sleep 0.1; done'
+TimeoutStartSec=200ms
+TimeoutStopSec=200ms
+TimeoutAbortSec=infinity`)), "sv.Define")
+
+	begin := time.Now()
+	assert.Equal(t, ErrStartTimeout, sv.Start(), "sv.Start")
+	assert.True(t, time.Since(begin) < 5*time.Second, "start was not aborted in time")
+	assert.Empty(t, sv.processes(), "processes of the service are alive")
+}
+
+func TestStartTimeoutPIDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-timeout-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// PID file is waited for for the rest of TimeoutStartSec
+	pidfile, script := filepath.Join(dir, "test.pid"), filepath.Join(dir, "fork.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte(`(sleep 1.5; sh -c 'echo $$ > `+pidfile+`; exec sleep 60') &`), 0644))
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=forking
+PIDFile=`+pidfile+`
+ExecStart=/bin/sh `+script+`
+TimeoutStartSec=5s`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+	assert.NotZero(t, sv.MainPID(), "sv.MainPID")
+	assert.Equal(t, running, sv.Sub(), "sv.Sub")
+	require.NoError(t, sv.Stop(), "sv.Stop")
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/plasma-umass/systemgo/unit"
+)
+
+// User and group databases
+var (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// Number of fields in an entry of the databases
+const (
+	passwdFields = 7
+	groupFields  = 4
+)
+
+// credentials of the processes of the service
+type credentials struct {
+	User, Home, Shell string
+	UID, GID          uint32
+	Groups            []uint32
+}
+
+// Credential returns the credentials in the form applicable to a child process
+func (c *credentials) Credential() *syscall.Credential {
+	return &syscall.Credential{
+		Uid:    c.UID,
+		Gid:    c.GID,
+		Groups: c.Groups,
+	}
+}
+
+// Env returns environment variables describing the user
+func (c *credentials) Env() (env []string) {
+	if c.User == "" {
+		return nil
+	}
+
+	env = []string{"USER=" + c.User, "LOGNAME=" + c.User}
+	if c.Home != "" {
+		env = append(env, "HOME="+c.Home)
+	}
+	if c.Shell != "" {
+		env = append(env, "SHELL="+c.Shell)
+	}
+	return
+}
+
+// resolveCredentials resolves User, Group and SupplementaryGroups specified in definition.
+// nil is returned if neither User nor Group are specified
+func (sv *Unit) resolveCredentials() (creds *credentials, err error) {
+	usr, grp := sv.Definition.Service.User, sv.Definition.Service.Group
+	if usr == "" && grp == "" {
+		return nil, nil
+	}
+
+	creds = &credentials{
+		UID: uint32(os.Getuid()),
+		GID: uint32(os.Getgid()),
+	}
+
+	if usr != "" {
+		var fields []string
+		if fields, err = lookupEntry(passwdPath, passwdFields, usr); err != nil {
+			return nil, unit.ParseErr("User", unit.ParseErr(usr, err))
+		}
+
+		if fields == nil {
+			// Numeric ID without an entry in the database
+			creds.UID, _ = parseID(usr)
+			creds.GID = creds.UID
+			creds.User = usr
+		} else {
+			creds.User, creds.Home, creds.Shell = fields[0], fields[5], fields[6]
+			if creds.UID, err = parseID(fields[2]); err != nil {
+				return nil, unit.ParseErr("User", unit.ParseErr(usr, err))
+			}
+			if creds.GID, err = parseID(fields[3]); err != nil {
+				return nil, unit.ParseErr("User", unit.ParseErr(usr, err))
+			}
+			if creds.Groups, err = memberOf(creds.User); err != nil {
+				return nil, unit.ParseErr("User", unit.ParseErr(usr, err))
+			}
+		}
+	}
+
+	if grp != "" {
+		if creds.GID, err = lookupGroup(grp); err != nil {
+			return nil, unit.ParseErr("Group", unit.ParseErr(grp, err))
+		}
+	}
+
+	for _, line := range sv.Definition.Service.SupplementaryGroups {
+		for _, name := range strings.Fields(line) {
+			var gid uint32
+			if gid, err = lookupGroup(name); err != nil {
+				return nil, unit.ParseErr("SupplementaryGroups", unit.ParseErr(name, err))
+			}
+			creds.Groups = append(creds.Groups, gid)
+		}
+	}
+
+	if creds.Groups == nil {
+		// Do not inherit supplementary groups of the manager
+		creds.Groups = []uint32{}
+	}
+	return creds, nil
+}
+
+// credential returns the credentials c is run with, nil meaning the credentials of the manager
+func (sv *Unit) credential(c unit.ExecCommand) *syscall.Credential {
+	if sv.creds == nil || c.FullPrivileges || c.NoSetCredentials {
+		return nil
+	}
+	return sv.creds.Credential()
+}
+
+// lookupGroup returns the GID of group specified by name or numeric ID
+func lookupGroup(name string) (gid uint32, err error) {
+	var fields []string
+	if fields, err = lookupEntry(groupPath, groupFields, name); err != nil {
+		return
+	}
+	if fields == nil {
+		return parseID(name)
+	}
+	return parseID(fields[2])
+}
+
+// memberOf returns GIDs of the groups user is listed as a member of
+func memberOf(user string) (gids []uint32, err error) {
+	err = scanEntries(groupPath, groupFields, func(fields []string) bool {
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != user {
+				continue
+			}
+			if gid, err := parseID(fields[2]); err == nil {
+				gids = append(gids, gid)
+			}
+		}
+		return false
+	})
+	return
+}
+
+// lookupEntry returns the fields of the entry of database at path with name
+// or ID specified. If there is no such entry, ErrNotExist is returned, unless
+// name is a numeric ID, in which case nil fields are returned
+func lookupEntry(path string, nfields int, name string) (fields []string, err error) {
+	_, numErr := parseID(name)
+
+	err = scanEntries(path, nfields, func(f []string) bool {
+		if f[0] == name || numErr == nil && f[2] == name {
+			fields = f
+			return true
+		}
+		return false
+	})
+	switch {
+	case err != nil && !(os.IsNotExist(err) && numErr == nil):
+		return nil, err
+	case fields == nil && numErr != nil:
+		return nil, unit.ErrNotExist
+	default:
+		return fields, nil
+	}
+}
+
+// scanEntries calls fn with the fields of each entry of database at path, which consists
+// of nfields fields, until fn returns true
+func scanEntries(path string, nfields int, fn func(fields []string) bool) (err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != nfields {
+			continue
+		}
+
+		if fn(fields) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-user")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	require.NoError(t, ioutil.WriteFile(passwd, []byte(`# comment
+root:x:0:0:root:/root:/bin/bash
+test:x:1000:1000:Test:/home/test:/bin/sh
+`), 0644))
+	require.NoError(t, ioutil.WriteFile(group, []byte(`root:x:0:
+test:x:1000:
+wheel:x:10:root,test
+audio:x:29:test
+`), 0644))
+
+	defer func(p, g string) { passwdPath, groupPath = p, g }(passwdPath, groupPath)
+	passwdPath, groupPath = passwd, group
+
+	sv := Unit{}
+	creds, err := sv.resolveCredentials()
+	assert.NoError(t, err)
+	assert.Nil(t, creds, "credentials without User and Group")
+
+	sv.Definition.Service.User = "test"
+	if creds, err = sv.resolveCredentials(); assert.NoError(t, err) {
+		assert.Equal(t, &credentials{
+			User:   "test",
+			Home:   "/home/test",
+			Shell:  "/bin/sh",
+			UID:    1000,
+			GID:    1000,
+			Groups: []uint32{10, 29},
+		}, creds)
+		assert.Equal(t, []string{"USER=test", "LOGNAME=test", "HOME=/home/test", "SHELL=/bin/sh"}, creds.Env())
+	}
+
+	sv.Definition.Service.User = "1000"
+	sv.Definition.Service.Group = "wheel"
+	sv.Definition.Service.SupplementaryGroups = []string{"root 42"}
+	if creds, err = sv.resolveCredentials(); assert.NoError(t, err) {
+		assert.Equal(t, "test", creds.User)
+		assert.Equal(t, uint32(10), creds.GID)
+		assert.Equal(t, []uint32{10, 29, 0, 42}, creds.Groups)
+	}
+
+	sv.Definition.Service.User = "4242"
+	sv.Definition.Service.Group = ""
+	sv.Definition.Service.SupplementaryGroups = nil
+	if creds, err = sv.resolveCredentials(); assert.NoError(t, err) {
+		assert.Equal(t, &credentials{User: "4242", UID: 4242, GID: 4242, Groups: []uint32{}}, creds)
+	}
+
+	sv.Definition.Service.User = "nonexistent"
+	_, err = sv.resolveCredentials()
+	assert.Error(t, err, "nonexistent user")
+
+	sv.Definition.Service.User = ""
+	sv.Definition.Service.Group = "nonexistent"
+	_, err = sv.resolveCredentials()
+	assert.Error(t, err, "nonexistent group")
+}
+
+func TestStartNonexistentUser(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/true
+User=systemgo-nonexistent`)), "sv.Define")
+
+	var logged []string
+	sv.SetOutputLog(func(priority int, identifier, line string) {
+		logged = append(logged, line)
+	})
+
+	assert.Error(t, sv.Start(), "sv.Start")
+	assert.Equal(t, failed, sv.Sub(), "sv.Sub")
+	if assert.Len(t, logged, 1, "unit log") {
+		assert.Contains(t, logged[0], "Failed to determine user credentials")
+	}
+}
+
+func TestStartUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing credentials requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "systemgo-user")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Chmod(dir, 0777))
+
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+Type=oneshot
+ExecStart=/bin/touch `+filepath.Join(dir, "user")+`
+ExecStartPost=+/bin/touch `+filepath.Join(dir, "root")+`
+User=4242
+Group=4243`)), "sv.Define")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	fi, err := os.Stat(filepath.Join(dir, "user"))
+	if assert.NoError(t, err) {
+		st := fi.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(4242), st.Uid)
+		assert.Equal(t, uint32(4243), st.Gid)
+	}
+
+	fi, err = os.Stat(filepath.Join(dir, "root"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint32(0), fi.Sys().(*syscall.Stat_t).Uid)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const DEFAULT_WATCHDOG_SIGNAL = "SIGABRT"
+
+// watchdogTimeout returns the time the service has to send a keep-alive within,
+// 0 if the watchdog is disabled. sv.mutex must be held by the caller
+func (sv *Unit) watchdogTimeout() time.Duration {
+	timeout := sv.Definition.Service.WatchdogSec
+	if sv.watchdogOverride > 0 {
+		timeout = sv.watchdogOverride
+	}
+	if timeout == unit.Infinity {
+		return 0
+	}
+	return timeout
+}
+
+// watchdogEnv returns the environment variables describing the watchdog.
+// WATCHDOG_PID is set by the sandbox helper right before the main process
+// is executed, since its PID is not known before it is started
+func (sv *Unit) watchdogEnv() []string {
+	timeout := sv.Definition.Service.WatchdogSec
+	if timeout <= 0 || timeout == unit.Infinity {
+		return nil
+	}
+	return []string{"WATCHDOG_USEC=" + strconv.FormatInt(int64(timeout/time.Microsecond), 10)}
+}
+
+// startWatchdog starts the watchdog of the main process started by cmd, if enabled
+func (sv *Unit) startWatchdog(cmd *exec.Cmd) {
+	sv.mutex.Lock()
+	defer sv.mutex.Unlock()
+
+	sv.stopWatchdog()
+
+	timeout := sv.watchdogTimeout()
+	if timeout <= 0 {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		// timer is assigned with sv.mutex held
+		sv.mutex.Lock()
+		t := timer
+		sv.mutex.Unlock()
+		sv.watchdogExpired(cmd, t)
+	})
+	sv.watchdogTimer = timer
+}
+
+// stopWatchdog stops the watchdog, if running. sv.mutex must be held by the caller
+func (sv *Unit) stopWatchdog() {
+	if sv.watchdogTimer != nil {
+		sv.watchdogTimer.Stop()
+		sv.watchdogTimer = nil
+	}
+}
+
+// pingWatchdog resets the watchdog timer. sv.mutex must be held by the caller
+func (sv *Unit) pingWatchdog() {
+	if sv.watchdogTimer == nil {
+		return
+	}
+	if timeout := sv.watchdogTimeout(); timeout > 0 {
+		sv.watchdogTimer.Reset(timeout)
+	} else {
+		sv.stopWatchdog()
+	}
+}
+
+// watchdogExpired handles the expiry of timer started for the main process started by cmd
+func (sv *Unit) watchdogExpired(cmd *exec.Cmd, timer *time.Timer) {
+	sv.mutex.Lock()
+	if timer != sv.watchdogTimer || cmd != sv.main || sv.stopping {
+		// Watchdog was stopped or restarted in the meantime
+		sv.mutex.Unlock()
+		return
+	}
+	sv.watchdogTimer = nil
+	sv.watchdogFired = true
+	sv.watchdogDone = make(chan struct{})
+	done := sv.watchdogDone
+	sv.mutex.Unlock()
+
+	defer close(done)
+
+	log.WithField("ExecStart", sv.Definition.Service.ExecStart).Warn("Watchdog timeout, terminating")
+
+	sig, err := parseSignal(sv.Definition.Service.WatchdogSignal)
+	if err != nil {
+		sig = syscall.SIGABRT
+	}
+	sv.terminateWith(sig, stopSigabrt, stopSigkill, sv.Definition.Service.TimeoutAbortSec)
+}
+
+// triggerWatchdog makes the watchdog expire immediately. sv.mutex must be held by the caller
+func (sv *Unit) triggerWatchdog() {
+	if timer := sv.watchdogTimer; timer != nil && timer.Stop() {
+		go sv.watchdogExpired(sv.main, timer)
+	}
+}
+
+// watchdogResult reports whether the main process was terminated by the watchdog and,
+// if so, waits for the termination to complete
+func (sv *Unit) watchdogResult() bool {
+	sv.mutex.Lock()
+	fired, done := sv.watchdogFired, sv.watchdogDone
+	sv.mutex.Unlock()
+
+	if fired && done != nil {
+		<-done
+	}
+	return fired
+}
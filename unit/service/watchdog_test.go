@@ -0,0 +1,119 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdogEnv(t *testing.T) {
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 1
+WatchdogSec=2s`)), "sv.Define")
+
+	env, err := sv.environment()
+	require.NoError(t, err, "sv.environment")
+	assert.Contains(t, env, "WATCHDOG_USEC=2000000")
+
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 1`)), "sv.Define")
+
+	env, err = sv.environment()
+	require.NoError(t, err, "sv.environment")
+	for _, kv := range env {
+		assert.False(t, strings.HasPrefix(kv, "WATCHDOG_"), kv)
+	}
+
+	sv = &Unit{}
+	assert.Error(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 1
+WatchdogSignal=SIGFOO`)), "invalid WatchdogSignal")
+}
+
+func TestWatchdogPID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemgo-watchdog-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sh -c 'echo $WATCHDOG_PID > `+out+`; exec sleep 10'
+ExecStartPost=/bin/sh -c 'while [ ! -s `+out+` ]; do sleep 0.01; done; echo $WATCHDOG_PID >> `+out+`'
+WatchdogSec=10s`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+	defer sv.Stop()
+
+	// Only the main process is told its PID
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(sv.MainPID())+"\n\n", string(b))
+}
+
+func TestWatchdog(t *testing.T) {
+	// Service sends keep-alives and is not terminated
+	sv := &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+WatchdogSec=300ms
+NotifyAccess=main`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, sv.Notify(sv.MainPID(), "WATCHDOG=1"), "sv.Notify")
+	}
+	assert.Equal(t, running, sv.Sub(), "sv.Sub")
+	require.NoError(t, sv.Stop(), "sv.Stop")
+	assert.NotEqual(t, resultWatchdog, sv.Result(), "sv.Result")
+
+	// Service does not send keep-alives and is terminated with WatchdogSignal
+	sv = &Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+WatchdogSec=200ms
+Restart=on-watchdog
+RestartSec=10ms`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	select {
+	case <-sv.AutoRestart():
+		assert.Equal(t, resultWatchdog, sv.Result(), "sv.Result")
+		assert.Empty(t, sv.processes(), "sv.processes")
+	case <-time.After(2 * time.Second):
+		t.Error("Service was not restarted after watchdog timeout")
+	}
+	require.NoError(t, sv.Stop(), "sv.Stop")
+}
+
+func TestWatchdogNotify(t *testing.T) {
+	sv := Unit{}
+	require.NoError(t, sv.Define(strings.NewReader(`[Service]
+ExecStart=/bin/sleep 10
+WatchdogSec=10s
+NotifyAccess=main`)), "sv.Define")
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	// Timeout can be changed by the service
+	require.NoError(t, sv.Notify(sv.MainPID(), "WATCHDOG_USEC=200000"), "sv.Notify")
+	time.Sleep(time.Second)
+	assert.Equal(t, failed, sv.Sub(), "WATCHDOG_USEC")
+	assert.Equal(t, resultWatchdog, sv.Result(), "sv.Result")
+
+	require.NoError(t, sv.Start(), "sv.Start")
+
+	// Timeout can be triggered by the service
+	require.NoError(t, sv.Notify(sv.MainPID(), "WATCHDOG=trigger"), "sv.Notify")
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, failed, sv.Sub(), "WATCHDOG=trigger")
+	assert.Equal(t, resultWatchdog, sv.Result(), "sv.Result")
+}
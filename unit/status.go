@@ -6,8 +6,51 @@ type Status struct {
 	Load       LoadStatus       `json:"Load"`
 	Activation ActivationStatus `json:"Activation"`
 
+	// PID of the main process, 0 if none
+	MainPID int `json:"MainPID,omitempty"`
+
+	// Status text reported by the unit
+	Text string `json:"Text,omitempty"`
+
+	// Number of automatic restarts of the unit
+	Restarts int `json:"Restarts,omitempty"`
+
+	// Result of the last run of the unit, e.g. "success" or "exit-code", empty if unknown
+	Result string `json:"Result,omitempty"`
+
+	// Exit status of the main process, nil if it has not exited
+	MainExit *ExitStatus `json:"MainExit,omitempty"`
+
+	// Condition and assertion, which failed on the last start, empty if none
+	Condition string `json:"Condition,omitempty"`
+	Assert    string `json:"Assert,omitempty"`
+
 	Log []byte `json:"Log,omitempty"`
 }
+
+// ExitStatus describes how a process exited
+type ExitStatus struct {
+	// Exit code of the process, if it exited normally
+	Code int `json:"Code"`
+
+	// Name of the signal the process was terminated by, empty if it exited normally
+	Signal string `json:"Signal,omitempty"`
+
+	// Whether the process dumped core
+	CoreDump bool `json:"CoreDump,omitempty"`
+}
+
+func (st ExitStatus) String() string {
+	switch {
+	case st.Signal == "":
+		return fmt.Sprintf("code=exited, status=%d", st.Code)
+	case st.CoreDump:
+		return fmt.Sprintf("code=dumped, signal=%s", st.Signal)
+	default:
+		return fmt.Sprintf("code=killed, signal=%s", st.Signal)
+	}
+}
+
 type ActivationStatus struct {
 	State Activation `json:"State"`
 	Sub   string     `json:"Sub"`
@@ -21,6 +64,27 @@ type LoadStatus struct {
 
 func (s Status) String() (out string) {
 	defer func() {
+		if s.MainPID > 0 {
+			out += fmt.Sprintf("\nMain PID: %d", s.MainPID)
+		}
+		if s.MainExit != nil {
+			out += fmt.Sprintf("\nMain process exited: %s", s.MainExit)
+		}
+		if s.Result != "" {
+			out += fmt.Sprintf("\nResult: %s", s.Result)
+		}
+		if s.Condition != "" {
+			out += fmt.Sprintf("\nCondition: start condition failed (%s was not met)", s.Condition)
+		}
+		if s.Assert != "" {
+			out += fmt.Sprintf("\nAssert: start assertion failed (%s was not met)", s.Assert)
+		}
+		if s.Text != "" {
+			out += fmt.Sprintf("\nStatus: %q", s.Text)
+		}
+		if s.Restarts > 0 {
+			out += fmt.Sprintf("\nRestarts: %d", s.Restarts)
+		}
 		if len(s.Log) > 0 {
 			out += fmt.Sprintf("\nLog:\n%s", s.Log)
 		}
@@ -36,4 +36,23 @@ Log:
 	)
 
 	assert.Equal(t, st.String(), expected)
+
+	st.Log = nil
+	st.MainPID = 42
+	assert.Contains(t, st.String(), "\nMain PID: 42")
+}
+
+func TestExitStatus(t *testing.T) {
+	for expected, st := range map[string]unit.ExitStatus{
+		"code=exited, status=0":       {},
+		"code=exited, status=3":       {Code: 3},
+		"code=killed, signal=SIGTERM": {Signal: "SIGTERM"},
+		"code=dumped, signal=SIGSEGV": {Signal: "SIGSEGV", CoreDump: true},
+	} {
+		assert.Equal(t, expected, st.String())
+	}
+
+	st := unit.Status{Result: "exit-code", MainExit: &unit.ExitStatus{Code: 1}}
+	assert.Contains(t, st.String(), "\nMain process exited: code=exited, status=1")
+	assert.Contains(t, st.String(), "\nResult: exit-code")
 }
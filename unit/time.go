@@ -0,0 +1,89 @@
+package unit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Infinity represents a time span, which never elapses
+const Infinity time.Duration = math.MaxInt64
+
+var ErrWrongTimespan = errors.New("Wrong time span format")
+
+// Time span units as accepted by Systemd (see systemd.time(7))
+var timespanUnits = map[string]time.Duration{
+	"us":      time.Microsecond,
+	"usec":    time.Microsecond,
+	"ms":      time.Millisecond,
+	"msec":    time.Millisecond,
+	"s":       time.Second,
+	"sec":     time.Second,
+	"second":  time.Second,
+	"seconds": time.Second,
+	"m":       time.Minute,
+	"min":     time.Minute,
+	"minute":  time.Minute,
+	"minutes": time.Minute,
+	"h":       time.Hour,
+	"hr":      time.Hour,
+	"hour":    time.Hour,
+	"hours":   time.Hour,
+	"d":       24 * time.Hour,
+	"day":     24 * time.Hour,
+	"days":    24 * time.Hour,
+	"w":       7 * 24 * time.Hour,
+	"week":    7 * 24 * time.Hour,
+	"weeks":   7 * 24 * time.Hour,
+}
+
+// ParseTimespan parses a time span in Systemd format, e.g. "5min 20s" or "100ms".
+// Values without a unit are interpreted as seconds, "infinity" is parsed as Infinity
+func ParseTimespan(s string) (d time.Duration, err error) {
+	s = strings.TrimSpace(s)
+
+	switch s {
+	case "":
+		return 0, ErrWrongTimespan
+	case "infinity":
+		return Infinity, nil
+	}
+
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return !unicode.IsDigit(r) && r != '.'
+		})
+		if i <= 0 {
+			return 0, ErrWrongTimespan
+		}
+
+		var n float64
+		if n, err = strconv.ParseFloat(s[:i], 64); err != nil {
+			return 0, ErrWrongTimespan
+		}
+		s = strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+
+		j := strings.IndexFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r)
+		})
+		if j < 0 {
+			j = len(s)
+		}
+
+		unit, ok := timespanUnits[s[:j]]
+		if !ok {
+			return 0, ErrWrongTimespan
+		}
+		s = strings.TrimLeftFunc(s[j:], unicode.IsSpace)
+
+		d += time.Duration(n * float64(unit))
+	}
+	return
+}
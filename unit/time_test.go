@@ -0,0 +1,34 @@
+package unit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/plasma-umass/systemgo/unit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTimespan(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"5":          5 * time.Second,
+		"100ms":      100 * time.Millisecond,
+		"1.5s":       1500 * time.Millisecond,
+		"2min 20s":   2*time.Minute + 20*time.Second,
+		"1h30min":    time.Hour + 30*time.Minute,
+		"3 days":     3 * 24 * time.Hour,
+		"20us":       20 * time.Microsecond,
+		"infinity":   unit.Infinity,
+		" 10sec   ":  10 * time.Second,
+		"1w 1d 1min": 8*24*time.Hour + time.Minute,
+	} {
+		d, err := unit.ParseTimespan(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, d, s)
+		}
+	}
+
+	for _, s := range []string{"", "s", "5 parsecs", "-5s", "1.2.3s", "infinit"} {
+		_, err := unit.ParseTimespan(s)
+		assert.Equal(t, unit.ErrWrongTimespan, err, s)
+	}
+}
@@ -0,0 +1,141 @@
+package unit
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Values accepted by ConditionVirtualization= other than booleans
+var virtualizations = map[string]bool{
+	"vm": true, "container": true,
+
+	"qemu": true, "kvm": true, "amazon": true, "zvm": true, "vmware": true,
+	"microsoft": true, "oracle": true, "powervm": true, "xen": true, "bochs": true,
+	"uml": true, "parallels": true, "bhyve": true, "qnx": true, "acrn": true,
+	"apple": true, "sre": true, "google": true, "vm-other": true,
+
+	"openvz": true, "lxc": true, "lxc-libvirt": true, "systemd-nspawn": true,
+	"docker": true, "podman": true, "rkt": true, "wsl": true, "proot": true,
+	"pouch": true, "container-other": true,
+}
+
+// Paths virtualization is detected by
+var (
+	containerPath  = "/run/systemd/container"
+	environPath    = "/proc/1/environ"
+	cpuinfoPath    = "/proc/cpuinfo"
+	dmiVendorPaths = []string{"/sys/class/dmi/id/sys_vendor", "/sys/class/dmi/id/product_name", "/sys/class/dmi/id/board_vendor"}
+	hypervisorPath = "/sys/hypervisor/type"
+)
+
+// Hypervisors identified by the prefixes of DMI vendor and product strings
+var dmiVendors = []struct {
+	prefix, name string
+}{
+	{"KVM", "kvm"},
+	{"OpenStack", "kvm"},
+	{"KubeVirt", "kvm"},
+	{"Amazon EC2", "amazon"},
+	{"QEMU", "qemu"},
+	{"VMware", "vmware"},
+	{"VMW", "vmware"},
+	{"innotek GmbH", "oracle"},
+	{"VirtualBox", "oracle"},
+	{"Oracle Corporation", "oracle"},
+	{"Xen", "xen"},
+	{"Bochs", "bochs"},
+	{"Parallels", "parallels"},
+	{"BHYVE", "bhyve"},
+	{"Hyper-V", "microsoft"},
+	{"Microsoft Corporation", "microsoft"},
+	{"Apple Virtualization", "apple"},
+	{"Google Compute Engine", "google"},
+}
+
+// detectContainer returns the name of the container technology the system runs in, empty if none
+func detectContainer() string {
+	if b, err := ioutil.ReadFile(containerPath); err == nil {
+		if name := strings.TrimSpace(string(b)); name != "" {
+			return name
+		}
+	}
+
+	if b, err := ioutil.ReadFile(environPath); err == nil {
+		for _, kv := range bytes.Split(b, []byte{0}) {
+			if bytes.HasPrefix(kv, []byte("container=")) {
+				if name := string(kv[len("container="):]); name != "" {
+					return name
+				}
+			}
+		}
+	}
+
+	for path, name := range map[string]string{
+		"/.dockerenv":        "docker",
+		"/run/.containerenv": "podman",
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// detectVM returns the name of the hypervisor the system runs on, empty if none
+func detectVM() string {
+	for _, path := range dmiVendorPaths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, v := range dmiVendors {
+			if strings.HasPrefix(string(b), v.prefix) {
+				return v.name
+			}
+		}
+	}
+
+	if b, err := ioutil.ReadFile(hypervisorPath); err == nil && strings.TrimSpace(string(b)) == "xen" {
+		return "xen"
+	}
+
+	if f, err := os.Open(cpuinfoPath); err == nil {
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if line := s.Text(); strings.HasPrefix(line, "flags") {
+				for _, flag := range strings.Fields(line) {
+					if flag == "hypervisor" {
+						return "vm-other"
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Virtualization returns the kind("vm" or "container") and the name of the
+// virtualization technology the system runs in, empty strings if none is detected
+func Virtualization() (kind, name string) {
+	if name = detectContainer(); name != "" {
+		return "container", name
+	}
+	if name = detectVM(); name != "" {
+		return "vm", name
+	}
+	return "", ""
+}
+
+func checkVirtualization(value string) bool {
+	kind, name := Virtualization()
+
+	if b, err := parseBoolean(value); err == nil {
+		return b == (kind != "")
+	}
+	return value == kind || value == name
+}